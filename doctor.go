@@ -0,0 +1,275 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"terraform-provider-packer/packer_interop"
+	"terraform-provider-packer/provider"
+
+	"github.com/hashicorp/packer-plugin-sdk/pathing"
+	"github.com/hashicorp/packer/command"
+	packerversion "github.com/hashicorp/packer/version"
+)
+
+const doctorUsage = "usage: terraform-provider-packer doctor [-plugin-directory dir] [-cache-dir dir] [dir]"
+
+// doctorReport writes the findings of `doctor` and remembers whether any
+// of them is fatal.
+type doctorReport struct {
+	w      io.Writer
+	failed bool
+}
+
+func (r *doctorReport) section(title string) {
+	_, _ = fmt.Fprintf(r.w, "\n%s\n", title)
+}
+
+func (r *doctorReport) info(format string, args ...any) {
+	_, _ = fmt.Fprintf(r.w, "  %s\n", fmt.Sprintf(format, args...))
+}
+
+func (r *doctorReport) ok(format string, args ...any) {
+	r.info("[ok]   "+format, args...)
+}
+
+func (r *doctorReport) warn(format string, args ...any) {
+	r.info("[warn] "+format, args...)
+}
+
+func (r *doctorReport) fail(format string, args ...any) {
+	r.failed = true
+	r.info("[fail] "+format, args...)
+}
+
+// runDoctor implements `terraform-provider-packer doctor [dir]`: it reports
+// on the embedded Packer, the download cache, installed plugins and the
+// environment, and validates the templates in dir. -plugin-directory takes
+// the provider's plugin_directory and -cache-dir its packer_binary_cache_dir.
+// It returns the exit code.
+func runDoctor(w io.Writer, args []string) int {
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	flags.Usage = func() { _, _ = fmt.Fprintln(os.Stderr, doctorUsage) }
+	pluginDir := flags.String("plugin-directory", "", "the provider's plugin_directory")
+	cacheDir := flags.String("cache-dir", "", "the provider's packer_binary_cache_dir")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 1 {
+		if err == nil {
+			flags.Usage()
+		}
+		return 2
+	}
+	dir := "."
+	if flags.NArg() == 1 {
+		dir = flags.Arg(0)
+	}
+	if *pluginDir != "" {
+		abs, err := filepath.Abs(*pluginDir)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "invalid plugin directory: %v\n", err)
+			return 2
+		}
+		packer_interop.SetPluginDirectory(abs)
+	}
+
+	r := &doctorReport{w: w}
+	_, _ = fmt.Fprintln(w, embeddedPackerNotice())
+	doctorEmbeddedPacker(r)
+	doctorDownloadCache(r, *cacheDir)
+	doctorPlugins(r, *cacheDir)
+	doctorEnvironment(r)
+	doctorValidate(r, dir)
+
+	if r.failed {
+		_, _ = fmt.Fprintln(w, "\nSome checks failed.")
+		return 1
+	}
+	_, _ = fmt.Fprintln(w, "\nAll checks passed.")
+	return 0
+}
+
+func doctorEmbeddedPacker(r *doctorReport) {
+	r.section("Embedded Packer")
+	r.info("version: %s", packerversion.FormattedVersion())
+	r.info("builders: %s", componentNames(command.Builders))
+	r.info("provisioners: %s", componentNames(command.Provisioners))
+	r.info("post-processors: %s", componentNames(command.PostProcessors))
+	r.info("data sources: %s", componentNames(command.Datasources))
+}
+
+func componentNames[T any](components map[string]T) string {
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func doctorDownloadCache(r *doctorReport, cacheDir string) {
+	r.section("Download cache")
+	base, entries, err := provider.DownloadCache(cacheDir)
+	r.info("directory: %s", base)
+	if err != nil {
+		r.fail("could not list the cache: %v", err)
+		return
+	}
+	if len(entries) == 0 {
+		r.info("no cached binaries")
+		return
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+		source := e.Source
+		if source == "" {
+			source = "unknown source"
+		}
+		line := fmt.Sprintf("%s (%s, %.1f MB, last used %s)",
+			filepath.Base(e.Dir), source, float64(e.Size)/(1024*1024), e.LastUsed.Format("2006-01-02 15:04"))
+		if e.Problem != "" {
+			r.warn("%s: %s", line, e.Problem)
+		} else {
+			r.ok("%s", line)
+		}
+	}
+	r.info("%d entries, %.1f MB in total", len(entries), float64(total)/(1024*1024))
+}
+
+// pluginDirectories returns the directories Packer loads plugins from.
+func pluginDirectories() ([]string, error) {
+	if dir := packer_interop.PluginDirectory(); dir != "" {
+		return []string{dir}, nil
+	}
+	if env := os.Getenv("PACKER_PLUGIN_PATH"); env != "" {
+		return filepath.SplitList(env), nil
+	}
+	configDir, err := pathing.ConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	return []string{filepath.Join(configDir, "plugins")}, nil
+}
+
+func doctorPlugins(r *doctorReport, cacheDir string) {
+	r.section("Plugins")
+	dirs, err := pluginDirectories()
+	if err != nil {
+		r.fail("could not determine the plugin directory: %v", err)
+		return
+	}
+	isolated, err := provider.IsolatedPluginDirectories(cacheDir)
+	if err != nil {
+		r.warn("could not list the plugin directories of isolated images: %v", err)
+	}
+	for _, dir := range append(dirs, isolated...) {
+		r.info("directory: %s", dir)
+		if _, err := os.Stat(dir); err != nil {
+			if os.IsNotExist(err) {
+				r.info("does not exist; `packer init` creates it")
+			} else {
+				r.warn("%v", err)
+			}
+			continue
+		}
+		var plugins []string
+		err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && strings.HasPrefix(d.Name(), "packer-plugin-") && !strings.HasSuffix(d.Name(), "_SHA256SUM") {
+				rel, _ := filepath.Rel(dir, p)
+				plugins = append(plugins, rel)
+			}
+			return nil
+		})
+		if err != nil {
+			r.warn("could not list plugins: %v", err)
+			continue
+		}
+		if len(plugins) == 0 {
+			r.info("no plugins installed")
+		}
+		for _, plugin := range plugins {
+			r.info("%s", plugin)
+		}
+	}
+}
+
+func doctorEnvironment(r *doctorReport) {
+	r.section("Environment")
+	if home, err := os.UserHomeDir(); err != nil {
+		r.fail("home directory unknown (%v); Packer cannot locate its configuration and plugins", err)
+	} else {
+		r.ok("home directory: %s", home)
+	}
+
+	tmp := os.TempDir()
+	if f, err := os.CreateTemp(tmp, "tpp-doctor-*"); err != nil {
+		r.fail("temporary directory %s is not writable: %v", tmp, err)
+	} else {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		r.ok("temporary directory %s is writable", tmp)
+	}
+
+	if exe, err := os.Executable(); err != nil {
+		r.fail("could not locate the provider executable: %v", err)
+	} else {
+		r.ok("provider executable: %s", exe)
+	}
+
+	for _, name := range []string{"PACKER_PLUGIN_PATH", "PACKER_CONFIG_DIR", "PACKER_CACHE_DIR", "PACKER_LOG", "TMPDIR"} {
+		if v, ok := os.LookupEnv(name); ok {
+			r.info("%s=%s", name, v)
+		}
+	}
+}
+
+func doctorValidate(r *doctorReport, dir string) {
+	r.section("Template validation")
+	r.info("directory: %s", dir)
+	var templates []string
+	for _, pattern := range []string{"*.pkr.hcl", "*.pkr.json"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			r.fail("%v", err)
+			return
+		}
+		templates = append(templates, matches...)
+	}
+	if len(templates) == 0 {
+		r.info("no Packer templates found; pass a directory containing *.pkr.hcl files")
+		return
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		r.fail("could not locate the provider executable: %v", err)
+		return
+	}
+	cmd := exec.Command(exe, "validate", ".")
+	cmd.Dir = dir
+	for key, value := range packer_interop.EnvVars(map[string]string{}, true) {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
+	}
+	out, err := cmd.CombinedOutput()
+	out = []byte(strings.TrimPrefix(string(out), embeddedPackerNotice()+"\n"))
+	if err != nil {
+		// Variables are usually set by packer_image, so a failure may
+		// be expected here; the output tells.
+		r.warn("packer validate failed (%v); variables set by packer_image are unset here", err)
+	} else {
+		r.ok("packer validate succeeded")
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line != "" {
+			r.info("  %s", line)
+		}
+	}
+}
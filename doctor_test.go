@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// cacheEntry stores binary as a download cache entry named name in
+// cacheDir, recording digest as the sha256 it was verified with.
+func cacheEntry(t *testing.T, cacheDir string, name string, binary []byte, digest []byte) {
+	t.Helper()
+	dir := filepath.Join(cacheDir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	bin := "packer"
+	if runtime.GOOS == "windows" {
+		bin = "packer.exe"
+	}
+	if err := os.WriteFile(filepath.Join(dir, bin), binary, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(digest)
+	meta, err := json.Marshal(map[string]string{"source": "https://example.com/" + name, "binary_sha256": hex.EncodeToString(sum[:])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "metadata.json"), meta, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDoctorInspectsCacheDir(t *testing.T) {
+	t.Setenv("PACKER_PLUGIN_PATH", t.TempDir())
+	cacheDir := t.TempDir()
+	cacheEntry(t, cacheDir, "valid", []byte("packer"), []byte("packer"))
+	cacheEntry(t, cacheDir, "corrupt", []byte("tampered"), []byte("packer"))
+	isolated := filepath.Join(cacheDir, "isolated-plugins", "image-1", "github.com", "hashicorp", "docker")
+	if err := os.MkdirAll(isolated, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(isolated, "packer-plugin-docker_v1.0.9_x5.0_linux_amd64"), nil, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	runDoctor(&out, []string{"-cache-dir", cacheDir, t.TempDir()})
+	report := out.String()
+	for _, want := range []string{
+		"directory: " + cacheDir + "\n",
+		"[ok]   valid (https://example.com/valid,",
+		"[warn] corrupt (https://example.com/corrupt,",
+		"was modified",
+		"2 entries,",
+		"directory: " + filepath.Join(cacheDir, "isolated-plugins", "image-1") + "\n",
+		filepath.Join("github.com", "hashicorp", "docker", "packer-plugin-docker_v1.0.9_x5.0_linux_amd64"),
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report should contain %q:\n%s", want, report)
+		}
+	}
+}
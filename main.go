@@ -19,18 +19,35 @@ import (
 
 func main() {
 	if os.Getenv(packer_interop.TPPRunPacker) == "true" {
-		args := os.Args[1:]
-		if !suppressEmbeddedPackerNotice(args) {
-			// stderr only: the provider parses the output of some
-			// Packer invocations.
-			_, _ = fmt.Fprintln(os.Stderr, embeddedPackerNotice())
-		}
-		os.Exit(packer.Main(args))
-	} else {
-		if err := providerserver.Serve(context.Background(), provider.New, providerserver.ServeOpts{
-			Address: "registry.terraform.io/toowoxx/packer",
-		}); err != nil {
-			log.Fatal(err)
+		runEmbeddedPacker(os.Args[1:])
+	}
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "packer":
+			// Packer re-executes itself with os.Args, e.g. to catch
+			// panics; the children must start as Packer with its
+			// arguments as well.
+			_ = os.Setenv(packer_interop.TPPRunPacker, "true")
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			runEmbeddedPacker(os.Args[1:])
+		case "doctor":
+			os.Exit(runDoctor(os.Stdout, os.Args[2:]))
 		}
 	}
+	err := providerserver.Serve(context.Background(), provider.New, providerserver.ServeOpts{
+		Address: "registry.terraform.io/toowoxx/packer",
+	})
+	provider.RemoveUncachedDownloads()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runEmbeddedPacker(args []string) {
+	if !suppressEmbeddedPackerNotice(args) {
+		// stderr only: the provider parses the output of some
+		// Packer invocations.
+		_, _ = fmt.Fprintln(os.Stderr, embeddedPackerNotice())
+	}
+	os.Exit(packer.Main(args))
 }
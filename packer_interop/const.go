@@ -1,6 +1,7 @@
 package packer_interop
 
 const (
-	TPPRunPacker    = "TPP_RUN_PACKER"
-	TPPManifestPath = "TPP_MANIFEST_PATH"
+	TPPRunPacker     = "TPP_RUN_PACKER"
+	TPPManifestPath  = "TPP_MANIFEST_PATH"
+	PackerPluginPath = "PACKER_PLUGIN_PATH"
 )
@@ -3,10 +3,32 @@ package packer_interop
 import (
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/google/uuid"
 )
 
+var (
+	pluginDirectoryMu sync.RWMutex
+	pluginDirectory   string
+)
+
+// SetPluginDirectory makes EnvVars export dir as PACKER_PLUGIN_PATH, so that
+// every Packer invocation uses the same plugins. An empty dir leaves the
+// plugin directory to Packer.
+func SetPluginDirectory(dir string) {
+	pluginDirectoryMu.Lock()
+	defer pluginDirectoryMu.Unlock()
+	pluginDirectory = dir
+}
+
+// PluginDirectory returns the directory set by SetPluginDirectory.
+func PluginDirectory() string {
+	pluginDirectoryMu.RLock()
+	defer pluginDirectoryMu.RUnlock()
+	return pluginDirectory
+}
+
 func EnvVars(additionalEnvVars map[string]string, passThroughCurrent bool) map[string]string {
 	envVars := map[string]string{}
 	if passThroughCurrent {
@@ -18,6 +40,9 @@ func EnvVars(additionalEnvVars map[string]string, passThroughCurrent bool) map[s
 			envVars[split[0]] = split[1]
 		}
 	}
+	if dir := PluginDirectory(); dir != "" {
+		envVars[PackerPluginPath] = dir
+	}
 	for key, value := range additionalEnvVars {
 		envVars[key] = value
 	}
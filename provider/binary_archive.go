@@ -0,0 +1,387 @@
+package provider
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+type archiveFormat int
+
+const (
+	archiveNone archiveFormat = iota
+	archiveZip
+	archiveTar
+	archiveTarGzip
+	archiveTarXz
+	archiveTarZstd
+	archiveGzip
+	archiveXz
+	archiveZstd
+)
+
+func (f archiveFormat) String() string {
+	switch f {
+	case archiveZip:
+		return "zip"
+	case archiveTar:
+		return "tar"
+	case archiveTarGzip:
+		return "tar.gz"
+	case archiveTarXz:
+		return "tar.xz"
+	case archiveTarZstd:
+		return "tar.zst"
+	case archiveGzip:
+		return "gz"
+	case archiveXz:
+		return "xz"
+	case archiveZstd:
+		return "zst"
+	}
+	return "raw"
+}
+
+var (
+	zipMagic  = []byte{'P', 'K', 0x03, 0x04}
+	gzipMagic = []byte{0x1f, 0x8b}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	tarMagic  = []byte("ustar")
+)
+
+// tarMagicOffset is the position of the "ustar" magic in a tar header.
+const tarMagicOffset = 257
+
+// maxDecompressedArchiveSize bounds how many bytes are decompressed from a
+// downloaded archive, protecting against decompression bombs.
+var maxDecompressedArchiveSize int64 = 2 << 30
+
+var errArchiveTooLarge = errors.New("archive exceeds the maximum decompressed size")
+
+// detectArchiveFormat identifies the archive type of path by its magic bytes.
+// Files that match no known format are treated as raw binaries, and gzip,
+// xz and zstd streams that do not hold a tarball as a compressed binary.
+func detectArchiveFormat(path string) (archiveFormat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return archiveNone, fmt.Errorf("could not open downloaded file: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	header := make([]byte, tarMagicOffset+len(tarMagic))
+	n, err := io.ReadFull(f, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return archiveNone, fmt.Errorf("could not read downloaded file: %v", err)
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, zipMagic):
+		return archiveZip, nil
+	case bytes.HasPrefix(header, gzipMagic):
+		return compressedFormat(f, archiveGzip, archiveTarGzip)
+	case bytes.HasPrefix(header, xzMagic):
+		return compressedFormat(f, archiveXz, archiveTarXz)
+	case bytes.HasPrefix(header, zstdMagic):
+		return compressedFormat(f, archiveZstd, archiveTarZstd)
+	case len(header) == tarMagicOffset+len(tarMagic) && bytes.Equal(header[tarMagicOffset:], tarMagic):
+		return archiveTar, nil
+	}
+	return archiveNone, nil
+}
+
+// compressedFormat returns tarball if the stream in f, compressed as
+// compressed, starts with a valid tar header, else compressed.
+func compressedFormat(f *os.File, compressed archiveFormat, tarball archiveFormat) (archiveFormat, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return archiveNone, fmt.Errorf("could not read downloaded file: %v", err)
+	}
+	stream, closeStream, err := decompress(f, compressed)
+	if err != nil {
+		return compressed, nil
+	}
+	defer closeStream()
+	if _, err := tar.NewReader(stream).Next(); err != nil {
+		return compressed, nil
+	}
+	return tarball, nil
+}
+
+// decompress returns the decompressed stream of r, which is compressed as
+// format, and the function that releases it. Uncompressed formats are
+// returned as they are.
+func decompress(r io.Reader, format archiveFormat) (io.Reader, func(), error) {
+	switch format {
+	case archiveGzip, archiveTarGzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gz, func() { _ = gz.Close() }, nil
+	case archiveXz, archiveTarXz:
+		xzReader, err := xz.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return xzReader, func() {}, nil
+	case archiveZstd, archiveTarZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	}
+	return r, func() {}, nil
+}
+
+// normalizeArchivePath cleans a user-supplied path inside an archive and
+// rejects paths that are absolute or escape the archive root.
+func normalizeArchivePath(p string) (string, error) {
+	clean, ok := archiveEntryName(strings.TrimSpace(p))
+	if !ok {
+		return "", fmt.Errorf("invalid archive path %q: must be a relative path inside the archive", p)
+	}
+	return clean, nil
+}
+
+// archiveEntryName returns the cleaned, slash-separated name of an archive
+// entry. ok is false for names that would escape the extraction root; such
+// entries are never selected.
+func archiveEntryName(name string) (clean string, ok bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || path.IsAbs(name) {
+		return "", false
+	}
+	clean = path.Clean(name)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", false
+	}
+	return clean, true
+}
+
+// choosePackerEntry picks the executable among the regular files of an
+// archive: the entry at innerPath when set, otherwise a file named packer
+// (or packer.exe), otherwise the only file in the archive.
+func choosePackerEntry(names []string, innerPath string) (string, error) {
+	if innerPath != "" {
+		for _, name := range names {
+			if name == innerPath {
+				return name, nil
+			}
+		}
+		return "", fmt.Errorf("downloaded archive does not contain a file at %q", innerPath)
+	}
+
+	var named []string
+	for _, name := range names {
+		base := strings.ToLower(path.Base(name))
+		if base == "packer" || base == "packer.exe" {
+			named = append(named, name)
+		}
+	}
+	switch {
+	case len(named) == 1:
+		return named[0], nil
+	case len(named) > 1:
+		return "", fmt.Errorf(
+			"downloaded archive contains multiple files named packer; set packer_binary_archive_path to select one",
+		)
+	case len(names) == 1:
+		return names[0], nil
+	}
+	return "", fmt.Errorf(
+		"could not identify a binary in the downloaded archive: " +
+			"expected a file named packer (or packer.exe) or an archive containing exactly one file; " +
+			"set packer_binary_archive_path to select one",
+	)
+}
+
+// extractPackerFromArchive extracts the Packer executable from the archive
+// into dir and returns its temporary path. See choosePackerEntry for how the
+// executable is selected.
+func extractPackerFromArchive(archivePath string, format archiveFormat, innerPath string, dir string) (string, error) {
+	switch format {
+	case archiveZip:
+		return extractPackerFromZip(archivePath, innerPath, dir)
+	case archiveGzip, archiveXz, archiveZstd:
+		return extractCompressedPacker(archivePath, format, innerPath, dir)
+	}
+
+	// Tarballs can only be read sequentially: list the entries first, then
+	// stream the archive again to extract the chosen one.
+	var names []string
+	err := walkTar(archivePath, format, func(name string, _ io.Reader) (bool, error) {
+		names = append(names, name)
+		return false, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	chosen, err := choosePackerEntry(names, innerPath)
+	if err != nil {
+		return "", err
+	}
+
+	var extracted string
+	err = walkTar(archivePath, format, func(name string, r io.Reader) (bool, error) {
+		if name != chosen {
+			return false, nil
+		}
+		var extractErr error
+		extracted, extractErr = writeExtractedFile(r, chosen, dir)
+		return true, extractErr
+	})
+	if err != nil {
+		return "", err
+	}
+	return extracted, nil
+}
+
+// walkTar calls fn for every regular file with a safe name in the tarball.
+// fn returns true to stop the walk early.
+func walkTar(archivePath string, format archiveFormat, fn func(name string, r io.Reader) (bool, error)) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("could not open downloaded %s archive: %v", format, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	stream, closeStream, err := decompress(f, format)
+	if err != nil {
+		return fmt.Errorf("could not open downloaded %s archive: %v", format, err)
+	}
+	defer closeStream()
+
+	tr := tar.NewReader(&sizeLimitedReader{r: stream, remaining: maxDecompressedArchiveSize})
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read downloaded %s archive: %w", format, err)
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		name, ok := archiveEntryName(hdr.Name)
+		if !ok {
+			continue
+		}
+		stop, err := fn(name, tr)
+		if err != nil || stop {
+			return err
+		}
+	}
+}
+
+// extractPackerFromZip extracts the chosen executable from a zip archive.
+func extractPackerFromZip(archivePath string, innerPath string, dir string) (string, error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return "", fmt.Errorf("could not open downloaded zip archive: %v", err)
+	}
+	defer func() { _ = r.Close() }()
+
+	files := map[string]*zip.File{}
+	var names []string
+	for _, f := range r.File {
+		if !f.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		name, ok := archiveEntryName(f.Name)
+		if !ok {
+			continue
+		}
+		files[name] = f
+		names = append(names, name)
+	}
+
+	chosenName, err := choosePackerEntry(names, innerPath)
+	if err != nil {
+		return "", err
+	}
+	chosen := files[chosenName]
+	if chosen.UncompressedSize64 > uint64(maxDecompressedArchiveSize) {
+		return "", fmt.Errorf("could not extract %q from downloaded zip archive: %w", chosen.Name, errArchiveTooLarge)
+	}
+
+	src, err := chosen.Open()
+	if err != nil {
+		return "", fmt.Errorf("could not read %q from downloaded zip archive: %v", chosen.Name, err)
+	}
+	defer func() { _ = src.Close() }()
+
+	return writeExtractedFile(&sizeLimitedReader{r: src, remaining: maxDecompressedArchiveSize}, chosen.Name, dir)
+}
+
+// extractCompressedPacker decompresses an executable compressed as format,
+// which has no entries to select with innerPath.
+func extractCompressedPacker(archivePath string, format archiveFormat, innerPath string, dir string) (string, error) {
+	if innerPath != "" {
+		return "", fmt.Errorf("packer_binary_archive_path is set, but the download is a %s-compressed file, not an archive", format)
+	}
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return "", fmt.Errorf("could not open downloaded %s file: %v", format, err)
+	}
+	defer func() { _ = f.Close() }()
+	stream, closeStream, err := decompress(f, format)
+	if err != nil {
+		return "", fmt.Errorf("could not open downloaded %s file: %v", format, err)
+	}
+	defer closeStream()
+	return writeExtractedFile(&sizeLimitedReader{r: stream, remaining: maxDecompressedArchiveSize}, "packer", dir)
+}
+
+func writeExtractedFile(src io.Reader, name string, dir string) (string, error) {
+	tmp, err := os.CreateTemp(dir, "extract-*")
+	if err != nil {
+		return "", fmt.Errorf("could not create temporary file in %q: %v", dir, err)
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("could not extract %q from downloaded archive: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("could not finish extracting %q: %v", name, err)
+	}
+	return tmp.Name(), nil
+}
+
+// sizeLimitedReader fails with errArchiveTooLarge once more than remaining
+// bytes would be read from r.
+type sizeLimitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// Only an error if the stream actually continues past the limit.
+		var probe [1]byte
+		if n, err := l.r.Read(probe[:]); n == 0 {
+			return 0, err
+		}
+		return 0, errArchiveTooLarge
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
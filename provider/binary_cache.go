@@ -0,0 +1,257 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultCacheLockTimeout bounds how long a process waits for another one
+// to finish populating the same cache entry.
+const defaultCacheLockTimeout = 15 * time.Minute
+
+// cacheLockRetryDelay is how often a busy cache entry lock is polled.
+var cacheLockRetryDelay = 100 * time.Millisecond
+
+// cacheEntryGracePeriod is how long after its last use a cache entry is
+// not pruned, since another process may have resolved it but not run it
+// yet.
+var cacheEntryGracePeriod = 10 * time.Minute
+
+// cacheLimits bounds the download cache. Zero values mean unlimited.
+type cacheLimits struct {
+	MaxBytes   int64
+	MaxEntries int
+}
+
+func (l cacheLimits) enabled() bool {
+	return l.MaxBytes > 0 || l.MaxEntries > 0
+}
+
+type cacheEntry struct {
+	path     string
+	size     int64
+	lastUsed time.Time
+}
+
+// markCacheEntryUsed records a cache hit for least-recently-used pruning.
+// The entry directory's modification time serves as the last-used time, so
+// that the cached binary itself stays untouched.
+func markCacheEntryUsed(dir string) {
+	now := time.Now()
+	_ = os.Chtimes(dir, now, now)
+}
+
+// isolatedPluginsDirName is the directory inside the download cache that
+// holds the plugin directories of packer_image resources with
+// isolate_plugins. It is not a cache entry.
+const isolatedPluginsDirName = "isolated-plugins"
+
+// templateSourcesDirName is the directory inside the download cache that
+// holds the fetched template sources. It is not a cache entry.
+const templateSourcesDirName = "template-sources"
+
+// pluginDownloadsDirName is the directory inside the download cache that
+// packer_plugin resources fetch plugins into before installing them. It is
+// not a cache entry.
+const pluginDownloadsDirName = "plugin-downloads"
+
+// cacheDataDirs are the directories inside the download cache that are not
+// cache entries.
+var cacheDataDirs = []string{isolatedPluginsDirName, templateSourcesDirName, pluginDownloadsDirName}
+
+func listCacheEntries(base string) ([]cacheEntry, error) {
+	dirEntries, err := os.ReadDir(base)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []cacheEntry
+	for _, d := range dirEntries {
+		if !d.IsDir() || slices.Contains(cacheDataDirs, d.Name()) {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		entry := cacheEntry{path: filepath.Join(base, d.Name()), lastUsed: info.ModTime()}
+		_ = filepath.WalkDir(entry.path, func(_ string, f fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if fi, err := f.Info(); err == nil && fi.Mode().IsRegular() {
+				entry.size += fi.Size()
+			}
+			return nil
+		})
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// pruneDownloadCache removes the least recently used entries under base
+// until the cache fits limits. The entries in keep and the ones used within
+// cacheEntryGracePeriod are never removed. It returns the removed entry
+// directories.
+func pruneDownloadCache(base string, limits cacheLimits, keep ...string) ([]string, error) {
+	if !limits.enabled() {
+		return nil, nil
+	}
+	entries, err := listCacheEntries(base)
+	if err != nil {
+		return nil, fmt.Errorf("could not list download cache %q: %v", base, err)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUsed.After(entries[j].lastUsed)
+	})
+
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+	count := len(entries)
+
+	var removed []string
+	recent := time.Now().Add(-cacheEntryGracePeriod)
+	for i := len(entries) - 1; i >= 0; i-- {
+		overSize := limits.MaxBytes > 0 && total > limits.MaxBytes
+		overCount := limits.MaxEntries > 0 && count > limits.MaxEntries
+		if !overSize && !overCount {
+			break
+		}
+		e := entries[i]
+		if slices.Contains(keep, e.path) || e.lastUsed.After(recent) {
+			continue
+		}
+		// Entries that another process is populating or verifying are
+		// skipped; they will be considered again on the next run.
+		lock, locked, err := tryLockFile(cacheLockPath(e.path), false)
+		if err != nil || !locked {
+			continue
+		}
+		err = os.RemoveAll(e.path)
+		_ = lock.Unlock()
+		if err != nil {
+			return removed, fmt.Errorf("could not remove cache entry %q: %v", e.path, err)
+		}
+		removed = append(removed, e.path)
+		total -= e.size
+		count--
+	}
+	return removed, nil
+}
+
+// cacheLockPath is the lock file guarding dir. It lives next to dir rather
+// than inside it, so that removing a cache entry never removes a lock that
+// another process holds or waits for.
+func cacheLockPath(dir string) string {
+	return dir + ".lock"
+}
+
+// lockDir takes an exclusive cross-process lock on dir, a cache entry or a
+// Packer plugin directory, waiting up to timeout for another holder to
+// release it. The lock is a fileLock, so a crashed process cannot leave a
+// stale lock behind. The lock file records the current holder for error
+// messages.
+func lockDir(ctx context.Context, dir string, timeout time.Duration) (*fileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return nil, fmt.Errorf("could not create directory %q: %v", filepath.Dir(dir), err)
+	}
+	lockPath := cacheLockPath(dir)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	lock, err := lockFileContext(ctx, lockPath, false, cacheLockRetryDelay)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			holder, _ := os.ReadFile(lockPath)
+			return nil, fmt.Errorf(
+				"timed out after %s waiting for the lock on %q (held by %s)",
+				timeout, dir, describeCacheLockHolder(holder),
+			)
+		}
+		return nil, fmt.Errorf("could not lock %q: %v", dir, err)
+	}
+	holder := fmt.Sprintf("pid %d since %s", os.Getpid(), time.Now().UTC().Format(time.RFC3339))
+	if err := lock.setHolder(holder); err != nil {
+		_ = lock.Unlock()
+		return nil, fmt.Errorf("could not record the holder of the lock on %q: %v", dir, err)
+	}
+	return lock, nil
+}
+
+func describeCacheLockHolder(content []byte) string {
+	if holder := strings.TrimSpace(string(content)); holder != "" {
+		return holder
+	}
+	return "an unknown process"
+}
+
+// CachedBinary describes an entry of the download cache, as reported by the
+// `doctor` command.
+type CachedBinary struct {
+	Dir      string
+	Source   string
+	Size     int64
+	LastUsed time.Time
+	// Problem explains why the entry would not be reused as is; it is
+	// empty for a healthy entry.
+	Problem string
+}
+
+// DownloadCache returns the download cache directory and its entries, most
+// recently used first. cacheDir is the provider's packer_binary_cache_dir;
+// when empty, the default cache directory is used.
+func DownloadCache(cacheDir string) (string, []CachedBinary, error) {
+	base := downloadOptions{CacheDir: cacheDir}.cacheBaseDir()
+	entries, err := listCacheEntries(base)
+	if err != nil {
+		return base, nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUsed.After(entries[j].lastUsed)
+	})
+	cached := make([]CachedBinary, 0, len(entries))
+	for _, e := range entries {
+		c := CachedBinary{Dir: e.path, Size: e.size, LastUsed: e.lastUsed}
+		if meta, err := readCacheMetadata(e.path); err == nil {
+			c.Source = meta.Source
+		}
+		if err := verifyCacheEntry(e.path, filepath.Join(e.path, downloadedBinaryName())); err != nil {
+			c.Problem = err.Error()
+		}
+		cached = append(cached, c)
+	}
+	return base, cached, nil
+}
+
+// IsolatedPluginDirectories returns the plugin directories of packer_image
+// resources with isolate_plugins kept in the download cache; cacheDir is as
+// for DownloadCache.
+func IsolatedPluginDirectories(cacheDir string) ([]string, error) {
+	base := filepath.Join(downloadOptions{CacheDir: cacheDir}.cacheBaseDir(), isolatedPluginsDirName)
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var dirs []string
+	for _, e := range entries {
+		if e.IsDir() {
+			dirs = append(dirs, filepath.Join(base, e.Name()))
+		}
+	}
+	return dirs, nil
+}
@@ -0,0 +1,304 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// makeCacheEntry creates a cache entry holding size bytes that was last used
+// age ago.
+func makeCacheEntry(t *testing.T, base string, name string, size int, age time.Duration) string {
+	t.Helper()
+	dir := filepath.Join(base, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, downloadedBinaryName()), make([]byte, size), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	when := time.Now().Add(-age)
+	if err := os.Chtimes(dir, when, when); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func remainingCacheEntries(t *testing.T, base string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+func TestPruneDownloadCacheByEntries(t *testing.T) {
+	base := t.TempDir()
+	makeCacheEntry(t, base, "a", 10, 3*time.Hour)
+	makeCacheEntry(t, base, "b", 10, 2*time.Hour)
+	makeCacheEntry(t, base, "c", 10, time.Hour)
+
+	removed, err := pruneDownloadCache(base, cacheLimits{MaxEntries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || filepath.Base(removed[0]) != "a" {
+		t.Errorf("removed %v, want only the least recently used entry a", removed)
+	}
+	if got := strings.Join(remainingCacheEntries(t, base), ","); got != "b,c" {
+		t.Errorf("remaining entries = %s, want b,c", got)
+	}
+}
+
+func TestPruneDownloadCacheBySize(t *testing.T) {
+	base := t.TempDir()
+	makeCacheEntry(t, base, "a", 100, 3*time.Hour)
+	makeCacheEntry(t, base, "b", 100, 2*time.Hour)
+	makeCacheEntry(t, base, "c", 100, time.Hour)
+
+	if _, err := pruneDownloadCache(base, cacheLimits{MaxBytes: 250}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(remainingCacheEntries(t, base), ","); got != "b,c" {
+		t.Errorf("remaining entries = %s, want b,c", got)
+	}
+}
+
+func TestPruneDownloadCacheKeepsEntryInUse(t *testing.T) {
+	base := t.TempDir()
+	keep := makeCacheEntry(t, base, "a", 100, 3*time.Hour)
+	makeCacheEntry(t, base, "b", 100, 2*time.Hour)
+	makeCacheEntry(t, base, "c", 100, time.Hour)
+
+	if _, err := pruneDownloadCache(base, cacheLimits{MaxEntries: 1}, keep); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(remainingCacheEntries(t, base), ","); got != "a" {
+		t.Errorf("remaining entries = %s, want only the kept entry a", got)
+	}
+}
+
+func TestPruneDownloadCacheKeepsRecentlyUsedEntries(t *testing.T) {
+	base := t.TempDir()
+	makeCacheEntry(t, base, "a", 100, 3*time.Hour)
+	makeCacheEntry(t, base, "b", 100, time.Minute)
+	makeCacheEntry(t, base, "c", 100, 0)
+
+	if _, err := pruneDownloadCache(base, cacheLimits{MaxEntries: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(remainingCacheEntries(t, base), ","); got != "b,c" {
+		t.Errorf("remaining entries = %s, want the entries used within the grace period", got)
+	}
+}
+
+func TestPruneDownloadCacheWithoutLimits(t *testing.T) {
+	base := t.TempDir()
+	makeCacheEntry(t, base, "a", 100, time.Hour)
+
+	removed, err := pruneDownloadCache(base, cacheLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("removed %v without any limits", removed)
+	}
+}
+
+func TestCacheHitMarksEntryUsed(t *testing.T) {
+	useTempCacheDir(t)
+	server, _ := serveArtifact(t, []byte("fake packer"))
+
+	path, err := ensureDownloadedPackerBinary(context.Background(), server.URL+"/packer", "", downloadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Dir(path)
+	old := time.Now().Add(-24 * time.Hour)
+	if err := os.Chtimes(dir, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL+"/packer", "", downloadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().After(old.Add(time.Hour)) {
+		t.Errorf("cache hit did not update the last-used time of %s", dir)
+	}
+}
+
+func TestDownloadCustomCacheDir(t *testing.T) {
+	useTempCacheDir(t)
+	cacheDir := t.TempDir()
+	server, _ := serveArtifact(t, []byte("fake packer"))
+
+	path, err := ensureDownloadedPackerBinary(
+		context.Background(), server.URL+"/packer", "", downloadOptions{CacheDir: cacheDir},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(path, cacheDir+string(filepath.Separator)) {
+		t.Errorf("binary downloaded to %s, want it inside %s", path, cacheDir)
+	}
+}
+
+func TestDownloadCacheDisabled(t *testing.T) {
+	useTempCacheDir(t)
+	server, requests := serveArtifact(t, []byte("fake packer"))
+
+	opts := downloadOptions{DisableCache: true}
+	first, err := ensureDownloadedPackerBinary(context.Background(), server.URL+"/packer", "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(filepath.Dir(first)) })
+	second, err := ensureDownloadedPackerBinary(context.Background(), server.URL+"/packer", "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(filepath.Dir(second)) })
+
+	if first == second {
+		t.Errorf("disabled cache reused %s", first)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("expected 2 download requests with the cache disabled, got %d", requests.Load())
+	}
+	if entries, _ := os.ReadDir(downloadCacheBaseDir()); len(entries) != 0 {
+		t.Errorf("disabled cache wrote %d entries to the cache directory", len(entries))
+	}
+
+	RemoveUncachedDownloads()
+	for _, path := range []string{first, second} {
+		if _, err := os.Stat(filepath.Dir(path)); !os.IsNotExist(err) {
+			t.Errorf("the temporary download of %s should be removed, stat: %v", path, err)
+		}
+	}
+}
+
+func TestConcurrentDownloadsShareOneRequest(t *testing.T) {
+	useTempCacheDir(t)
+	content := []byte("fake packer")
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		// Keep the download in flight long enough for every caller to
+		// contend for the lock.
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write(content)
+	}))
+	t.Cleanup(server.Close)
+
+	const callers = 8
+	paths := make([]string, callers)
+	errs := make([]error, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			paths[i], errs[i] = ensureDownloadedPackerBinary(
+				context.Background(), server.URL+"/packer", sha256Hex(content), downloadOptions{},
+			)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range paths {
+		if errs[i] != nil {
+			t.Fatalf("caller %d: %v", i, errs[i])
+		}
+		if paths[i] != paths[0] {
+			t.Errorf("caller %d got %q, want %q", i, paths[i], paths[0])
+		}
+	}
+	if requests.Load() != 1 {
+		t.Errorf("expected exactly 1 download request, got %d", requests.Load())
+	}
+}
+
+func TestCacheLockTimeout(t *testing.T) {
+	useTempCacheDir(t)
+	server, requests := serveArtifact(t, []byte("fake packer"))
+	rawURL := server.URL + "/packer"
+
+	dir := filepath.Join(downloadCacheBaseDir(), downloadCacheKey(rawURL, "", downloadOptions{}))
+	holder, err := lockDir(context.Background(), dir, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = holder.Unlock() }()
+
+	_, err = ensureDownloadedPackerBinary(
+		context.Background(), rawURL, "", downloadOptions{LockTimeout: 200 * time.Millisecond},
+	)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a lock timeout, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "pid ") {
+		t.Errorf("timeout error does not name the lock holder: %v", err)
+	}
+	if requests.Load() != 0 {
+		t.Errorf("expected no download while the entry is locked, got %d requests", requests.Load())
+	}
+}
+
+func TestIncompleteCacheEntryIsCleanedUp(t *testing.T) {
+	useTempCacheDir(t)
+	server, _ := serveArtifact(t, []byte("fake packer"))
+	rawURL := server.URL + "/packer"
+
+	// A previous holder crashed mid-download and left a partial file.
+	dir := filepath.Join(downloadCacheBaseDir(), downloadCacheKey(rawURL, "", downloadOptions{}))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	leftover := filepath.Join(dir, "download-123")
+	if err := os.WriteFile(leftover, []byte("partial"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ensureDownloadedPackerBinary(context.Background(), rawURL, "", downloadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Errorf("leftover %s from a crashed download was not removed", leftover)
+	}
+}
+
+func TestPruneDownloadCacheSkipsLockedEntries(t *testing.T) {
+	base := t.TempDir()
+	busy := makeCacheEntry(t, base, "a", 10, 2*time.Hour)
+	makeCacheEntry(t, base, "b", 10, time.Hour)
+
+	lock, locked, err := tryLockFile(cacheLockPath(busy), false)
+	if err != nil || !locked {
+		t.Fatalf("could not lock %s: %v", busy, err)
+	}
+	defer func() { _ = lock.Unlock() }()
+
+	if _, err := pruneDownloadCache(base, cacheLimits{MaxEntries: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(busy); err != nil {
+		t.Errorf("locked entry was pruned: %v", err)
+	}
+}
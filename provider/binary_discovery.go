@@ -0,0 +1,140 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+// Environment variables naming the Packer binary in discovery mode, in order
+// of precedence.
+var packerBinaryEnvVars = []string{"PACKER_BINARY", "TPP_PACKER_BINARY"}
+
+var packerVersionPattern = regexp.MustCompile(`(?m)^Packer v?(\d+\.\d+\.\d+\S*)`)
+
+var errNoPackerBinaryFound = errors.New("no Packer binary found")
+
+// parsePackerVersion extracts the version from `packer version` output,
+// e.g. "Packer v1.9.4" followed by an optional update notice.
+func parsePackerVersion(output []byte) (*version.Version, error) {
+	m := packerVersionPattern.FindSubmatch(output)
+	if m == nil {
+		return nil, fmt.Errorf("could not find a version in %q", strings.TrimSpace(string(output)))
+	}
+	return version.NewVersion(string(m[1]))
+}
+
+// satisfiesConstraints checks v against c, ignoring pre-release and build
+// suffixes: the embedded build reports 1.10.0-mpl, which go-version would
+// otherwise only match against pre-release constraints.
+func satisfiesConstraints(c version.Constraints, v *version.Version) bool {
+	return c == nil || c.Check(v.Core())
+}
+
+// probePackerVersion runs `bin version`, memoized, and parses the result.
+func probePackerVersion(bin string) (*version.Version, error) {
+	info, err := binaryInfos.get(bin)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(info.Output)))
+	}
+	return parsePackerVersion(info.Output)
+}
+
+// discoveryOptions configures discoverPackerBinary.
+type discoveryOptions struct {
+	// SearchPaths are directories searched after PATH.
+	SearchPaths []string
+	// Constraints the binary's version must satisfy; nil accepts any.
+	Constraints version.Constraints
+}
+
+type discoveredBinary struct {
+	Path    string
+	Version *version.Version
+}
+
+// discoverPackerBinary locates a Packer binary. A binary named by
+// PACKER_BINARY or TPP_PACKER_BINARY is used exclusively; otherwise every
+// `packer` executable in PATH and then in opts.SearchPaths is probed and the
+// newest one satisfying opts.Constraints wins, the earliest on ties.
+func discoverPackerBinary(opts discoveryOptions) (discoveredBinary, error) {
+	for _, name := range packerBinaryEnvVars {
+		bin := os.Getenv(name)
+		if bin == "" {
+			continue
+		}
+		v, err := probePackerVersion(bin)
+		if err != nil {
+			return discoveredBinary{}, fmt.Errorf("%s=%s is not a usable Packer binary: %v", name, bin, err)
+		}
+		if !satisfiesConstraints(opts.Constraints, v) {
+			return discoveredBinary{}, fmt.Errorf(
+				"%s=%s is Packer %s, which does not satisfy %q", name, bin, v, opts.Constraints,
+			)
+		}
+		return discoveredBinary{Path: bin, Version: v}, nil
+	}
+
+	dirs := filepath.SplitList(os.Getenv("PATH"))
+	dirs = append(dirs, opts.SearchPaths...)
+	var best discoveredBinary
+	var rejected []string
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		candidate := filepath.Join(dir, downloadedBinaryName())
+		if !isExecutableFile(candidate) {
+			continue
+		}
+		key := candidate
+		if resolved, err := filepath.EvalSymlinks(candidate); err == nil {
+			key = resolved
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		v, err := probePackerVersion(candidate)
+		if err != nil {
+			rejected = append(rejected, fmt.Sprintf("%s (%v)", candidate, err))
+			continue
+		}
+		if !satisfiesConstraints(opts.Constraints, v) {
+			rejected = append(rejected, fmt.Sprintf("%s (%s)", candidate, v))
+			continue
+		}
+		if best.Version == nil || v.GreaterThan(best.Version) {
+			best = discoveredBinary{Path: candidate, Version: v}
+		}
+	}
+	if best.Version == nil {
+		if len(rejected) > 0 {
+			wanted := ""
+			if opts.Constraints != nil {
+				wanted = fmt.Sprintf(" satisfying %q", opts.Constraints)
+			}
+			return best, fmt.Errorf("%w%s; rejected: %s",
+				errNoPackerBinaryFound, wanted, strings.Join(rejected, ", "))
+		}
+		return best, fmt.Errorf("%w in %s, PATH or the search paths",
+			errNoPackerBinaryFound, strings.Join(packerBinaryEnvVars, ", "))
+	}
+	return best, nil
+}
+
+func isExecutableFile(p string) bool {
+	info, err := os.Stat(p)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	return runtime.GOOS == "windows" || info.Mode().Perm()&0o111 != 0
+}
@@ -0,0 +1,128 @@
+package provider
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-version"
+)
+
+// fakePacker writes a `packer` script into a new directory that reports v.
+func fakePacker(t *testing.T, v string) string {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho 'Packer v" + v + "'\n"
+	if err := os.WriteFile(filepath.Join(dir, "packer"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// isolateDiscovery clears the discovery environment and sets PATH to dirs.
+func isolateDiscovery(t *testing.T, dirs ...string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake Packer binaries are shell scripts")
+	}
+	for _, name := range packerBinaryEnvVars {
+		t.Setenv(name, "")
+	}
+	t.Setenv("PATH", strings.Join(dirs, string(os.PathListSeparator)))
+}
+
+func TestParsePackerVersion(t *testing.T) {
+	cases := map[string]string{
+		"Packer v1.9.4\n":      "1.9.4",
+		"Packer v1.10.0-mpl\n": "1.10.0-mpl",
+		"Packer v1.8.0\n\nYour version of Packer is out of date! The latest version\nis 1.9.4.\n": "1.8.0",
+	}
+	for output, want := range cases {
+		got, err := parsePackerVersion([]byte(output))
+		if err != nil {
+			t.Errorf("parsePackerVersion(%q) returned error: %v", output, err)
+		} else if got.Original() != want {
+			t.Errorf("parsePackerVersion(%q) = %s, want %s", output, got.Original(), want)
+		}
+	}
+	if _, err := parsePackerVersion([]byte("command not found")); err == nil {
+		t.Error("parsePackerVersion should fail without a version")
+	}
+}
+
+func TestSatisfiesConstraintsIgnoresSuffixes(t *testing.T) {
+	embedded := version.Must(version.NewVersion("1.10.0-mpl"))
+	if !satisfiesConstraints(version.MustConstraints(version.NewConstraint(">= 1.9")), embedded) {
+		t.Error("1.10.0-mpl should satisfy >= 1.9")
+	}
+	if satisfiesConstraints(version.MustConstraints(version.NewConstraint(">= 1.11")), embedded) {
+		t.Error("1.10.0-mpl should not satisfy >= 1.11")
+	}
+}
+
+func TestDiscoverPicksNewestSatisfyingBinary(t *testing.T) {
+	oldest := fakePacker(t, "1.8.0")
+	middle := fakePacker(t, "1.9.4")
+	newest := fakePacker(t, "1.11.0")
+	isolateDiscovery(t, oldest, middle)
+
+	found, err := discoverPackerBinary(discoveryOptions{SearchPaths: []string{newest}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Path != filepath.Join(newest, "packer") {
+		t.Errorf("discovered %s, want the newest binary in the search paths", found.Path)
+	}
+
+	found, err = discoverPackerBinary(discoveryOptions{
+		SearchPaths: []string{newest},
+		Constraints: version.MustConstraints(version.NewConstraint(">= 1.9, < 1.11")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Path != filepath.Join(middle, "packer") || found.Version.String() != "1.9.4" {
+		t.Errorf("discovered %s (%s), want 1.9.4 from PATH", found.Path, found.Version)
+	}
+}
+
+func TestDiscoverPrefersEnvironment(t *testing.T) {
+	fromEnv := fakePacker(t, "1.7.0")
+	isolateDiscovery(t, fakePacker(t, "1.9.4"))
+	t.Setenv("TPP_PACKER_BINARY", filepath.Join(fromEnv, "packer"))
+
+	found, err := discoverPackerBinary(discoveryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Path != filepath.Join(fromEnv, "packer") {
+		t.Errorf("discovered %s, want the binary named by TPP_PACKER_BINARY", found.Path)
+	}
+
+	_, err = discoverPackerBinary(discoveryOptions{
+		Constraints: version.MustConstraints(version.NewConstraint(">= 1.9")),
+	})
+	if err == nil || !strings.Contains(err.Error(), "TPP_PACKER_BINARY") {
+		t.Errorf("expected the environment binary to be rejected by the constraint, got %v", err)
+	}
+}
+
+func TestDiscoverReportsMissingBinary(t *testing.T) {
+	isolateDiscovery(t, t.TempDir())
+
+	_, err := discoverPackerBinary(discoveryOptions{})
+	if !errors.Is(err, errNoPackerBinaryFound) {
+		t.Errorf("expected errNoPackerBinaryFound, got %v", err)
+	}
+
+	isolateDiscovery(t, fakePacker(t, "1.8.0"))
+	_, err = discoverPackerBinary(discoveryOptions{
+		Constraints: version.MustConstraints(version.NewConstraint(">= 1.9")),
+	})
+	if !errors.Is(err, errNoPackerBinaryFound) || !strings.Contains(err.Error(), "1.8.0") {
+		t.Errorf("expected the rejected 1.8.0 binary to be reported, got %v", err)
+	}
+}
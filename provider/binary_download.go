@@ -1,26 +1,54 @@
 package provider
 
 import (
-	"archive/zip"
-	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"terraform-provider-packer/crypto_util"
 )
 
 const downloadCacheSubdir = "terraform-provider-packer/downloaded-binaries"
 
-var zipMagic = []byte{'P', 'K', 0x03, 0x04}
+// uncachedDownloads tracks the temporary directories binaries are
+// downloaded into while the cache is disabled; see RemoveUncachedDownloads.
+var uncachedDownloads struct {
+	sync.Mutex
+	dirs []string
+}
+
+// uncachedDownloadDir creates a temporary directory to download a binary
+// into while the cache is disabled.
+func uncachedDownloadDir() (string, error) {
+	dir, err := os.MkdirTemp("", "terraform-provider-packer-binary-*")
+	if err != nil {
+		return "", fmt.Errorf("could not create temporary directory: %v", err)
+	}
+	uncachedDownloads.Lock()
+	defer uncachedDownloads.Unlock()
+	uncachedDownloads.dirs = append(uncachedDownloads.dirs, dir)
+	return dir, nil
+}
+
+// RemoveUncachedDownloads removes the binaries downloaded while the cache
+// is disabled. The provider process calls it before it exits, when Packer
+// no longer runs them.
+func RemoveUncachedDownloads() {
+	uncachedDownloads.Lock()
+	defer uncachedDownloads.Unlock()
+	for _, dir := range uncachedDownloads.dirs {
+		_ = os.RemoveAll(dir)
+	}
+	uncachedDownloads.dirs = nil
+}
 
 func normalizeChecksum(checksum string) (string, error) {
 	c := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(checksum)), "sha256:")
@@ -40,11 +68,60 @@ func downloadedBinaryName() string {
 	return "packer"
 }
 
+// downloadOptions holds the optional settings that refine how a binary is
+// obtained from packer_binary_url.
+type downloadOptions struct {
+	// ArchivePath selects the executable inside an archive by its path.
+	ArchivePath string
+	// Mirrors serve the same artifact as the primary URL and are tried in
+	// order once it has failed.
+	Mirrors []string
+	// Retries is the number of additional attempts per URL after a
+	// transient failure.
+	Retries int
+	// Headers are added to every download request, e.g. Authorization.
+	Headers map[string]string
+	// CABundle holds PEM certificates trusted in addition to the system
+	// roots.
+	CABundle string
+	// Timeout bounds a whole download including retries; zero means no
+	// limit.
+	Timeout time.Duration
+	// CacheDir overrides the download cache location.
+	CacheDir string
+	// DisableCache downloads into a fresh temporary directory that is
+	// never reused and is removed when the provider exits.
+	DisableCache bool
+	// LockTimeout bounds the wait for another process populating the same
+	// cache entry or installing plugins into the same plugin directory;
+	// zero means defaultCacheLockTimeout.
+	LockTimeout time.Duration
+}
+
+// lockTimeout returns LockTimeout or its default.
+func (o downloadOptions) lockTimeout() time.Duration {
+	if o.LockTimeout > 0 {
+		return o.LockTimeout
+	}
+	return defaultCacheLockTimeout
+}
+
+func (o downloadOptions) cacheBaseDir() string {
+	if o.CacheDir != "" {
+		return o.CacheDir
+	}
+	return downloadCacheBaseDir()
+}
+
 // downloadCacheKey derives a stable directory name from URL and checksum so
 // that a cached binary is only reused for the exact same source and
 // verification requirements it was originally downloaded with.
-func downloadCacheKey(rawURL string, checksum string) string {
-	sum := sha256.Sum256([]byte(rawURL + "\n" + checksum))
+func downloadCacheKey(rawURL string, checksum string, opts downloadOptions) string {
+	key := rawURL + "\n" + checksum
+	if opts.ArchivePath != "" {
+		key += "\n" + opts.ArchivePath
+	}
+	sum := sha256.Sum256([]byte(key))
 	return hex.EncodeToString(sum[:])
 }
 
@@ -58,38 +135,110 @@ var downloadCacheBaseDir = func() string {
 
 // ensureDownloadedPackerBinary returns the local path of the binary served by
 // rawURL, downloading and caching it if necessary. The artifact may be a raw
-// executable or a zip archive containing one. checksum, when non-empty, is a
-// SHA-256 hash that the downloaded artifact itself must match.
-func ensureDownloadedPackerBinary(ctx context.Context, rawURL string, checksum string) (string, error) {
-	parsed, err := url.Parse(rawURL)
-	if err != nil {
-		return "", fmt.Errorf("invalid URL %q: %v", rawURL, err)
-	}
-	if parsed.Scheme != "http" && parsed.Scheme != "https" {
-		return "", fmt.Errorf("unsupported URL scheme %q: only http and https are supported", parsed.Scheme)
+// executable or an archive containing one (see extractPackerFromArchive).
+// checksum, when non-empty, is a SHA-256 hash that the downloaded artifact
+// itself must match.
+//
+// Plain http(s) URLs are downloaded directly; any other go-getter source
+// (file::, local paths, git::, ...) is fetched with go-getter. When such a
+// source yields a directory (a repository or an archive unpacked via
+// ?archive=), the executable is selected from it and checksum applies to
+// that executable.
+//
+// Cached binaries are re-verified against their metadata sidecar before
+// they are reused (see verifyCacheEntry).
+func ensureDownloadedPackerBinary(
+	ctx context.Context, rawURL string, checksum string, opts downloadOptions,
+) (string, error) {
+	urls := append([]string{rawURL}, opts.Mirrors...)
+	for _, u := range urls[1:] {
+		if err := validateDownloadURL(u); err != nil {
+			return "", err
+		}
 	}
 
+	var err error
 	if checksum != "" {
 		if checksum, err = normalizeChecksum(checksum); err != nil {
 			return "", err
 		}
 	}
+	if opts.ArchivePath != "" {
+		if opts.ArchivePath, err = normalizeArchivePath(opts.ArchivePath); err != nil {
+			return "", err
+		}
+	}
 
-	targetDir := filepath.Join(downloadCacheBaseDir(), downloadCacheKey(rawURL, checksum))
+	var targetDir string
+	if opts.DisableCache {
+		if targetDir, err = uncachedDownloadDir(); err != nil {
+			return "", err
+		}
+	} else {
+		targetDir = filepath.Join(opts.cacheBaseDir(), downloadCacheKey(rawURL, checksum, opts))
+		// Only one process populates an entry; the others wait and then
+		// reuse it.
+		lock, err := lockDir(ctx, targetDir, opts.lockTimeout())
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = lock.Unlock() }()
+	}
 	target := filepath.Join(targetDir, downloadedBinaryName())
 	if _, statErr := os.Stat(target); statErr == nil {
-		return target, nil
+		err := verifyCacheEntry(targetDir, target)
+		if err == nil {
+			markCacheEntryUsed(targetDir)
+			return target, nil
+		}
+		if !errors.Is(err, errCacheMetadataMissing) {
+			return "", fmt.Errorf("refusing to use cached binary: %v", err)
+		}
+		// Entries stored before sidecars existed cannot be re-verified;
+		// they are removed below and downloaded again.
+	}
+	if !opts.DisableCache {
+		// Holding the lock, anything left in an incomplete entry stems from
+		// a holder that crashed mid-download.
+		if err := os.RemoveAll(targetDir); err != nil {
+			return "", fmt.Errorf("could not clean up cache entry %q: %v", targetDir, err)
+		}
 	}
 
 	if err := os.MkdirAll(targetDir, 0o755); err != nil {
 		return "", fmt.Errorf("could not create cache directory %q: %v", targetDir, err)
 	}
+	// MkdirAll is subject to the umask; cache entries must not be writable
+	// by other users (see verifyCacheEntry).
+	if err := os.Chmod(targetDir, 0o755); err != nil {
+		return "", fmt.Errorf("could not set permissions on cache directory %q: %v", targetDir, err)
+	}
 
-	artifact, err := downloadToTempFile(ctx, rawURL, targetDir)
-	if err != nil {
-		return "", err
+	var artifact string
+	fetchedDir := false
+	if isPlainHTTPSource(rawURL) {
+		artifact, err = downloadToTempFile(ctx, urls, targetDir, opts)
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = os.Remove(artifact) }()
+	} else {
+		if len(opts.Mirrors) > 0 {
+			return "", fmt.Errorf("mirrors are only supported for http(s) URLs, not %s", rawURL)
+		}
+		fetched, staging, err := fetchWithGetter(ctx, rawURL, targetDir)
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = os.RemoveAll(staging) }()
+		artifact = fetched
+		if info, statErr := os.Stat(fetched); statErr == nil && info.IsDir() {
+			fetchedDir = true
+			if artifact, err = findPackerInDir(fetched, opts.ArchivePath); err != nil {
+				return "", err
+			}
+		}
 	}
-	defer func() { _ = os.Remove(artifact) }()
 
 	if checksum != "" {
 		actual, hashErr := crypto_util.FileSHA256(artifact)
@@ -105,133 +254,39 @@ func ensureDownloadedPackerBinary(ctx context.Context, rawURL string, checksum s
 	}
 
 	binary := artifact
-	if isZip, zipErr := fileHasZipMagic(artifact); zipErr != nil {
-		return "", zipErr
-	} else if isZip {
-		binary, err = extractPackerFromZip(artifact, targetDir)
+	format := archiveNone
+	if !fetchedDir {
+		if format, err = detectArchiveFormat(artifact); err != nil {
+			return "", err
+		}
+	}
+	if format != archiveNone {
+		binary, err = extractPackerFromArchive(artifact, format, opts.ArchivePath, targetDir)
 		if err != nil {
 			return "", err
 		}
 		defer func() { _ = os.Remove(binary) }()
+	} else if opts.ArchivePath != "" && !fetchedDir {
+		return "", fmt.Errorf("packer_binary_archive_path is set, but %s did not serve a supported archive", rawURL)
 	}
 
 	if err := os.Chmod(binary, 0o755); err != nil {
 		return "", fmt.Errorf("could not make downloaded binary executable: %v", err)
 	}
-	if err := os.Rename(binary, target); err != nil {
-		// A concurrent run may have populated the cache entry first; that
-		// copy passed the same verification, so use it.
-		if _, statErr := os.Stat(target); statErr == nil {
-			return target, nil
-		}
-		return "", fmt.Errorf("could not move downloaded binary into cache: %v", err)
-	}
-	return target, nil
-}
-
-func downloadToTempFile(ctx context.Context, rawURL string, dir string) (string, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	binarySum, err := crypto_util.FileSHA256(binary)
 	if err != nil {
-		return "", fmt.Errorf("could not create request for %s: %v", rawURL, err)
+		return "", fmt.Errorf("could not hash downloaded binary: %v", err)
 	}
-	req.Header.Set("User-Agent", "terraform-provider-packer")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("could not download %s: %v", rawURL, err)
-	}
-	defer func() { _ = resp.Body.Close() }()
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("could not download %s: unexpected status %s", rawURL, resp.Status)
-	}
-
-	tmp, err := os.CreateTemp(dir, "download-*")
-	if err != nil {
-		return "", fmt.Errorf("could not create temporary file in %q: %v", dir, err)
-	}
-	if _, err := io.Copy(tmp, resp.Body); err != nil {
-		_ = tmp.Close()
-		_ = os.Remove(tmp.Name())
-		return "", fmt.Errorf("could not write download to %q: %v", tmp.Name(), err)
-	}
-	if err := tmp.Close(); err != nil {
-		_ = os.Remove(tmp.Name())
-		return "", fmt.Errorf("could not finish writing download to %q: %v", tmp.Name(), err)
-	}
-	return tmp.Name(), nil
-}
-
-func fileHasZipMagic(path string) (bool, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return false, fmt.Errorf("could not open downloaded file: %v", err)
+	if err := writeCacheMetadata(targetDir, cacheMetadata{
+		Source:         rawURL,
+		ArtifactSHA256: checksum,
+		BinarySHA256:   binarySum,
+		StoredAt:       time.Now().UTC(),
+	}); err != nil {
+		return "", fmt.Errorf("could not write cache metadata: %v", err)
 	}
-	defer func() { _ = f.Close() }()
-
-	header := make([]byte, len(zipMagic))
-	if _, err := io.ReadFull(f, header); err != nil {
-		// Too short to be a zip archive; treat as a raw binary.
-		return false, nil
-	}
-	return bytes.Equal(header, zipMagic), nil
-}
-
-// extractPackerFromZip extracts the Packer executable from the archive into
-// dir and returns its temporary path. The archive must contain a file named
-// packer (or packer.exe), or exactly one file.
-func extractPackerFromZip(archivePath string, dir string) (string, error) {
-	r, err := zip.OpenReader(archivePath)
-	if err != nil {
-		return "", fmt.Errorf("could not open downloaded zip archive: %v", err)
-	}
-	defer func() { _ = r.Close() }()
-
-	var files []*zip.File
-	var named []*zip.File
-	for _, f := range r.File {
-		if f.FileInfo().IsDir() {
-			continue
-		}
-		files = append(files, f)
-		base := strings.ToLower(filepath.Base(filepath.FromSlash(f.Name)))
-		if base == "packer" || base == "packer.exe" {
-			named = append(named, f)
-		}
-	}
-
-	var chosen *zip.File
-	switch {
-	case len(named) == 1:
-		chosen = named[0]
-	case len(named) > 1:
-		return "", fmt.Errorf("downloaded zip archive contains multiple files named packer")
-	case len(files) == 1:
-		chosen = files[0]
-	default:
-		return "", fmt.Errorf(
-			"could not identify a binary in the downloaded zip archive: " +
-				"expected a file named packer (or packer.exe) or an archive containing exactly one file",
-		)
-	}
-
-	src, err := chosen.Open()
-	if err != nil {
-		return "", fmt.Errorf("could not read %q from downloaded zip archive: %v", chosen.Name, err)
-	}
-	defer func() { _ = src.Close() }()
-
-	tmp, err := os.CreateTemp(dir, "extract-*")
-	if err != nil {
-		return "", fmt.Errorf("could not create temporary file in %q: %v", dir, err)
-	}
-	if _, err := io.Copy(tmp, src); err != nil {
-		_ = tmp.Close()
-		_ = os.Remove(tmp.Name())
-		return "", fmt.Errorf("could not extract %q from downloaded zip archive: %v", chosen.Name, err)
-	}
-	if err := tmp.Close(); err != nil {
-		_ = os.Remove(tmp.Name())
-		return "", fmt.Errorf("could not finish extracting %q: %v", chosen.Name, err)
+	if err := os.Rename(binary, target); err != nil {
+		return "", fmt.Errorf("could not move downloaded binary into cache: %v", err)
 	}
-	return tmp.Name(), nil
+	return target, nil
 }
@@ -1,18 +1,30 @@
 package provider
 
 import (
+	"archive/tar"
 	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 )
 
 func useTempCacheDir(t *testing.T) {
@@ -80,7 +92,7 @@ func TestDownloadRawBinaryAndCache(t *testing.T) {
 	content := []byte("#!/bin/sh\necho fake packer\n")
 	server, requests := serveArtifact(t, content)
 
-	path, err := ensureDownloadedPackerBinary(context.Background(), server.URL+"/packer", "")
+	path, err := ensureDownloadedPackerBinary(context.Background(), server.URL+"/packer", "", downloadOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -101,7 +113,7 @@ func TestDownloadRawBinaryAndCache(t *testing.T) {
 		}
 	}
 
-	cached, err := ensureDownloadedPackerBinary(context.Background(), server.URL+"/packer", "")
+	cached, err := ensureDownloadedPackerBinary(context.Background(), server.URL+"/packer", "", downloadOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -118,13 +130,13 @@ func TestDownloadChecksumVerification(t *testing.T) {
 	content := []byte("fake packer binary")
 	server, _ := serveArtifact(t, content)
 
-	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, strings.Repeat("00", 32)); err == nil {
+	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, strings.Repeat("00", 32), downloadOptions{}); err == nil {
 		t.Error("expected checksum mismatch error")
 	} else if !strings.Contains(err.Error(), "checksum mismatch") {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "sha256:"+sha256Hex(content)); err != nil {
+	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "sha256:"+sha256Hex(content), downloadOptions{}); err != nil {
 		t.Errorf("download with correct checksum failed: %v", err)
 	}
 }
@@ -138,7 +150,7 @@ func TestDownloadZipWithNamedBinary(t *testing.T) {
 	})
 	server, _ := serveArtifact(t, archive)
 
-	path, err := ensureDownloadedPackerBinary(context.Background(), server.URL, sha256Hex(archive))
+	path, err := ensureDownloadedPackerBinary(context.Background(), server.URL, sha256Hex(archive), downloadOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -157,7 +169,7 @@ func TestDownloadZipWithSingleFile(t *testing.T) {
 	archive := zipArchive(t, map[string][]byte{"custom-packer-fork": binary})
 	server, _ := serveArtifact(t, archive)
 
-	path, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "")
+	path, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -178,15 +190,15 @@ func TestDownloadZipWithoutIdentifiableBinary(t *testing.T) {
 	})
 	server, _ := serveArtifact(t, archive)
 
-	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, ""); err == nil {
+	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{}); err == nil {
 		t.Error("expected error for archive without identifiable binary")
 	}
 }
 
 func TestDownloadRejectsUnsupportedScheme(t *testing.T) {
 	useTempCacheDir(t)
-	for _, rawURL := range []string{"file:///usr/bin/packer", "ftp://example.com/packer"} {
-		if _, err := ensureDownloadedPackerBinary(context.Background(), rawURL, ""); err == nil {
+	for _, rawURL := range []string{"ftp://example.com/packer", "gopher://example.com/packer"} {
+		if _, err := ensureDownloadedPackerBinary(context.Background(), rawURL, "", downloadOptions{}); err == nil {
 			t.Errorf("expected error for URL %q", rawURL)
 		}
 	}
@@ -199,7 +211,7 @@ func TestDownloadFailsOnHTTPError(t *testing.T) {
 	}))
 	t.Cleanup(server.Close)
 
-	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, ""); err == nil {
+	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{}); err == nil {
 		t.Error("expected error for HTTP 404 response")
 	}
 }
@@ -210,13 +222,470 @@ func TestChecksumChangeBustsCache(t *testing.T) {
 	server, requests := serveArtifact(t, content)
 	checksum := sha256Hex(content)
 
-	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, ""); err != nil {
+	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{}); err != nil {
 		t.Fatal(err)
 	}
-	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, checksum); err != nil {
+	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, checksum, downloadOptions{}); err != nil {
 		t.Fatal(err)
 	}
 	if requests.Load() != 2 {
 		t.Errorf("expected a fresh download after adding a checksum, got %d requests", requests.Load())
 	}
 }
+
+func tarArchive(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for name, content := range files {
+		if err := w.WriteHeader(&tar.Header{Name: name, Mode: 0o755, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func compress(t *testing.T, format archiveFormat, content []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch format {
+	case archiveTar:
+		return content
+	case archiveTarGzip, archiveGzip:
+		w = gzip.NewWriter(&buf)
+	case archiveTarXz, archiveXz:
+		w, err = xz.NewWriter(&buf)
+	case archiveTarZstd, archiveZstd:
+		w, err = zstd.NewWriter(&buf)
+	default:
+		t.Fatalf("unsupported format %s", format)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDownloadTarballs(t *testing.T) {
+	binary := []byte("fake packer binary from tarball")
+	for _, format := range []archiveFormat{archiveTar, archiveTarGzip, archiveTarXz, archiveTarZstd} {
+		t.Run(format.String(), func(t *testing.T) {
+			useTempCacheDir(t)
+			archive := compress(t, format, tarArchive(t, map[string][]byte{
+				"packer_1.9.4/bin/packer": binary,
+				"packer_1.9.4/README.md":  []byte("readme"),
+			}))
+			server, _ := serveArtifact(t, archive)
+
+			path, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, binary) {
+				t.Error("extracted binary content does not match archived content")
+			}
+		})
+	}
+}
+
+func TestDownloadCompressedBinary(t *testing.T) {
+	binary := []byte("fake packer binary compressed on its own")
+	for _, format := range []archiveFormat{archiveGzip, archiveXz, archiveZstd} {
+		t.Run(format.String(), func(t *testing.T) {
+			useTempCacheDir(t)
+			server, _ := serveArtifact(t, compress(t, format, binary))
+
+			path, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := os.ReadFile(path); !bytes.Equal(got, binary) {
+				t.Errorf("decompressed binary = %q, want %q", got, binary)
+			}
+
+			useTempCacheDir(t)
+			if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{
+				ArchivePath: "bin/packer",
+			}); err == nil {
+				t.Error("expected error when archive path is set for a compressed binary")
+			}
+		})
+	}
+}
+
+func TestDownloadArchivePath(t *testing.T) {
+	useTempCacheDir(t)
+	binary := []byte("the real binary")
+	archive := compress(t, archiveTarGzip, tarArchive(t, map[string][]byte{
+		"bin/packer-fork": binary,
+		"bin/helper":      []byte("helper"),
+		"packer":          []byte("not this one"),
+	}))
+	server, _ := serveArtifact(t, archive)
+
+	path, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{
+		ArchivePath: "./bin/packer-fork",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, binary) {
+		t.Error("extracted binary does not match the file at the archive path")
+	}
+
+	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{
+		ArchivePath: "bin/missing",
+	}); err == nil {
+		t.Error("expected error for archive path that does not exist")
+	}
+}
+
+func TestDownloadArchivePathTraversal(t *testing.T) {
+	useTempCacheDir(t)
+	archive := zipArchive(t, map[string][]byte{
+		"../packer": []byte("escaping entry"),
+		"tool":      []byte("tool"),
+	})
+	server, _ := serveArtifact(t, archive)
+
+	for _, archivePath := range []string{"../packer", "/packer", "bin/../../packer"} {
+		if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{
+			ArchivePath: archivePath,
+		}); err == nil {
+			t.Errorf("expected error for archive path %q", archivePath)
+		}
+	}
+
+	// The escaping entry is never selected, leaving the only safe file.
+	path, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "tool" {
+		t.Errorf("extracted %q, want the only safe entry", got)
+	}
+}
+
+func TestDownloadDecompressedSizeLimit(t *testing.T) {
+	useTempCacheDir(t)
+	previous := maxDecompressedArchiveSize
+	maxDecompressedArchiveSize = 1024
+	t.Cleanup(func() { maxDecompressedArchiveSize = previous })
+
+	binary := bytes.Repeat([]byte{0}, 4096)
+	for _, archive := range [][]byte{
+		zipArchive(t, map[string][]byte{"packer": binary}),
+		compress(t, archiveTarGzip, tarArchive(t, map[string][]byte{"packer": binary})),
+	} {
+		server, _ := serveArtifact(t, archive)
+		if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{}); err == nil {
+			t.Error("expected error for archive exceeding the decompressed size limit")
+		} else if !errors.Is(err, errArchiveTooLarge) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestArchivePathRequiresArchive(t *testing.T) {
+	useTempCacheDir(t)
+	server, _ := serveArtifact(t, []byte("raw binary"))
+	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{
+		ArchivePath: "bin/packer",
+	}); err == nil {
+		t.Error("expected error when archive path is set for a raw binary")
+	}
+}
+
+func useFastRetries(t *testing.T) {
+	t.Helper()
+	previous := downloadRetryBaseDelay
+	downloadRetryBaseDelay = time.Millisecond
+	t.Cleanup(func() { downloadRetryBaseDelay = previous })
+}
+
+func TestDownloadRetriesTransientFailures(t *testing.T) {
+	useTempCacheDir(t)
+	useFastRetries(t)
+	content := []byte("fake packer binary")
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if requests.Add(1) <= 2 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(content)
+	}))
+	t.Cleanup(server.Close)
+
+	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{Retries: 1}); err == nil {
+		t.Error("expected failure when retries are exhausted")
+	}
+	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{Retries: 1}); err != nil {
+		t.Errorf("expected success on retry: %v", err)
+	}
+	if requests.Load() != 3 {
+		t.Errorf("expected 3 requests, got %d", requests.Load())
+	}
+}
+
+func TestDownloadDoesNotRetryClientErrors(t *testing.T) {
+	useTempCacheDir(t)
+	useFastRetries(t)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	t.Cleanup(server.Close)
+
+	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{Retries: 3}); err == nil {
+		t.Error("expected error for HTTP 403 response")
+	}
+	if requests.Load() != 1 {
+		t.Errorf("expected a single request for a non-transient error, got %d", requests.Load())
+	}
+}
+
+func TestDownloadResumesInterruptedTransfer(t *testing.T) {
+	useTempCacheDir(t)
+	useFastRetries(t)
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if len(ranges) == 1 {
+			// Announce the full length but drop the connection halfway.
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			_, _ = w.Write(content[:len(content)/2])
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "packer", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+
+	path, err := ensureDownloadedPackerBinary(context.Background(), server.URL, sha256Hex(content), downloadOptions{Retries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("resumed download does not match served content")
+	}
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != "bytes=5000-" {
+		t.Errorf("unexpected Range headers %q", ranges)
+	}
+}
+
+func TestDownloadFallsBackToMirrors(t *testing.T) {
+	useTempCacheDir(t)
+	useFastRetries(t)
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "gone", http.StatusNotFound)
+	}))
+	t.Cleanup(broken.Close)
+	content := []byte("binary from mirror")
+	mirror, requests := serveArtifact(t, content)
+
+	path, err := ensureDownloadedPackerBinary(context.Background(), broken.URL, "", downloadOptions{
+		Mirrors: []string{broken.URL + "/other", mirror.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, content) {
+		t.Error("downloaded binary does not match mirror content")
+	}
+	if requests.Load() != 1 {
+		t.Errorf("expected 1 request to the mirror, got %d", requests.Load())
+	}
+
+	if _, err := ensureDownloadedPackerBinary(context.Background(), broken.URL, "", downloadOptions{
+		Mirrors: []string{"ftp://example.com/packer"},
+	}); err == nil {
+		t.Error("expected error for mirror with unsupported scheme")
+	}
+}
+
+func TestDownloadSendsHeadersAndTrustsCABundle(t *testing.T) {
+	useTempCacheDir(t)
+	content := []byte("private binary")
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write(content)
+	}))
+	t.Cleanup(server.Close)
+	caBundle := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{
+		Headers: map[string]string{"Authorization": "Bearer secret"},
+	}); err == nil {
+		t.Error("expected TLS verification to fail without the CA bundle")
+	}
+	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{
+		CABundle: caBundle,
+	}); err == nil {
+		t.Error("expected authorization to fail without headers")
+	}
+	path, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{
+		Headers:  map[string]string{"Authorization": "Bearer secret"},
+		CABundle: caBundle,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, content) {
+		t.Error("downloaded binary does not match served content")
+	}
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	useTempCacheDir(t)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(release)
+		server.Close()
+	})
+
+	start := time.Now()
+	if _, err := ensureDownloadedPackerBinary(context.Background(), server.URL, "", downloadOptions{
+		Retries: 5,
+		Timeout: 100 * time.Millisecond,
+	}); err == nil {
+		t.Error("expected download to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("timeout was not enforced, download took %s", elapsed)
+	}
+}
+
+func TestDownloadFromLocalSources(t *testing.T) {
+	useTempCacheDir(t)
+	dir := t.TempDir()
+	binary := []byte("binary on a network share")
+	if err := os.WriteFile(filepath.Join(dir, "packer"), binary, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	archive := zipArchive(t, map[string][]byte{"bin/packer": binary, "README": []byte("readme")})
+	if err := os.WriteFile(filepath.Join(dir, "packer.zip"), archive, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tree := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tree, "bin"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tree, "bin", "packer"), binary, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		source   string
+		checksum string
+		opts     downloadOptions
+	}{
+		{source: filepath.Join(dir, "packer"), checksum: sha256Hex(binary)},
+		{source: "file::" + filepath.Join(dir, "packer")},
+		// Archives are verified as artifacts and unpacked by the provider...
+		{source: filepath.Join(dir, "packer.zip"), checksum: sha256Hex(archive)},
+		// ...unless go-getter is asked to unpack them, in which case the
+		// checksum applies to the selected executable.
+		{
+			source:   "file::" + filepath.Join(dir, "packer.zip") + "?archive=zip",
+			checksum: sha256Hex(binary),
+			opts:     downloadOptions{ArchivePath: "bin/packer"},
+		},
+		// Directories are copied, not linked, and searched like archives.
+		{source: tree, checksum: sha256Hex(binary)},
+		{source: "file::" + tree, opts: downloadOptions{ArchivePath: "bin/packer"}},
+	} {
+		path, err := ensureDownloadedPackerBinary(context.Background(), tc.source, tc.checksum, tc.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tc.source, err)
+			continue
+		}
+		if got, _ := os.ReadFile(path); !bytes.Equal(got, binary) {
+			t.Errorf("%s: fetched binary does not match", tc.source)
+		}
+	}
+
+	if _, err := ensureDownloadedPackerBinary(
+		context.Background(), filepath.Join(dir, "packer"), strings.Repeat("00", 32), downloadOptions{},
+	); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected checksum mismatch for local source, got %v", err)
+	}
+	if _, err := ensureDownloadedPackerBinary(
+		context.Background(), filepath.Join(dir, "missing"), "", downloadOptions{},
+	); err == nil {
+		t.Error("expected error for missing local source")
+	}
+}
+
+func TestDownloadFromGitRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	useTempCacheDir(t)
+	repo := t.TempDir()
+	binary := []byte("vendored binary")
+	if err := os.MkdirAll(filepath.Join(repo, "tools", "bin"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "tools", "bin", "packer"), binary, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "vendor packer"},
+		{"tag", "v1.0.0"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	path, err := ensureDownloadedPackerBinary(
+		context.Background(), "git::file://"+filepath.ToSlash(repo)+"?ref=v1.0.0", sha256Hex(binary), downloadOptions{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, binary) {
+		t.Error("fetched binary does not match the vendored one")
+	}
+}
@@ -0,0 +1,259 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// downloadRetryBaseDelay is the wait before the first retry; it doubles with
+// every further attempt up to downloadRetryMaxDelay.
+var downloadRetryBaseDelay = time.Second
+
+const downloadRetryMaxDelay = 30 * time.Second
+
+var errResponseTooLarge = errors.New("response too large")
+
+// validateDownloadURL checks that rawURL is an http(s) URL.
+func validateDownloadURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %v", rawURL, err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme %q: only http and https are supported", parsed.Scheme)
+	}
+	return nil
+}
+
+// httpClient returns the client used for downloads with these options.
+func (o downloadOptions) httpClient() (*http.Client, error) {
+	if strings.TrimSpace(o.CABundle) == "" {
+		return http.DefaultClient, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM([]byte(o.CABundle)) {
+		return nil, fmt.Errorf("CA bundle does not contain any PEM certificates")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return &http.Client{Transport: transport}, nil
+}
+
+func (o downloadOptions) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, o.Timeout)
+}
+
+// downloadTarget receives a download. Partially written content is kept
+// between attempts so that a retry can resume where the last one stopped.
+type downloadTarget interface {
+	io.Writer
+	written() int64
+	reset() error
+}
+
+type fileTarget struct {
+	f *os.File
+	n int64
+}
+
+func (t *fileTarget) Write(p []byte) (int, error) {
+	n, err := t.f.Write(p)
+	t.n += int64(n)
+	return n, err
+}
+
+func (t *fileTarget) written() int64 { return t.n }
+
+func (t *fileTarget) reset() error {
+	if err := t.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := t.f.Seek(0, io.SeekStart)
+	t.n = 0
+	return err
+}
+
+type memoryTarget struct {
+	buf   bytes.Buffer
+	limit int64
+}
+
+func (t *memoryTarget) Write(p []byte) (int, error) {
+	if int64(t.buf.Len()+len(p)) > t.limit {
+		return 0, errResponseTooLarge
+	}
+	return t.buf.Write(p)
+}
+
+func (t *memoryTarget) written() int64 { return int64(t.buf.Len()) }
+
+func (t *memoryTarget) reset() error {
+	t.buf.Reset()
+	return nil
+}
+
+// fetch downloads from the first of urls that succeeds into dst. Transient
+// failures (network errors, 408, 429 and 5xx responses, interrupted
+// transfers) are retried with exponential backoff, resuming partial
+// transfers with a Range request where the server supports it.
+func fetch(ctx context.Context, urls []string, dst downloadTarget, opts downloadOptions) error {
+	client, err := opts.httpClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := opts.withTimeout(ctx)
+	defer cancel()
+
+	var failures []string
+	for _, rawURL := range urls {
+		// Mirrors are not assumed to serve byte-identical partial content.
+		if err := dst.reset(); err != nil {
+			return fmt.Errorf("could not reset download: %v", err)
+		}
+		delay := downloadRetryBaseDelay
+		for attempt := 0; ; attempt++ {
+			retryable, err := fetchOnce(ctx, client, rawURL, dst, opts)
+			if err == nil {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return fmt.Errorf("could not download %s: %v", rawURL, ctx.Err())
+			}
+			if !retryable || attempt >= opts.Retries {
+				failures = append(failures, err.Error())
+				break
+			}
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return fmt.Errorf("could not download %s: %v", rawURL, ctx.Err())
+			}
+			delay = min(delay*2, downloadRetryMaxDelay)
+		}
+	}
+	return errors.New(strings.Join(failures, "; "))
+}
+
+// fetchOnce makes a single download attempt and reports whether a failure
+// is worth retrying.
+func fetchOnce(
+	ctx context.Context, client *http.Client, rawURL string, dst downloadTarget, opts downloadOptions,
+) (retryable bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return false, fmt.Errorf("could not create request for %s: %v", rawURL, err)
+	}
+	req.Header.Set("User-Agent", "terraform-provider-packer")
+	for key, value := range opts.Headers {
+		req.Header.Set(key, value)
+	}
+	offset := dst.written()
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("could not download %s: %v", rawURL, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0 && contentRangeStart(resp) == offset:
+		// Resuming the previous attempt.
+	case resp.StatusCode == http.StatusOK:
+		if offset > 0 {
+			// The server ignored the Range header; start over.
+			if err := dst.reset(); err != nil {
+				return false, fmt.Errorf("could not reset download: %v", err)
+			}
+		}
+	case resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The partial content does not line up with what we have.
+		if err := dst.reset(); err != nil {
+			return false, fmt.Errorf("could not reset download: %v", err)
+		}
+		return true, fmt.Errorf("could not resume download of %s: unexpected status %s", rawURL, resp.Status)
+	default:
+		retryable := resp.StatusCode >= 500 ||
+			resp.StatusCode == http.StatusRequestTimeout ||
+			resp.StatusCode == http.StatusTooManyRequests
+		return retryable, fmt.Errorf("could not download %s: unexpected status %s", rawURL, resp.Status)
+	}
+
+	if _, err := io.Copy(dst, resp.Body); err != nil {
+		if errors.Is(err, errResponseTooLarge) {
+			return false, fmt.Errorf("could not download %s: %v", rawURL, err)
+		}
+		return true, fmt.Errorf("could not download %s: %v", rawURL, err)
+	}
+	return false, nil
+}
+
+// contentRangeStart returns the first byte position of a 206 response, or
+// -1 if the Content-Range header cannot be parsed.
+func contentRangeStart(resp *http.Response) int64 {
+	value, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	if !ok {
+		return -1
+	}
+	start, _, ok := strings.Cut(value, "-")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// downloadToTempFile downloads the artifact from the first working URL into
+// a new temporary file in dir and returns its path.
+func downloadToTempFile(ctx context.Context, urls []string, dir string, opts downloadOptions) (string, error) {
+	tmp, err := os.CreateTemp(dir, "download-*")
+	if err != nil {
+		return "", fmt.Errorf("could not create temporary file in %q: %v", dir, err)
+	}
+	if err := fetch(ctx, urls, &fileTarget{f: tmp}, opts); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("could not finish writing download to %q: %v", tmp.Name(), err)
+	}
+	return tmp.Name(), nil
+}
+
+// downloadToMemory fetches a small file such as a checksum list or a
+// signature. Responses larger than limit bytes are rejected. Mirrors do not
+// apply.
+func downloadToMemory(ctx context.Context, rawURL string, limit int64, opts downloadOptions) ([]byte, error) {
+	if err := validateDownloadURL(rawURL); err != nil {
+		return nil, err
+	}
+	dst := &memoryTarget{limit: limit}
+	if err := fetch(ctx, []string{rawURL}, dst, opts); err != nil {
+		return nil, err
+	}
+	return dst.buf.Bytes(), nil
+}
@@ -0,0 +1,182 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	getter "github.com/hashicorp/go-getter/v2"
+)
+
+// forcedGetterPrefix matches the `git::`, `file::`, ... prefix that forces a
+// go-getter protocol.
+var forcedGetterPrefix = regexp.MustCompile(`^[A-Za-z0-9]+::`)
+
+// isPlainHTTPSource reports whether src is an ordinary http(s) URL that is
+// downloaded by the provider itself (with retries, resume and mirrors). Any
+// other source is handed to go-getter.
+func isPlainHTTPSource(src string) bool {
+	if forcedGetterPrefix.MatchString(src) {
+		return false
+	}
+	parsed, err := url.Parse(src)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return false
+	}
+	// go-getter's magic query parameters only make sense with go-getter.
+	q := parsed.Query()
+	return !q.Has("checksum") && !q.Has("archive") && !q.Has("filename")
+}
+
+// getterSourceRequestsArchive reports whether src asks go-getter to unpack
+// an archive via `?archive=`.
+func getterSourceRequestsArchive(src string) bool {
+	_, query, ok := strings.Cut(forcedGetterPrefix.ReplaceAllString(src, ""), "?")
+	if !ok {
+		return false
+	}
+	values, err := url.ParseQuery(query)
+	return err == nil && values.Get("archive") != ""
+}
+
+// fetchWithGetter retrieves src with go-getter into a new staging directory
+// inside dir. It returns the fetched file, or the directory holding a git
+// checkout or unpacked archive, along with the staging directory that the
+// caller must remove.
+func fetchWithGetter(ctx context.Context, src string, dir string) (result string, staging string, err error) {
+	client := &getter.Client{
+		DisableSymlinks: true,
+		// Archives are only unpacked by go-getter when asked to with
+		// ?archive=; otherwise the artifact is verified and unpacked like
+		// an http download so that checksums keep applying to the artifact.
+		Decompressors: map[string]getter.Decompressor{},
+	}
+	if getterSourceRequestsArchive(src) {
+		client.Decompressors = getter.Decompressors
+	}
+	return fetchWithClient(ctx, client, src, dir)
+}
+
+// fetchWithClient is fetchWithGetter with the go-getter client given.
+//
+// go-getter links a local directory into the destination instead of
+// copying it, whatever Request.Copy says, and the link would lead callers
+// out of the staging directory. Local sources are therefore fetched in
+// place and copied into the staging directory: a file into the directory,
+// as go-getter fetches other files, and a directory as the directory.
+func fetchWithClient(ctx context.Context, client *getter.Client, src string, dir string) (result string, staging string, err error) {
+	staging, err = os.MkdirTemp(dir, "getter-*")
+	if err != nil {
+		return "", "", fmt.Errorf("could not create temporary directory in %q: %v", dir, err)
+	}
+	pwd, err := os.Getwd()
+	if err != nil {
+		_ = os.RemoveAll(staging)
+		return "", "", fmt.Errorf("could not determine working directory: %v", err)
+	}
+
+	dst := filepath.Join(staging, "src")
+	res, err := client.Get(ctx, &getter.Request{
+		Src:     src,
+		Dst:     dst,
+		Pwd:     pwd,
+		GetMode: getter.ModeAny,
+		Copy:    true,
+		Inplace: true,
+	})
+	if err != nil {
+		_ = os.RemoveAll(staging)
+		return "", "", fmt.Errorf("could not fetch %s: %v", src, err)
+	}
+	result = res.Dst
+	if rel, err := filepath.Rel(staging, result); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		target := dst
+		if info, err := os.Stat(result); err == nil && !info.IsDir() {
+			target = filepath.Join(dst, filepath.Base(result))
+		}
+		if err := os.MkdirAll(dst, 0o755); err != nil {
+			_ = os.RemoveAll(staging)
+			return "", "", fmt.Errorf("could not create directory %q: %v", dst, err)
+		}
+		if err := copyTree(result, target); err != nil {
+			_ = os.RemoveAll(staging)
+			return "", "", fmt.Errorf("could not copy %s: %v", src, err)
+		}
+		result = target
+	}
+	return result, staging, nil
+}
+
+// copyTree copies the directories and regular files under src, or src
+// itself if it is a file, to dst. src may be a symbolic link. VCS metadata
+// is skipped.
+func copyTree(src string, dst string) error {
+	src, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			if d.Name() == ".git" || d.Name() == ".hg" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, 0o755)
+		}
+		info, err := d.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, content, info.Mode().Perm())
+	})
+}
+
+// findPackerInDir selects the executable in a fetched directory the same
+// way as inside an archive (see choosePackerEntry). VCS metadata is ignored.
+func findPackerInDir(root string, innerPath string) (string, error) {
+	var names []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" || d.Name() == ".hg" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not list fetched files: %v", err)
+	}
+	chosen, err := choosePackerEntry(names, innerPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, filepath.FromSlash(chosen)), nil
+}
@@ -0,0 +1,127 @@
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"terraform-provider-packer/crypto_util"
+)
+
+// cacheMetadataName is the sidecar file stored next to each cached binary.
+const cacheMetadataName = "metadata.json"
+
+// errCacheMetadataMissing marks cache entries written before sidecars were
+// introduced; they are downloaded again instead of being trusted.
+var errCacheMetadataMissing = errors.New("cache entry has no metadata")
+
+// cacheMetadata records how a cache entry was obtained and the digest of the
+// binary that was verified when it was stored.
+type cacheMetadata struct {
+	Source string `json:"source"`
+	// ArtifactSHA256 is the checksum the downloaded artifact was verified
+	// against, if any.
+	ArtifactSHA256 string `json:"artifact_sha256,omitempty"`
+	// BinarySHA256 is the digest of the cached executable itself.
+	BinarySHA256 string    `json:"binary_sha256"`
+	StoredAt     time.Time `json:"stored_at"`
+}
+
+// writeCacheMetadata stores meta in dir. The file is written to a temporary
+// name first so that readers never see a partial sidecar.
+func writeCacheMetadata(dir string, meta cacheMetadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "metadata-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, cacheMetadataName))
+}
+
+func readCacheMetadata(dir string) (cacheMetadata, error) {
+	var meta cacheMetadata
+	data, err := os.ReadFile(filepath.Join(dir, cacheMetadataName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return meta, errCacheMetadataMissing
+		}
+		return meta, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("could not parse %s: %v", cacheMetadataName, err)
+	}
+	if meta.BinarySHA256 == "" {
+		return meta, fmt.Errorf("%s does not record a binary digest", cacheMetadataName)
+	}
+	return meta, nil
+}
+
+// verifyCacheEntry checks a cached binary before it is reused: the entry
+// must not be writable by other users, and the binary must still hash to
+// the digest recorded when it was stored.
+func verifyCacheEntry(dir string, binary string) error {
+	for _, p := range []string{dir, binary} {
+		if err := checkPrivatePath(p); err != nil {
+			return err
+		}
+	}
+	meta, err := readCacheMetadata(dir)
+	if err != nil {
+		return err
+	}
+	actual, err := crypto_util.FileSHA256(binary)
+	if err != nil {
+		return fmt.Errorf("could not hash cached binary: %v", err)
+	}
+	if actual != meta.BinarySHA256 {
+		return fmt.Errorf(
+			"cached binary %s was modified: expected sha256:%s, got sha256:%s; remove %s to download it again",
+			binary, meta.BinarySHA256, actual, dir,
+		)
+	}
+	return nil
+}
+
+// verifyLocalPackerBinary resolves a packer_binary setting (a path or a name
+// looked up in PATH) and checks that the file matches checksum.
+func verifyLocalPackerBinary(bin string, checksum string) (string, error) {
+	checksum, err := normalizeChecksum(checksum)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := exec.LookPath(bin)
+	if err != nil {
+		return "", fmt.Errorf("could not find %s: %v", bin, err)
+	}
+	actual, err := crypto_util.FileSHA256(resolved)
+	if err != nil {
+		return "", fmt.Errorf("could not hash %s: %v", resolved, err)
+	}
+	if actual != checksum {
+		return "", fmt.Errorf(
+			"checksum mismatch for %s: expected sha256:%s, got sha256:%s",
+			resolved, checksum, actual,
+		)
+	}
+	return resolved, nil
+}
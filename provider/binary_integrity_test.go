@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCachedBinaryIsRehashedOnReuse(t *testing.T) {
+	useTempCacheDir(t)
+	server, _ := serveArtifact(t, []byte("fake packer"))
+
+	path, err := ensureDownloadedPackerBinary(context.Background(), server.URL+"/packer", "", downloadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(path), cacheMetadataName)); err != nil {
+		t.Fatalf("cache entry has no metadata sidecar: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("tampered"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ensureDownloadedPackerBinary(context.Background(), server.URL+"/packer", "", downloadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "was modified") {
+		t.Fatalf("expected a modified cache entry to be refused, got %v", err)
+	}
+}
+
+func TestCachedBinaryWithoutMetadataIsDownloadedAgain(t *testing.T) {
+	useTempCacheDir(t)
+	content := []byte("fake packer")
+	server, requests := serveArtifact(t, content)
+
+	path, err := ensureDownloadedPackerBinary(context.Background(), server.URL+"/packer", "", downloadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(filepath.Dir(path), cacheMetadataName)); err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := ensureDownloadedPackerBinary(context.Background(), server.URL+"/packer", "", downloadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != path {
+		t.Errorf("re-download returned %q, want %q", again, path)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("expected the entry without metadata to be downloaded again, got %d requests", requests.Load())
+	}
+	if _, err := readCacheMetadata(filepath.Dir(path)); err != nil {
+		t.Errorf("re-downloaded entry has no valid metadata: %v", err)
+	}
+}
+
+func TestCachedBinaryWritableByOthersIsRefused(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits are not checked on Windows")
+	}
+	useTempCacheDir(t)
+	server, _ := serveArtifact(t, []byte("fake packer"))
+
+	path, err := ensureDownloadedPackerBinary(context.Background(), server.URL+"/packer", "", downloadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Dir(path), 0o777); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ensureDownloadedPackerBinary(context.Background(), server.URL+"/packer", "", downloadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "writable by other users") {
+		t.Fatalf("expected a world-writable cache entry to be refused, got %v", err)
+	}
+}
+
+func TestVerifyLocalPackerBinary(t *testing.T) {
+	content := []byte("local packer")
+	bin := filepath.Join(t.TempDir(), downloadedBinaryName())
+	if err := os.WriteFile(bin, content, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	resolved, err := verifyLocalPackerBinary(bin, "sha256:"+sha256Hex(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved != bin {
+		t.Errorf("verifyLocalPackerBinary returned %q, want %q", resolved, bin)
+	}
+
+	_, err = verifyLocalPackerBinary(bin, sha256Hex([]byte("other")))
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected a checksum mismatch, got %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+
+package provider
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkPrivatePath ensures that p belongs to the current user and cannot be
+// modified by anyone else.
+func checkPrivatePath(p string) error {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%s is a symbolic link", p)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is owned by uid %d, not by the current user (uid %d)", p, stat.Uid, os.Getuid())
+	}
+	if info.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("%s is writable by other users (mode %s)", p, info.Mode().Perm())
+	}
+	return nil
+}
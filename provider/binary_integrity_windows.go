@@ -0,0 +1,21 @@
+//go:build windows
+
+package provider
+
+import (
+	"fmt"
+	"os"
+)
+
+// checkPrivatePath rejects symbolic links. Windows ACLs are not inspected;
+// the user cache directory is private to the user by default.
+func checkPrivatePath(p string) error {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%s is a symbolic link", p)
+	}
+	return nil
+}
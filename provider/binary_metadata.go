@@ -0,0 +1,131 @@
+package provider
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"terraform-provider-packer/crypto_util"
+	"terraform-provider-packer/packer_interop"
+)
+
+// binaryInfo is what the provider learns about a Packer binary by running
+// `packer version`.
+type binaryInfo struct {
+	// Version as reported without the "Packer v" prefix, e.g. 1.10.0-mpl.
+	Version string
+	// Output of `packer version`, also set when it failed.
+	Output []byte
+}
+
+// binaryFingerprint identifies the file behind a path cheaply; the digest of
+// the file is only computed again when it changes.
+type binaryFingerprint struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+type binaryProbe struct {
+	once sync.Once
+	info binaryInfo
+	err  error
+}
+
+// binaryInfoCache memoizes binaryInfo per binary for the lifetime of the
+// provider process. Each distinct binary (by digest) is probed once, even
+// when resources ask for it concurrently.
+type binaryInfoCache struct {
+	mu      sync.Mutex
+	digests map[binaryFingerprint]string
+	probes  map[string]*binaryProbe
+}
+
+var binaryInfos = newBinaryInfoCache()
+
+func newBinaryInfoCache() *binaryInfoCache {
+	return &binaryInfoCache{
+		digests: map[binaryFingerprint]string{},
+		probes:  map[string]*binaryProbe{},
+	}
+}
+
+// get returns the metadata of bin, a path or a name looked up in PATH. An
+// empty bin stands for the embedded build.
+func (c *binaryInfoCache) get(bin string) (binaryInfo, error) {
+	embedded := bin == ""
+	exe := bin
+	if embedded {
+		exe, _ = os.Executable()
+	}
+	resolved, err := exec.LookPath(exe)
+	if err != nil {
+		return binaryInfo{}, fmt.Errorf("could not find %s: %v", exe, err)
+	}
+	digest, err := c.digest(resolved)
+	if err != nil {
+		return binaryInfo{}, err
+	}
+	key := digest
+	if embedded {
+		// The provider executable only acts as Packer when re-executed
+		// with the embedded environment.
+		key = "embedded:" + digest
+	}
+
+	c.mu.Lock()
+	probe, ok := c.probes[key]
+	if !ok {
+		probe = &binaryProbe{}
+		c.probes[key] = probe
+	}
+	c.mu.Unlock()
+
+	probe.once.Do(func() {
+		probe.info, probe.err = probeBinary(resolved, embedded)
+	})
+	return probe.info, probe.err
+}
+
+func (c *binaryInfoCache) digest(path string) (string, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	fp := binaryFingerprint{path: path, size: stat.Size(), modTime: stat.ModTime()}
+	c.mu.Lock()
+	digest, ok := c.digests[fp]
+	c.mu.Unlock()
+	if ok {
+		return digest, nil
+	}
+	digest, err = crypto_util.FileSHA256(path)
+	if err != nil {
+		return "", fmt.Errorf("could not hash %s: %v", path, err)
+	}
+	c.mu.Lock()
+	c.digests[fp] = digest
+	c.mu.Unlock()
+	return digest, nil
+}
+
+func probeBinary(exe string, embedded bool) (binaryInfo, error) {
+	env := map[string]string{"CHECKPOINT_DISABLE": "1"}
+	if embedded {
+		env = packer_interop.EnvVars(map[string]string{}, true)
+	}
+	out, err := runCommandWithEnvCapture(exe, env, "version")
+	info := binaryInfo{Output: out}
+	if err != nil {
+		return info, err
+	}
+	if v, parseErr := parsePackerVersion(out); parseErr == nil {
+		info.Version = v.Original()
+	} else {
+		info.Version = strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(string(out), "Packer")), "v")
+	}
+	return info, nil
+}
@@ -0,0 +1,106 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingPacker writes a `packer` script reporting v that appends a line to
+// a log file on every run, and returns the script and the log.
+func countingPacker(t *testing.T, v string) (string, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake Packer binaries are shell scripts")
+	}
+	dir := t.TempDir()
+	log := filepath.Join(dir, "runs.log")
+	bin := filepath.Join(dir, "packer")
+	script := "#!/bin/sh\necho run >> '" + log + "'\necho 'Packer v" + v + "'\n"
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return bin, log
+}
+
+func countRuns(t *testing.T, log string) int {
+	t.Helper()
+	raw, err := os.ReadFile(log)
+	if os.IsNotExist(err) {
+		return 0
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(raw), "run\n")
+}
+
+func TestBinaryInfoIsProbedOnce(t *testing.T) {
+	cache := newBinaryInfoCache()
+	bin, log := countingPacker(t, "1.9.4")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			info, err := cache.get(bin)
+			if err == nil && info.Version != "1.9.4" {
+				t.Errorf("version = %q, want 1.9.4", info.Version)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runs := countRuns(t, log); runs != 1 {
+		t.Errorf("packer version ran %d times, want 1", runs)
+	}
+}
+
+func TestBinaryInfoFollowsChanges(t *testing.T) {
+	cache := newBinaryInfoCache()
+	bin, log := countingPacker(t, "1.9.4")
+	if _, err := cache.get(bin); err != nil {
+		t.Fatal(err)
+	}
+
+	// Same path, different content: the binary was upgraded in place.
+	script := "#!/bin/sh\necho run >> '" + log + "'\necho 'Packer v1.11.0'\n"
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(bin, later, later); err != nil {
+		t.Fatal(err)
+	}
+	info, err := cache.get(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "1.11.0" {
+		t.Errorf("version = %q after replacing the binary, want 1.11.0", info.Version)
+	}
+
+	// A copy with identical content shares the probe.
+	copied := filepath.Join(t.TempDir(), "packer")
+	raw, _ := os.ReadFile(bin)
+	if err := os.WriteFile(copied, raw, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.get(copied); err != nil {
+		t.Fatal(err)
+	}
+	if runs := countRuns(t, log); runs != 2 {
+		t.Errorf("packer version ran %d times, want 2", runs)
+	}
+}
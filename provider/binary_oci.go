@@ -0,0 +1,424 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	ociMediaTypeIndex             = "application/vnd.oci.image.index.v1+json"
+	ociMediaTypeManifest          = "application/vnd.oci.image.manifest.v1+json"
+	dockerMediaTypeList           = "application/vnd.docker.distribution.manifest.list.v2+json"
+	dockerMediaTypeManifest       = "application/vnd.docker.distribution.manifest.v2+json"
+	ociAnnotationTitle            = "org.opencontainers.image.title"
+	maxOCIManifestSize      int64 = 4 << 20
+)
+
+var (
+	ociRepositoryPattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	ociTagPattern        = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	ociDigestPattern     = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// ociReference is a parsed `registry/repository[:tag][@digest]` reference.
+type ociReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// parseOCIReference parses ref. The registry host is mandatory; Docker Hub
+// must be spelled out as docker.io. Without tag or digest, `latest` is used.
+func parseOCIReference(ref string) (ociReference, error) {
+	var r ociReference
+	rest := strings.TrimSpace(ref)
+	if name, digest, ok := strings.Cut(rest, "@"); ok {
+		if !ociDigestPattern.MatchString(digest) {
+			return r, fmt.Errorf("invalid OCI reference %q: digest must be sha256:<64 hex characters>", ref)
+		}
+		r.Digest = digest
+		rest = name
+	}
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		r.Tag = rest[i+1:]
+		rest = rest[:i]
+		if !ociTagPattern.MatchString(r.Tag) {
+			return r, fmt.Errorf("invalid OCI reference %q: invalid tag %q", ref, r.Tag)
+		}
+	}
+	registry, repository, ok := strings.Cut(rest, "/")
+	if !ok || (!strings.ContainsAny(registry, ".:") && registry != "localhost") {
+		return r, fmt.Errorf("invalid OCI reference %q: expected registry/repository[:tag][@digest]", ref)
+	}
+	if !ociRepositoryPattern.MatchString(repository) {
+		return r, fmt.Errorf("invalid OCI reference %q: invalid repository %q", ref, repository)
+	}
+	if registry == "docker.io" {
+		registry = "registry-1.docker.io"
+		if !strings.Contains(repository, "/") {
+			repository = "library/" + repository
+		}
+	}
+	r.Registry, r.Repository = registry, repository
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+	return r, nil
+}
+
+func (r ociReference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// manifestReference is the tag or digest used to request the manifest.
+func (r ociReference) manifestReference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+func (r ociReference) endpoint(kind string, reference string) string {
+	return "https://" + r.Registry + "/v2/" + r.Repository + "/" + kind + "/" + reference
+}
+
+// ociAuth holds the registry credentials. Username and Password are used for
+// basic auth or exchanged for a bearer token, as the registry demands;
+// Token is sent as a bearer token directly.
+type ociAuth struct {
+	Username string
+	Password string
+	Token    string
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations"`
+	Platform    *ociPlatform      `json:"platform"`
+}
+
+type ociPlatform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+}
+
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// ociRegistryClient talks to the distribution API of one repository and
+// remembers the Authorization header that the registry accepted.
+type ociRegistryClient struct {
+	client        *http.Client
+	ref           ociReference
+	auth          ociAuth
+	authorization string
+}
+
+func newOCIRegistryClient(ref ociReference, auth ociAuth, opts downloadOptions) (*ociRegistryClient, error) {
+	client, err := opts.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	c := &ociRegistryClient{client: client, ref: ref, auth: auth}
+	if auth.Token != "" {
+		c.authorization = "Bearer " + auth.Token
+	}
+	return c, nil
+}
+
+// get performs a GET request, answering an authentication challenge once.
+func (c *ociRegistryClient) get(ctx context.Context, rawURL string, accept []string) (*http.Response, error) {
+	resp, err := c.send(ctx, rawURL, accept)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || c.auth.Token != "" {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	_ = resp.Body.Close()
+	if err := c.authenticate(ctx, challenge); err != nil {
+		return nil, err
+	}
+	return c.send(ctx, rawURL, accept)
+}
+
+func (c *ociRegistryClient) send(ctx context.Context, rawURL string, accept []string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "terraform-provider-packer")
+	if len(accept) > 0 {
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+	}
+	if c.authorization != "" {
+		req.Header.Set("Authorization", c.authorization)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach registry %s: %v", c.ref.Registry, err)
+	}
+	return resp, nil
+}
+
+// authenticate answers a WWW-Authenticate challenge with basic auth or by
+// fetching a bearer token from the advertised realm.
+func (c *ociRegistryClient) authenticate(ctx context.Context, challenge string) error {
+	scheme, params := parseAuthChallenge(challenge)
+	switch scheme {
+	case "basic":
+		if c.auth.Username == "" {
+			return fmt.Errorf("registry %s requires credentials", c.ref.Registry)
+		}
+		c.authorization = "Basic " + basicCredentials(c.auth.Username, c.auth.Password)
+		return nil
+	case "bearer":
+		token, err := c.fetchToken(ctx, params)
+		if err != nil {
+			return err
+		}
+		c.authorization = "Bearer " + token
+		return nil
+	default:
+		return fmt.Errorf("registry %s requires unsupported authentication %q", c.ref.Registry, challenge)
+	}
+}
+
+func (c *ociRegistryClient) fetchToken(ctx context.Context, params map[string]string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Scheme != "https" {
+		return "", fmt.Errorf("registry %s sent an invalid token realm %q", c.ref.Registry, params["realm"])
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + c.ref.Repository + ":pull"
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "terraform-provider-packer")
+	if c.auth.Username != "" {
+		req.SetBasicAuth(c.auth.Username, c.auth.Password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not obtain a registry token from %s: %v", realm.Host, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not obtain a registry token from %s: unexpected status %s", realm.Host, resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxOCIManifestSize)).Decode(&body); err != nil {
+		return "", fmt.Errorf("could not parse registry token response: %v", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", fmt.Errorf("registry token response from %s contains no token", realm.Host)
+}
+
+// parseAuthChallenge splits `Bearer realm="...",service="..."` into the
+// lower-cased scheme and its parameters.
+func parseAuthChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				break
+			}
+			params[key] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+		}
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return strings.ToLower(scheme), params
+}
+
+func basicCredentials(username string, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
+
+// fetchManifest retrieves the manifest or index at reference and checks it
+// against reference when that is a digest.
+func (c *ociRegistryClient) fetchManifest(ctx context.Context, reference string) (ociManifest, error) {
+	var m ociManifest
+	accept := []string{ociMediaTypeIndex, ociMediaTypeManifest, dockerMediaTypeList, dockerMediaTypeManifest}
+	resp, err := c.get(ctx, c.ref.endpoint("manifests", reference), accept)
+	if err != nil {
+		return m, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return m, fmt.Errorf("could not fetch manifest %s of %s: unexpected status %s", reference, c.ref, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxOCIManifestSize+1))
+	if err != nil {
+		return m, fmt.Errorf("could not read manifest of %s: %v", c.ref, err)
+	}
+	if int64(len(body)) > maxOCIManifestSize {
+		return m, fmt.Errorf("manifest of %s exceeds %d bytes", c.ref, maxOCIManifestSize)
+	}
+	if strings.HasPrefix(reference, "sha256:") {
+		sum := sha256.Sum256(body)
+		if actual := "sha256:" + hex.EncodeToString(sum[:]); actual != reference {
+			return m, fmt.Errorf("manifest digest mismatch for %s: expected %s, got %s", c.ref, reference, actual)
+		}
+	}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return m, fmt.Errorf("could not parse manifest of %s: %v", c.ref, err)
+	}
+	if m.MediaType == "" {
+		m.MediaType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	}
+	return m, nil
+}
+
+// resolveLayer finds the layer holding the Packer binary for goos/goarch,
+// following an image index to the matching platform manifest first.
+func (c *ociRegistryClient) resolveLayer(ctx context.Context, goos string, goarch string) (ociDescriptor, error) {
+	m, err := c.fetchManifest(ctx, c.ref.manifestReference())
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	if m.MediaType == ociMediaTypeIndex || m.MediaType == dockerMediaTypeList || len(m.Manifests) > 0 {
+		var platforms []string
+		var match *ociDescriptor
+		for i, d := range m.Manifests {
+			if d.Platform == nil {
+				continue
+			}
+			platforms = append(platforms, platformKey(d.Platform.OS, d.Platform.Architecture))
+			if d.Platform.OS == goos && d.Platform.Architecture == goarch && match == nil {
+				match = &m.Manifests[i]
+			}
+		}
+		if match == nil {
+			return ociDescriptor{}, fmt.Errorf(
+				"%s has no manifest for the current platform %s (available: %s)",
+				c.ref, platformKey(goos, goarch), strings.Join(platforms, ", "),
+			)
+		}
+		if m, err = c.fetchManifest(ctx, match.Digest); err != nil {
+			return ociDescriptor{}, err
+		}
+	}
+	return chooseOCILayer(m.Layers, goos, goarch)
+}
+
+// chooseOCILayer picks the only layer, or else the one titled `packer` (or
+// `packer.exe`) or whose title names the platform, e.g.
+// packer_linux_amd64.zip.
+func chooseOCILayer(layers []ociDescriptor, goos string, goarch string) (ociDescriptor, error) {
+	if len(layers) == 1 {
+		return layers[0], nil
+	}
+	var titles []string
+	var matches []ociDescriptor
+	for _, l := range layers {
+		title := l.Annotations[ociAnnotationTitle]
+		titles = append(titles, title)
+		if title == "packer" || title == "packer.exe" || strings.Contains(title, platformKey(goos, goarch)) {
+			matches = append(matches, l)
+		}
+	}
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+	return ociDescriptor{}, fmt.Errorf(
+		"could not identify the Packer layer for %s among %d layers (titles: %s)",
+		platformKey(goos, goarch), len(layers), strings.Join(titles, ", "),
+	)
+}
+
+// ensureOCIPackerBinary pulls the Packer binary for the current platform from
+// the OCI artifact at ref and stores it in the download cache. The layer is
+// verified against its digest and, when set, against checksum. The layer may
+// hold a raw executable or an archive, like packer_binary_url.
+func ensureOCIPackerBinary(
+	ctx context.Context, ref string, checksum string, auth ociAuth, opts downloadOptions,
+) (string, error) {
+	parsed, err := parseOCIReference(ref)
+	if err != nil {
+		return "", err
+	}
+	client, err := newOCIRegistryClient(parsed, auth, opts)
+	if err != nil {
+		return "", err
+	}
+	goos, goarch := currentPlatform()
+	layer, err := client.resolveLayer(ctx, goos, goarch)
+	if err != nil {
+		return "", err
+	}
+	digest, ok := strings.CutPrefix(layer.Digest, "sha256:")
+	if !ok || !ociDigestPattern.MatchString(layer.Digest) {
+		return "", fmt.Errorf("layer of %s has unsupported digest %q", parsed, layer.Digest)
+	}
+	if checksum != "" {
+		if checksum, err = normalizeChecksum(checksum); err != nil {
+			return "", err
+		}
+		if checksum != digest {
+			return "", fmt.Errorf("checksum mismatch for %s: expected sha256:%s, layer digest is sha256:%s", parsed, checksum, digest)
+		}
+	}
+
+	// Blobs are content-addressed, so the blob URL and its digest make an
+	// immutable cache key and the regular download path can fetch it.
+	if client.authorization != "" {
+		headers := maps.Clone(opts.Headers)
+		if headers == nil {
+			headers = map[string]string{}
+		}
+		headers["Authorization"] = client.authorization
+		opts.Headers = headers
+	}
+	return ensureDownloadedPackerBinary(ctx, parsed.endpoint("blobs", layer.Digest), digest, opts)
+}
@@ -0,0 +1,326 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testRegistry is an in-process stand-in for an OCI distribution registry.
+type testRegistry struct {
+	t         *testing.T
+	server    *httptest.Server
+	auth      string // "", "basic" or "bearer"
+	username  string
+	password  string
+	mu        sync.Mutex
+	manifests map[string][]byte
+	types     map[string]string
+	blobs     map[string][]byte
+	requests  map[string]int
+}
+
+func newTestRegistry(t *testing.T, auth string) *testRegistry {
+	t.Helper()
+	r := &testRegistry{
+		t:         t,
+		auth:      auth,
+		username:  "robot",
+		password:  "hunter2",
+		manifests: map[string][]byte{},
+		types:     map[string]string{},
+		blobs:     map[string][]byte{},
+		requests:  map[string]int{},
+	}
+	r.server = httptest.NewTLSServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *testRegistry) host() string {
+	return strings.TrimPrefix(r.server.URL, "https://")
+}
+
+func (r *testRegistry) opts() downloadOptions {
+	caBundle := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: r.server.Certificate().Raw}))
+	return downloadOptions{CABundle: caBundle}
+}
+
+func (r *testRegistry) credentials() ociAuth {
+	return ociAuth{Username: r.username, Password: r.password}
+}
+
+func (r *testRegistry) count(kind string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests[kind]
+}
+
+func (r *testRegistry) addBlob(content []byte, title string) ociDescriptor {
+	digest := "sha256:" + sha256Hex(content)
+	r.blobs[digest] = content
+	d := ociDescriptor{MediaType: "application/octet-stream", Digest: digest, Size: int64(len(content))}
+	if title != "" {
+		d.Annotations = map[string]string{ociAnnotationTitle: title}
+	}
+	return d
+}
+
+// addManifest stores m under its digest and, when tag is set, under tag.
+func (r *testRegistry) addManifest(tag string, mediaType string, m any) ociDescriptor {
+	body, err := json.Marshal(m)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	digest := "sha256:" + sha256Hex(body)
+	for _, ref := range []string{digest, tag} {
+		if ref != "" {
+			r.manifests[ref] = body
+			r.types[ref] = mediaType
+		}
+	}
+	return ociDescriptor{MediaType: mediaType, Digest: digest, Size: int64(len(body))}
+}
+
+func (r *testRegistry) addImage(tag string, layers ...ociDescriptor) ociDescriptor {
+	return r.addManifest(tag, ociMediaTypeManifest, map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     ociMediaTypeManifest,
+		"layers":        layers,
+	})
+}
+
+func (r *testRegistry) authorized(req *http.Request) bool {
+	switch r.auth {
+	case "basic":
+		user, pass, ok := req.BasicAuth()
+		return ok && user == r.username && pass == r.password
+	case "bearer":
+		return req.Header.Get("Authorization") == "Bearer registry-token"
+	}
+	return true
+}
+
+func (r *testRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		user, pass, ok := req.BasicAuth()
+		if !ok || user != r.username || pass != r.password {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+		if got := req.URL.Query().Get("scope"); got != "repository:tools/packer:pull" {
+			http.Error(w, "unexpected scope "+got, http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"token":"registry-token"}`))
+		return
+	}
+	if !r.authorized(req) {
+		switch r.auth {
+		case "basic":
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+		case "bearer":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+				`Bearer realm="%s/token",service="test-registry",scope="repository:tools/packer:pull"`, r.server.URL,
+			))
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rest, ok := strings.CutPrefix(req.URL.Path, "/v2/tools/packer/")
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	kind, ref, _ := strings.Cut(rest, "/")
+	r.mu.Lock()
+	r.requests[kind]++
+	r.mu.Unlock()
+	switch kind {
+	case "manifests":
+		body, ok := r.manifests[ref]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", r.types[ref])
+		_, _ = w.Write(body)
+	case "blobs":
+		body, ok := r.blobs[ref]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		_, _ = w.Write(body)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func usePlatform(t *testing.T, goos string, goarch string) {
+	t.Helper()
+	previous := currentPlatform
+	currentPlatform = func() (string, string) { return goos, goarch }
+	t.Cleanup(func() { currentPlatform = previous })
+}
+
+func TestParseOCIReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	cases := []struct {
+		ref  string
+		want ociReference
+	}{
+		{"registry.local/tools/packer:1.9.4", ociReference{Registry: "registry.local", Repository: "tools/packer", Tag: "1.9.4"}},
+		{"registry.local:5000/packer", ociReference{Registry: "registry.local:5000", Repository: "packer", Tag: "latest"}},
+		{"localhost/tools/packer@" + digest, ociReference{Registry: "localhost", Repository: "tools/packer", Digest: digest}},
+		{"docker.io/packer:1.9", ociReference{Registry: "registry-1.docker.io", Repository: "library/packer", Tag: "1.9"}},
+	}
+	for _, c := range cases {
+		got, err := parseOCIReference(c.ref)
+		if err != nil {
+			t.Errorf("parseOCIReference(%q) returned error: %v", c.ref, err)
+		} else if got != c.want {
+			t.Errorf("parseOCIReference(%q) = %+v, want %+v", c.ref, got, c.want)
+		}
+	}
+	for _, ref := range []string{"packer:1.9", "tools/packer", "registry.local/Tools/packer", "registry.local/packer@sha256:abc"} {
+		if _, err := parseOCIReference(ref); err == nil {
+			t.Errorf("parseOCIReference(%q) should have failed", ref)
+		}
+	}
+}
+
+func TestParseAuthChallenge(t *testing.T) {
+	scheme, params := parseAuthChallenge(`Bearer realm="https://auth.example.com/token",service="registry",scope="repository:a/b:pull,push"`)
+	if scheme != "bearer" {
+		t.Errorf("scheme = %q, want bearer", scheme)
+	}
+	want := map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry",
+		"scope":   "repository:a/b:pull,push",
+	}
+	for key, value := range want {
+		if params[key] != value {
+			t.Errorf("params[%q] = %q, want %q", key, params[key], value)
+		}
+	}
+}
+
+func TestOCIPullAnonymous(t *testing.T) {
+	useTempCacheDir(t)
+	registry := newTestRegistry(t, "")
+	content := []byte("fake packer from a registry")
+	registry.addImage("1.9.4", registry.addBlob(content, "packer"))
+	ref := registry.host() + "/tools/packer:1.9.4"
+
+	path, err := ensureOCIPackerBinary(context.Background(), ref, "", ociAuth{}, registry.opts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, content) {
+		t.Error("pulled binary does not match the layer content")
+	}
+
+	again, err := ensureOCIPackerBinary(context.Background(), ref, "", ociAuth{}, registry.opts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != path {
+		t.Errorf("second pull returned %q, want cached %q", again, path)
+	}
+	if registry.count("blobs") != 1 {
+		t.Errorf("expected the layer to be downloaded once, got %d blob requests", registry.count("blobs"))
+	}
+}
+
+func TestOCIPullIndexWithBearerAuth(t *testing.T) {
+	useTempCacheDir(t)
+	usePlatform(t, "linux", "amd64")
+	registry := newTestRegistry(t, "bearer")
+	linux := []byte("linux packer")
+	darwin := registry.addImage("", registry.addBlob([]byte("darwin packer"), "packer"))
+	darwin.Platform = &ociPlatform{OS: "darwin", Architecture: "arm64"}
+	linuxManifest := registry.addImage("", registry.addBlob(linux, "packer"))
+	linuxManifest.Platform = &ociPlatform{OS: "linux", Architecture: "amd64"}
+	registry.addManifest("1.9.4", ociMediaTypeIndex, map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     ociMediaTypeIndex,
+		"manifests":     []ociDescriptor{darwin, linuxManifest},
+	})
+	ref := registry.host() + "/tools/packer:1.9.4"
+
+	if _, err := ensureOCIPackerBinary(context.Background(), ref, "", ociAuth{}, registry.opts()); err == nil {
+		t.Error("expected an anonymous pull from an authenticated registry to fail")
+	}
+	path, err := ensureOCIPackerBinary(context.Background(), ref, "", registry.credentials(), registry.opts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, linux) {
+		t.Errorf("pulled %q, want the linux_amd64 layer", got)
+	}
+
+	usePlatform(t, "windows", "arm64")
+	_, err = ensureOCIPackerBinary(context.Background(), ref, "", registry.credentials(), registry.opts())
+	if err == nil || !strings.Contains(err.Error(), "no manifest for the current platform windows_arm64") {
+		t.Errorf("expected a missing platform error, got %v", err)
+	}
+}
+
+func TestOCIPullBasicAuthWithTitledLayers(t *testing.T) {
+	useTempCacheDir(t)
+	usePlatform(t, "linux", "amd64")
+	registry := newTestRegistry(t, "basic")
+	content := []byte("linux packer")
+	registry.addImage("latest",
+		registry.addBlob([]byte("readme"), "README.md"),
+		registry.addBlob(zipArchive(t, map[string][]byte{"packer": content}), "packer_linux_amd64.zip"),
+	)
+	ref := registry.host() + "/tools/packer"
+
+	path, err := ensureOCIPackerBinary(context.Background(), ref, "", registry.credentials(), registry.opts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, content) {
+		t.Error("pulled binary does not match the packer in the platform layer")
+	}
+}
+
+func TestOCIPullVerifiesDigests(t *testing.T) {
+	useTempCacheDir(t)
+	registry := newTestRegistry(t, "")
+	layer := registry.addBlob([]byte("genuine packer"), "packer")
+	manifest := registry.addImage("1.9.4", layer)
+	registry.blobs[layer.Digest] = []byte("tampered packer")
+
+	_, err := ensureOCIPackerBinary(context.Background(), registry.host()+"/tools/packer:1.9.4", "", ociAuth{}, registry.opts())
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected a tampered layer to be rejected, got %v", err)
+	}
+
+	_, err = ensureOCIPackerBinary(
+		context.Background(), registry.host()+"/tools/packer:1.9.4", sha256Hex([]byte("other")), ociAuth{}, registry.opts(),
+	)
+	if err == nil || !strings.Contains(err.Error(), "layer digest") {
+		t.Errorf("expected packer_binary_checksum to be compared with the layer digest, got %v", err)
+	}
+
+	registry.manifests[manifest.Digest] = []byte(`{"schemaVersion":2,"layers":[]}`)
+	_, err = ensureOCIPackerBinary(
+		context.Background(), registry.host()+"/tools/packer@"+manifest.Digest, "", ociAuth{}, registry.opts(),
+	)
+	if err == nil || !strings.Contains(err.Error(), "manifest digest mismatch") {
+		t.Errorf("expected a tampered manifest to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package provider
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// currentPlatform is the `os_arch` key of the running provider, e.g.
+// linux_amd64, used to pick per-platform downloads.
+var currentPlatform = func() (goos string, goarch string) {
+	return runtime.GOOS, runtime.GOARCH
+}
+
+func platformKey(goos string, goarch string) string {
+	return goos + "_" + goarch
+}
+
+// expandBinaryURL substitutes the {os}, {arch} and {version} placeholders
+// in a download URL. Using {version} requires version to be set.
+func expandBinaryURL(rawURL string, goos string, goarch string, version string) (string, error) {
+	if strings.Contains(rawURL, "{version}") && version == "" {
+		return "", fmt.Errorf("URL %q uses the {version} placeholder, but packer_binary_version is not set", rawURL)
+	}
+	return strings.NewReplacer(
+		"{os}", goos,
+		"{arch}", goarch,
+		"{version}", strings.TrimPrefix(version, "v"),
+	).Replace(rawURL), nil
+}
+
+// checksumForPlatform selects the checksum for platform (an `os_arch` key)
+// from a packer_binary_checksums map.
+func checksumForPlatform(checksums map[string]string, platform string) (string, error) {
+	if checksum, ok := checksums[platform]; ok {
+		return checksum, nil
+	}
+	available := make([]string, 0, len(checksums))
+	for key := range checksums {
+		available = append(available, key)
+	}
+	sort.Strings(available)
+	return "", fmt.Errorf(
+		"packer_binary_checksums has no entry for the current platform %s (configured: %s)",
+		platform, strings.Join(available, ", "),
+	)
+}
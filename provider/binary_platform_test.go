@@ -0,0 +1,47 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandBinaryURL(t *testing.T) {
+	got, err := expandBinaryURL(
+		"https://example.com/{version}/packer_{version}_{os}_{arch}.zip", "darwin", "arm64", "v1.9.4",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://example.com/1.9.4/packer_1.9.4_darwin_arm64.zip"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := expandBinaryURL("https://example.com/packer_{version}.zip", "linux", "amd64", ""); err == nil {
+		t.Error("expected error for {version} placeholder without a version")
+	}
+	if got, _ := expandBinaryURL("", "linux", "amd64", ""); got != "" {
+		t.Errorf("empty URL expanded to %q", got)
+	}
+}
+
+func TestChecksumForPlatform(t *testing.T) {
+	checksums := map[string]string{
+		"linux_amd64":  strings.Repeat("aa", 32),
+		"darwin_arm64": strings.Repeat("bb", 32),
+	}
+	got, err := checksumForPlatform(checksums, platformKey("darwin", "arm64"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != strings.Repeat("bb", 32) {
+		t.Errorf("got checksum %q", got)
+	}
+
+	_, err = checksumForPlatform(checksums, platformKey("windows", "amd64"))
+	if err == nil {
+		t.Fatal("expected error for platform without checksum")
+	}
+	if !strings.Contains(err.Error(), "windows_amd64") || !strings.Contains(err.Error(), "darwin_arm64, linux_amd64") {
+		t.Errorf("error should name the missing and configured platforms: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package provider
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+)
+
+// binaryResolver defers providing the provider's Packer binaries, which may
+// mean downloads, until the first operation that runs Packer. Resolution
+// happens once per provider; its errors are reported against every operation
+// that needs a binary, its warnings only against the first one.
+type binaryResolver struct {
+	once     sync.Once
+	resolve  func(ctx context.Context, diags *diag.Diagnostics) providerSettings
+	settings providerSettings
+	diags    diag.Diagnostics
+}
+
+func newBinaryResolver(resolve func(ctx context.Context, diags *diag.Diagnostics) providerSettings) *binaryResolver {
+	return &binaryResolver{resolve: resolve}
+}
+
+// get resolves the binaries on first use. A nil resolver, as seen before the
+// provider is configured, selects the embedded build.
+func (r *binaryResolver) get(ctx context.Context) (providerSettings, diag.Diagnostics) {
+	if r == nil {
+		return providerSettings{}, nil
+	}
+	first := false
+	r.once.Do(func() {
+		first = true
+		r.settings = r.resolve(ctx, &r.diags)
+	})
+	if first {
+		return r.settings, r.diags
+	}
+	return r.settings, r.diags.Errors()
+}
@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+)
+
+func TestBinaryResolverResolvesOnce(t *testing.T) {
+	var calls atomic.Int32
+	resolver := newBinaryResolver(func(_ context.Context, diags *diag.Diagnostics) providerSettings {
+		calls.Add(1)
+		diags.AddWarning("No Packer binary discovered", "falling back")
+		return providerSettings{PackerBinary: "/usr/bin/packer"}
+	})
+	if calls.Load() != 0 {
+		t.Fatal("binaries were resolved before an operation needed them")
+	}
+
+	var wg sync.WaitGroup
+	var warnings atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			settings, diags := resolver.get(context.Background())
+			if settings.PackerBinary != "/usr/bin/packer" {
+				t.Errorf("PackerBinary = %q, want /usr/bin/packer", settings.PackerBinary)
+			}
+			warnings.Add(int32(diags.WarningsCount()))
+		}()
+	}
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Errorf("resolved %d times, want once", calls.Load())
+	}
+	if warnings.Load() != 1 {
+		t.Errorf("the warning was reported %d times, want once", warnings.Load())
+	}
+}
+
+func TestBinaryResolverReportsErrorsToEveryOperation(t *testing.T) {
+	resolver := newBinaryResolver(func(_ context.Context, diags *diag.Diagnostics) providerSettings {
+		diags.AddWarning("Failed to prune Packer binary cache", "permission denied")
+		diags.AddError("Failed to download Packer binary", "mirror unreachable")
+		return providerSettings{}
+	})
+	for i := 0; i < 2; i++ {
+		_, diags := resolver.get(context.Background())
+		if diags.ErrorsCount() != 1 {
+			t.Errorf("operation %d got %d errors, want 1", i, diags.ErrorsCount())
+		}
+	}
+}
+
+func TestNilBinaryResolverSelectsEmbedded(t *testing.T) {
+	var resolver *binaryResolver
+	settings, diags := resolver.get(context.Background())
+	if diags.HasError() || settings.PackerBinary != "" {
+		t.Errorf("got %+v, %v; want the embedded build", settings, diags)
+	}
+}
@@ -0,0 +1,95 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// embeddedBinaryName selects the embedded Packer build in a `binary`
+// attribute, regardless of the provider's default binary.
+const embeddedBinaryName = "embedded"
+
+// namedBinaryModel is an entry of the provider's `binaries` map.
+type namedBinaryModel struct {
+	Path        types.String `tfsdk:"path"`
+	URL         types.String `tfsdk:"url"`
+	Checksum    types.String `tfsdk:"checksum"`
+	ArchivePath types.String `tfsdk:"archive_path"`
+}
+
+// resolveNamedBinary provides the binary described by an entry of the
+// `binaries` map, verifying and downloading it like packer_binary and
+// packer_binary_url. opts carries the provider-wide download settings.
+func resolveNamedBinary(ctx context.Context, name string, m namedBinaryModel, opts downloadOptions) (string, error) {
+	binPath := knownStringValue(m.Path)
+	binURL := knownStringValue(m.URL)
+	checksum := knownStringValue(m.Checksum)
+	archivePath := knownStringValue(m.ArchivePath)
+
+	if name == embeddedBinaryName {
+		return "", fmt.Errorf("the name %q is reserved for the embedded Packer build", embeddedBinaryName)
+	}
+	if (binPath == "") == (binURL == "") {
+		return "", fmt.Errorf("exactly one of path and url must be set")
+	}
+	if archivePath != "" && binURL == "" {
+		return "", fmt.Errorf("archive_path requires url to be set")
+	}
+	if binPath != "" {
+		if checksum == "" {
+			return binPath, nil
+		}
+		return verifyLocalPackerBinary(binPath, checksum)
+	}
+	goos, goarch := currentPlatform()
+	binURL, err := expandBinaryURL(binURL, goos, goarch, "")
+	if err != nil {
+		return "", err
+	}
+	// Mirrors belong to packer_binary_url and do not apply here.
+	opts.Mirrors = nil
+	opts.ArchivePath = archivePath
+	return ensureDownloadedPackerBinary(ctx, binURL, checksum, opts)
+}
+
+// binaryFor returns the executable selected by a `binary` attribute: the
+// provider's default binary when name is empty, the embedded build (an
+// empty path) for "embedded", or an entry of the `binaries` map.
+func (s providerSettings) binaryFor(name string) (string, error) {
+	switch name {
+	case "":
+		return s.PackerBinary, nil
+	case embeddedBinaryName:
+		if !s.AllowEmbedded {
+			return "", fmt.Errorf("binary %q is not available because allow_embedded is false", name)
+		}
+		return "", nil
+	}
+	if bin, ok := s.Binaries[name]; ok {
+		return bin, nil
+	}
+	names := make([]string, 0, len(s.Binaries))
+	for n := range s.Binaries {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return "", fmt.Errorf(
+		"binary %q is not defined in the provider's binaries (defined: %s)", name, strings.Join(names, ", "),
+	)
+}
+
+// packerExecutable returns the executable to run for the binary selected by
+// name; the embedded build is run by re-executing the provider.
+func (s providerSettings) packerExecutable(name string) (string, error) {
+	bin, err := s.binaryFor(name)
+	if err != nil || bin != "" {
+		return bin, err
+	}
+	exe, _ := os.Executable()
+	return exe, nil
+}
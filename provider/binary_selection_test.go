@@ -0,0 +1,81 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestBinaryFor(t *testing.T) {
+	settings := providerSettings{
+		PackerBinary:  "/usr/bin/packer",
+		Binaries:      map[string]string{"packer-1.9": "/opt/packer-1.9/packer", "packer-1.11": "/opt/packer-1.11/packer"},
+		AllowEmbedded: true,
+	}
+	for name, want := range map[string]string{
+		"":           "/usr/bin/packer",
+		"embedded":   "",
+		"packer-1.9": "/opt/packer-1.9/packer",
+	} {
+		got, err := settings.binaryFor(name)
+		if err != nil {
+			t.Errorf("binaryFor(%q) returned error: %v", name, err)
+		} else if got != want {
+			t.Errorf("binaryFor(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	_, err := settings.binaryFor("packer-2")
+	if err == nil || !strings.Contains(err.Error(), "defined: packer-1.11, packer-1.9") {
+		t.Errorf("expected an unknown binary to list the defined ones, got %v", err)
+	}
+
+	settings.AllowEmbedded = false
+	if _, err := settings.binaryFor("embedded"); err == nil {
+		t.Error("expected the embedded build to be unavailable with allow_embedded = false")
+	}
+}
+
+func TestResolveNamedBinary(t *testing.T) {
+	useTempCacheDir(t)
+	content := []byte("fake packer")
+	server, _ := serveArtifact(t, content)
+
+	downloaded, err := resolveNamedBinary(context.Background(), "new", namedBinaryModel{
+		URL:      types.StringValue(server.URL + "/packer_{os}_{arch}"),
+		Checksum: types.StringValue(sha256Hex(content)),
+	}, downloadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(downloaded); !bytes.Equal(got, content) {
+		t.Error("downloaded binary does not match served content")
+	}
+
+	local := filepath.Join(t.TempDir(), "packer")
+	if err := os.WriteFile(local, content, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	_, err = resolveNamedBinary(context.Background(), "local", namedBinaryModel{
+		Path:     types.StringValue(local),
+		Checksum: types.StringValue(sha256Hex([]byte("other"))),
+	}, downloadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected a checksum mismatch for the local binary, got %v", err)
+	}
+
+	for name, m := range map[string]namedBinaryModel{
+		"both":     {Path: types.StringValue(local), URL: types.StringValue(server.URL)},
+		"neither":  {},
+		"embedded": {Path: types.StringValue(local)},
+	} {
+		if _, err := resolveNamedBinary(context.Background(), name, m, downloadOptions{}); err == nil {
+			t.Errorf("resolveNamedBinary(%q) should have failed", name)
+		}
+	}
+}
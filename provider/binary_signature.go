@@ -0,0 +1,326 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxChecksumsFileSize bounds the checksums file and its signature, which
+// are held in memory.
+const maxChecksumsFileSize = 1 << 20
+
+// sshSignatureNamespace is the namespace checksums files must be signed
+// with, i.e. `ssh-keygen -Y sign -n file`.
+const sshSignatureNamespace = "file"
+
+// signatureVerifier checks a detached signature made by a pinned key.
+type signatureVerifier interface {
+	verify(message []byte, signature []byte) error
+	// defaultSignatureSuffix is appended to the checksums URL when no
+	// signature URL is configured.
+	defaultSignatureSuffix() string
+}
+
+// checksumsSource describes a signed SHA256SUMS-style file that lists the
+// checksum of the artifact to download.
+type checksumsSource struct {
+	URL          string
+	SignatureURL string
+	Verifier     signatureVerifier
+}
+
+// newSignatureVerifier builds the verifier for whichever public key is set.
+// Exactly one key must be given.
+func newSignatureVerifier(pgpKey string, sshKey string, minisignKey string) (signatureVerifier, error) {
+	var verifiers []signatureVerifier
+	if pgpKey != "" {
+		v, err := newPGPVerifier(pgpKey)
+		if err != nil {
+			return nil, err
+		}
+		verifiers = append(verifiers, v)
+	}
+	if sshKey != "" {
+		v, err := newSSHVerifier(sshKey)
+		if err != nil {
+			return nil, err
+		}
+		verifiers = append(verifiers, v)
+	}
+	if minisignKey != "" {
+		v, err := newMinisignVerifier(minisignKey)
+		if err != nil {
+			return nil, err
+		}
+		verifiers = append(verifiers, v)
+	}
+	if len(verifiers) != 1 {
+		return nil, fmt.Errorf("expected exactly one public key to verify the checksums file, got %d", len(verifiers))
+	}
+	return verifiers[0], nil
+}
+
+// resolveSignedChecksum downloads the checksums file and its signature,
+// verifies the signature and returns the checksum listed for the file name
+// of artifactURL.
+func resolveSignedChecksum(
+	ctx context.Context, artifactURL string, src checksumsSource, opts downloadOptions,
+) (string, error) {
+	filename, err := artifactFileName(artifactURL)
+	if err != nil {
+		return "", err
+	}
+	sums, err := downloadToMemory(ctx, src.URL, maxChecksumsFileSize, opts)
+	if err != nil {
+		return "", err
+	}
+	signatureURL := src.SignatureURL
+	if signatureURL == "" {
+		signatureURL = src.URL + src.Verifier.defaultSignatureSuffix()
+	}
+	signature, err := downloadToMemory(ctx, signatureURL, maxChecksumsFileSize, opts)
+	if err != nil {
+		return "", err
+	}
+	if err := src.Verifier.verify(sums, signature); err != nil {
+		return "", fmt.Errorf("signature verification of %s failed: %v", src.URL, err)
+	}
+	return checksumForArtifact(sums, filename)
+}
+
+func artifactFileName(rawURL string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %v", rawURL, err)
+	}
+	name := path.Base(parsed.Path)
+	if name == "." || name == "/" {
+		return "", fmt.Errorf("could not determine the artifact file name from %q", rawURL)
+	}
+	return name, nil
+}
+
+// checksumForArtifact finds the line for filename in a checksums file in
+// the format written by sha256sum (`<hex>  <name>` or `<hex> *<name>`).
+func checksumForArtifact(sums []byte, filename string) (string, error) {
+	for _, line := range strings.Split(string(sums), "\n") {
+		sum, name, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		name = strings.TrimPrefix(strings.TrimLeft(name, " "), "*")
+		if strings.TrimPrefix(name, "./") != filename {
+			continue
+		}
+		checksum, err := normalizeChecksum(sum)
+		if err != nil {
+			return "", fmt.Errorf("invalid checksum for %q in checksums file: %v", filename, err)
+		}
+		return checksum, nil
+	}
+	return "", fmt.Errorf("checksums file has no entry for %q", filename)
+}
+
+type pgpVerifier struct {
+	keyring openpgp.EntityList
+}
+
+func newPGPVerifier(armoredKey string) (*pgpVerifier, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armoredKey))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse OpenPGP public key: %v", err)
+	}
+	return &pgpVerifier{keyring: keyring}, nil
+}
+
+func (v *pgpVerifier) verify(message []byte, signature []byte) error {
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN PGP SIGNATURE-----")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(v.keyring, bytes.NewReader(message), bytes.NewReader(signature), nil)
+	} else {
+		_, err = openpgp.CheckDetachedSignature(v.keyring, bytes.NewReader(message), bytes.NewReader(signature), nil)
+	}
+	return err
+}
+
+func (v *pgpVerifier) defaultSignatureSuffix() string {
+	return ".sig"
+}
+
+type sshVerifier struct {
+	key ssh.PublicKey
+}
+
+func newSSHVerifier(authorizedKey string) (*sshVerifier, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse SSH public key: %v", err)
+	}
+	return &sshVerifier{key: key}, nil
+}
+
+const sshSigMagic = "SSHSIG"
+
+// sshSigBlob is the SSHSIG signature format used by `ssh-keygen -Y sign`,
+// following the magic preamble.
+type sshSigBlob struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// sshSignedData is what an SSHSIG signature is computed over, following
+// the magic preamble.
+type sshSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+func (v *sshVerifier) verify(message []byte, signature []byte) error {
+	block, _ := pem.Decode(signature)
+	if block == nil || block.Type != "SSH SIGNATURE" {
+		return fmt.Errorf("not an armored SSH signature")
+	}
+	raw, ok := bytes.CutPrefix(block.Bytes, []byte(sshSigMagic))
+	if !ok {
+		return fmt.Errorf("invalid SSH signature preamble")
+	}
+	var blob sshSigBlob
+	if err := ssh.Unmarshal(raw, &blob); err != nil {
+		return fmt.Errorf("could not parse SSH signature: %v", err)
+	}
+	if blob.Version != 1 {
+		return fmt.Errorf("unsupported SSH signature version %d", blob.Version)
+	}
+	if !bytes.Equal(blob.PublicKey, v.key.Marshal()) {
+		return fmt.Errorf("signature was made by a different key")
+	}
+	if blob.Namespace != sshSignatureNamespace {
+		return fmt.Errorf("signature namespace is %q, expected %q", blob.Namespace, sshSignatureNamespace)
+	}
+
+	var hash []byte
+	switch blob.HashAlgorithm {
+	case "sha256":
+		sum := sha256.Sum256(message)
+		hash = sum[:]
+	case "sha512":
+		sum := sha512.Sum512(message)
+		hash = sum[:]
+	default:
+		return fmt.Errorf("unsupported SSH signature hash algorithm %q", blob.HashAlgorithm)
+	}
+
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(blob.Signature, &sig); err != nil {
+		return fmt.Errorf("could not parse SSH signature: %v", err)
+	}
+	signed := append([]byte(sshSigMagic), ssh.Marshal(sshSignedData{
+		Namespace:     blob.Namespace,
+		Reserved:      blob.Reserved,
+		HashAlgorithm: blob.HashAlgorithm,
+		Hash:          hash,
+	})...)
+	return v.key.Verify(signed, &sig)
+}
+
+func (v *sshVerifier) defaultSignatureSuffix() string {
+	return ".sig"
+}
+
+type minisignVerifier struct {
+	keyID [8]byte
+	key   ed25519.PublicKey
+}
+
+// minisignLines returns the non-empty lines of a minisign key or signature
+// file, skipping untrusted comments.
+func minisignLines(content string) []string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func newMinisignVerifier(publicKey string) (*minisignVerifier, error) {
+	lines := minisignLines(publicKey)
+	if len(lines) != 1 {
+		return nil, fmt.Errorf("could not parse minisign public key: expected a single key line")
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil {
+		return nil, fmt.Errorf("could not parse minisign public key: %v", err)
+	}
+	if len(raw) != 2+8+ed25519.PublicKeySize || string(raw[:2]) != "Ed" {
+		return nil, fmt.Errorf("could not parse minisign public key: unsupported key format")
+	}
+	v := &minisignVerifier{key: ed25519.PublicKey(raw[10:])}
+	copy(v.keyID[:], raw[2:10])
+	return v, nil
+}
+
+func (v *minisignVerifier) verify(message []byte, signature []byte) error {
+	lines := minisignLines(string(signature))
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "trusted comment: ") {
+		return fmt.Errorf("invalid minisign signature file")
+	}
+	sig, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return fmt.Errorf("invalid minisign signature")
+	}
+	if !bytes.Equal(sig[2:10], v.keyID[:]) {
+		return fmt.Errorf("signature was made by a different key")
+	}
+
+	signed := message
+	switch string(sig[:2]) {
+	case "Ed":
+	case "ED":
+		sum := blake2b.Sum512(message)
+		signed = sum[:]
+	default:
+		return fmt.Errorf("unsupported minisign signature algorithm %q", sig[:2])
+	}
+	if !ed25519.Verify(v.key, signed, sig[10:]) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	// The global signature binds the trusted comment to the signature.
+	globalSig, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return fmt.Errorf("invalid minisign global signature")
+	}
+	trustedComment := strings.TrimPrefix(lines[1], "trusted comment: ")
+	globalMessage := append(append([]byte{}, sig[10:]...), trustedComment...)
+	if !ed25519.Verify(v.key, globalMessage, globalSig) {
+		return fmt.Errorf("invalid trusted comment signature")
+	}
+	return nil
+}
+
+func (v *minisignVerifier) defaultSignatureSuffix() string {
+	return ".minisig"
+}
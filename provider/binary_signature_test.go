@@ -0,0 +1,205 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/ssh"
+)
+
+// serveFiles serves the given contents by URL path.
+func serveFiles(t *testing.T, files map[string][]byte) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(content)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testSums() []byte {
+	return []byte(strings.Repeat("11", 32) + "  packer_1.9.4_darwin_arm64.zip\n" +
+		strings.Repeat("22", 32) + " *packer_1.9.4_linux_amd64.zip\n")
+}
+
+func TestChecksumForArtifact(t *testing.T) {
+	got, err := checksumForArtifact(testSums(), "packer_1.9.4_linux_amd64.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != strings.Repeat("22", 32) {
+		t.Errorf("got checksum %q", got)
+	}
+	if _, err := checksumForArtifact(testSums(), "packer_1.9.4_windows_amd64.zip"); err == nil {
+		t.Error("expected error for artifact missing from checksums file")
+	}
+}
+
+func TestResolveSignedChecksumPGP(t *testing.T) {
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var publicKey bytes.Buffer
+	w, err := armor.Encode(&publicKey, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+
+	sums := testSums()
+	var signature bytes.Buffer
+	if err := openpgp.DetachSign(&signature, entity, bytes.NewReader(sums), nil); err != nil {
+		t.Fatal(err)
+	}
+	server := serveFiles(t, map[string][]byte{
+		"/SHA256SUMS":     sums,
+		"/SHA256SUMS.sig": signature.Bytes(),
+		"/tampered":       append(sums, []byte(strings.Repeat("33", 32)+"  other.zip\n")...),
+		"/tampered.sig":   signature.Bytes(),
+	})
+
+	verifier, err := newSignatureVerifier(publicKey.String(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := resolveSignedChecksum(context.Background(), server.URL+"/dist/packer_1.9.4_linux_amd64.zip",
+		checksumsSource{URL: server.URL + "/SHA256SUMS", Verifier: verifier}, downloadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != strings.Repeat("22", 32) {
+		t.Errorf("got checksum %q", got)
+	}
+
+	if _, err := resolveSignedChecksum(context.Background(), server.URL+"/dist/packer_1.9.4_linux_amd64.zip",
+		checksumsSource{URL: server.URL + "/tampered", Verifier: verifier}, downloadOptions{}); err == nil {
+		t.Error("expected signature verification to fail for tampered checksums file")
+	}
+}
+
+// sshSign produces an armored SSHSIG signature like `ssh-keygen -Y sign`.
+func sshSign(t *testing.T, signer ssh.Signer, namespace string, message []byte) []byte {
+	t.Helper()
+	hash := sha512.Sum512(message)
+	signed := append([]byte(sshSigMagic), ssh.Marshal(sshSignedData{
+		Namespace:     namespace,
+		HashAlgorithm: "sha512",
+		Hash:          hash[:],
+	})...)
+	sig, err := signer.Sign(rand.Reader, signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := append([]byte(sshSigMagic), ssh.Marshal(sshSigBlob{
+		Version:       1,
+		PublicKey:     signer.PublicKey().Marshal(),
+		Namespace:     namespace,
+		HashAlgorithm: "sha512",
+		Signature:     ssh.Marshal(sig),
+	})...)
+	return pem.EncodeToMemory(&pem.Block{Type: "SSH SIGNATURE", Bytes: blob})
+}
+
+func TestSSHSignatureVerification(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := newSignatureVerifier("", string(ssh.MarshalAuthorizedKey(signer.PublicKey())), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sums := testSums()
+	if err := verifier.verify(sums, sshSign(t, signer, "file", sums)); err != nil {
+		t.Errorf("valid signature rejected: %v", err)
+	}
+	if err := verifier.verify(sums, sshSign(t, signer, "git", sums)); err == nil {
+		t.Error("expected signature with wrong namespace to be rejected")
+	}
+	if err := verifier.verify(append(sums, '\n'), sshSign(t, signer, "file", sums)); err == nil {
+		t.Error("expected signature over different content to be rejected")
+	}
+}
+
+// minisignSign produces a minisign key and prehashed signature file.
+func minisignSign(t *testing.T, message []byte) (publicKey string, signature []byte) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	publicKey = "untrusted comment: minisign public key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), public...)) + "\n"
+
+	hashed := blake2b.Sum512(message)
+	sig := ed25519.Sign(private, hashed[:])
+	trustedComment := "timestamp:1700000000\tfile:SHA256SUMS"
+	global := ed25519.Sign(private, append(append([]byte{}, sig...), trustedComment...))
+	signature = []byte("untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte("ED"), keyID...), sig...)) + "\n" +
+		"trusted comment: " + trustedComment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n")
+	return publicKey, signature
+}
+
+func TestMinisignSignatureVerification(t *testing.T) {
+	sums := testSums()
+	publicKey, signature := minisignSign(t, sums)
+	verifier, err := newSignatureVerifier("", "", publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifier.defaultSignatureSuffix() != ".minisig" {
+		t.Errorf("unexpected default signature suffix %q", verifier.defaultSignatureSuffix())
+	}
+	if err := verifier.verify(sums, signature); err != nil {
+		t.Errorf("valid signature rejected: %v", err)
+	}
+
+	tamperedComment := bytes.Replace(signature, []byte("timestamp"), []byte("timestomp"), 1)
+	if err := verifier.verify(sums, tamperedComment); err == nil {
+		t.Error("expected signature with tampered trusted comment to be rejected")
+	}
+	if err := verifier.verify(append(sums, '\n'), signature); err == nil {
+		t.Error("expected signature over different content to be rejected")
+	}
+}
+
+func TestNewSignatureVerifierRequiresOneKey(t *testing.T) {
+	if _, err := newSignatureVerifier("", "", ""); err == nil {
+		t.Error("expected error when no key is configured")
+	}
+	publicKey, _ := minisignSign(t, nil)
+	_, private, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(private)
+	if _, err := newSignatureVerifier("", string(ssh.MarshalAuthorizedKey(signer.PublicKey())), publicKey); err == nil {
+		t.Error("expected error when more than one key is configured")
+	}
+}
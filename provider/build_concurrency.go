@@ -0,0 +1,73 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// buildLimiter bounds the Packer builds running in the provider process:
+// at most max builds overall (see max_concurrent_builds) and one build at a
+// time per concurrency_group. A nil limiter does not limit anything.
+type buildLimiter struct {
+	// slots holds a token per running build; nil means unlimited.
+	slots chan struct{}
+
+	mu     sync.Mutex
+	groups map[string]chan struct{}
+}
+
+func newBuildLimiter(max int) *buildLimiter {
+	l := &buildLimiter{groups: map[string]chan struct{}{}}
+	if max > 0 {
+		l.slots = make(chan struct{}, max)
+	}
+	return l
+}
+
+func (l *buildLimiter) group(name string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	g, ok := l.groups[name]
+	if !ok {
+		g = make(chan struct{}, 1)
+		l.groups[name] = g
+	}
+	return g
+}
+
+// acquire waits until a build in group may start and returns the function
+// that ends it. The group is entered before a build slot is taken, so that
+// builds waiting for their group do not keep other groups from running.
+func (l *buildLimiter) acquire(ctx context.Context, group string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	var g chan struct{}
+	if group != "" {
+		g = l.group(group)
+		select {
+		case g <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("canceled while waiting for a build of concurrency group %q to finish: %w", group, ctx.Err())
+		}
+	}
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			if g != nil {
+				<-g
+			}
+			return nil, fmt.Errorf("canceled while waiting for a free build slot (max_concurrent_builds): %w", ctx.Err())
+		}
+	}
+	return func() {
+		if l.slots != nil {
+			<-l.slots
+		}
+		if g != nil {
+			<-g
+		}
+	}, nil
+}
@@ -0,0 +1,93 @@
+package provider
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runBuilds starts a fake build per group in groups and returns the highest
+// number of builds that ran at once, overall and per group.
+func runBuilds(t *testing.T, l *buildLimiter, groups ...string) (int32, map[string]int32) {
+	t.Helper()
+	var running, peak atomic.Int32
+	var mu sync.Mutex
+	perGroup := map[string]int32{}
+	groupPeak := map[string]int32{}
+	var wg sync.WaitGroup
+	for _, group := range groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := l.acquire(context.Background(), group)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer release()
+			now := running.Add(1)
+			for {
+				old := peak.Load()
+				if now <= old || peak.CompareAndSwap(old, now) {
+					break
+				}
+			}
+			mu.Lock()
+			perGroup[group]++
+			groupPeak[group] = max(groupPeak[group], perGroup[group])
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			perGroup[group]--
+			mu.Unlock()
+			running.Add(-1)
+		}()
+	}
+	wg.Wait()
+	return peak.Load(), groupPeak
+}
+
+func TestBuildLimiterBoundsConcurrentBuilds(t *testing.T) {
+	peak, _ := runBuilds(t, newBuildLimiter(2), "", "", "", "", "", "")
+	if peak != 2 {
+		t.Errorf("%d builds ran at once, want 2", peak)
+	}
+}
+
+func TestBuildLimiterSerializesGroups(t *testing.T) {
+	peak, groupPeak := runBuilds(t, newBuildLimiter(0), "esxi", "esxi", "esxi", "aws", "aws", "")
+	if groupPeak["esxi"] != 1 || groupPeak["aws"] != 1 {
+		t.Errorf("builds of a group overlapped: %v", groupPeak)
+	}
+	if peak < 2 {
+		t.Errorf("only %d build ran at once, want different groups to run in parallel", peak)
+	}
+}
+
+func TestBuildLimiterAcquireIsCanceled(t *testing.T) {
+	l := newBuildLimiter(1)
+	release, err := l.acquire(context.Background(), "esxi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx, "aws"); err == nil {
+		t.Error("expected waiting for a build slot to be canceled")
+	}
+	if _, err := l.acquire(ctx, "esxi"); err == nil {
+		t.Error("expected waiting for the group to be canceled")
+	}
+	release()
+	// The canceled attempts must not have left the group or a slot taken.
+	release, err = l.acquire(context.Background(), "aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if _, err := (*buildLimiter)(nil).acquire(context.Background(), "esxi"); err != nil {
+		t.Errorf("a nil limiter should not limit builds, got %v", err)
+	}
+}
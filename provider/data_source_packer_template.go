@@ -0,0 +1,235 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+type dataSourceTemplateType struct {
+	RequiredPlugins []dataSourceTemplateRequiredPlugin `tfsdk:"required_plugin"`
+	Variables       []dataSourceTemplateVariable       `tfsdk:"variable"`
+	Sources         []dataSourceTemplateSource         `tfsdk:"source"`
+	Builds          []dataSourceTemplateBuild          `tfsdk:"build"`
+	Rendered        types.String                       `tfsdk:"rendered"`
+}
+
+type dataSourceTemplateRequiredPlugin struct {
+	Name    types.String `tfsdk:"name"`
+	Source  types.String `tfsdk:"source"`
+	Version types.String `tfsdk:"version"`
+}
+
+type dataSourceTemplateVariable struct {
+	Name        types.String `tfsdk:"name"`
+	Type        types.String `tfsdk:"type"`
+	Default     types.String `tfsdk:"default"`
+	Description types.String `tfsdk:"description"`
+	Sensitive   types.Bool   `tfsdk:"sensitive"`
+}
+
+type dataSourceTemplateSource struct {
+	Type   types.String `tfsdk:"type"`
+	Name   types.String `tfsdk:"name"`
+	Config types.String `tfsdk:"config"`
+}
+
+type dataSourceTemplateBuild struct {
+	Name           types.String               `tfsdk:"name"`
+	Sources        []string                   `tfsdk:"sources"`
+	Provisioners   []dataSourceTemplatePlugin `tfsdk:"provisioner"`
+	PostProcessors []dataSourceTemplatePlugin `tfsdk:"post_processor"`
+}
+
+type dataSourceTemplatePlugin struct {
+	Type   types.String `tfsdk:"type"`
+	Config types.String `tfsdk:"config"`
+}
+
+type dataSourceTemplate struct {
+	p tfProvider
+}
+
+func (d dataSourceTemplate) Metadata(_ context.Context, _ datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	*resp = datasource.MetadataResponse{
+		TypeName: "packer_template",
+	}
+}
+
+func (d dataSourceTemplate) Schema(_ context.Context, _ datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	config := schema.StringAttribute{
+		Description: "Body of the block as a JSON object string, written with `jsonencode()`, e.g. " +
+			"`config = jsonencode({ region = \"eu-west-1\" })`. It is a string rather than a dynamic value, because " +
+			"nested blocks cannot hold dynamic attributes. Lists of objects become repeated nested blocks, e.g. " +
+			"`source_ami_filter = [{ owners = [\"amazon\"] }]`; everything else becomes an attribute. Strings are " +
+			"Packer templates: `$${var.region}` in Terraform refers to a Packer variable, and `$$${var.region}` is " +
+			"the literal `${var.region}`.",
+		Optional: true,
+	}
+	plugin := func(kind string, example string) schema.ListNestedBlock {
+		return schema.ListNestedBlock{
+			Description: "A " + kind + " of the build.",
+			NestedObject: schema.NestedBlockObject{
+				Attributes: map[string]schema.Attribute{
+					"type": schema.StringAttribute{
+						Description: "Type of the " + kind + ", e.g. `" + example + "`.",
+						Required:    true,
+					},
+					"config": config,
+				},
+			},
+		}
+	}
+	*resp = datasource.SchemaResponse{
+		Schema: schema.Schema{
+			Description: "Renders a Packer HCL2 template from Terraform blocks, e.g. for `template_content` of " +
+				"`packer_image`. Block bodies are JSON object strings written with `jsonencode()`, because nested " +
+				"blocks cannot hold dynamic values.",
+			Attributes: map[string]schema.Attribute{
+				"rendered": schema.StringAttribute{
+					Description: "The rendered Packer HCL2 template.",
+					Computed:    true,
+				},
+			},
+			Blocks: map[string]schema.Block{
+				"required_plugin": schema.ListNestedBlock{
+					Description: "Plugin in the template's `required_plugins`.",
+					NestedObject: schema.NestedBlockObject{
+						Attributes: map[string]schema.Attribute{
+							"name": schema.StringAttribute{
+								Description: "Name of the plugin, e.g. `amazon`.",
+								Required:    true,
+							},
+							"source": schema.StringAttribute{
+								Description: "Source address of the plugin, e.g. `github.com/hashicorp/amazon`.",
+								Required:    true,
+							},
+							"version": schema.StringAttribute{
+								Description: "Version constraint of the plugin, e.g. `>= 1.2.0`.",
+								Optional:    true,
+							},
+						},
+					},
+				},
+				"variable": schema.ListNestedBlock{
+					Description: "Input variable of the template.",
+					NestedObject: schema.NestedBlockObject{
+						Attributes: map[string]schema.Attribute{
+							"name": schema.StringAttribute{
+								Description: "Name of the variable.",
+								Required:    true,
+							},
+							"type": schema.StringAttribute{
+								Description: "Type constraint of the variable, e.g. `list(string)`.",
+								Optional:    true,
+							},
+							"default": schema.StringAttribute{
+								Description: "Default value of the variable as JSON, usually written with `jsonencode()`.",
+								Optional:    true,
+							},
+							"description": schema.StringAttribute{
+								Description: "Description of the variable.",
+								Optional:    true,
+							},
+							"sensitive": schema.BoolAttribute{
+								Description: "Whether Packer hides the value of the variable in its output.",
+								Optional:    true,
+							},
+						},
+					},
+				},
+				"source": schema.ListNestedBlock{
+					Description: "Source, i.e. builder configuration, of the template.",
+					NestedObject: schema.NestedBlockObject{
+						Attributes: map[string]schema.Attribute{
+							"type": schema.StringAttribute{
+								Description: "Builder type, e.g. `amazon-ebs`.",
+								Required:    true,
+							},
+							"name": schema.StringAttribute{
+								Description: "Name of the source.",
+								Required:    true,
+							},
+							"config": config,
+						},
+					},
+				},
+				"build": schema.ListNestedBlock{
+					Description: "Build of the template.",
+					NestedObject: schema.NestedBlockObject{
+						Attributes: map[string]schema.Attribute{
+							"name": schema.StringAttribute{
+								Description: "Name of the build.",
+								Optional:    true,
+							},
+							"sources": schema.ListAttribute{
+								Description: "Sources to build, e.g. `source.amazon-ebs.ubuntu`.",
+								ElementType: types.StringType,
+								Required:    true,
+							},
+						},
+						Blocks: map[string]schema.Block{
+							"provisioner":    plugin("provisioner", "shell"),
+							"post_processor": plugin("post-processor", "manifest"),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (d dataSourceTemplate) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	state := dataSourceTemplateType{}
+	diags := req.Config.Get(ctx, &state)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	rendered, err := renderTemplate(state.spec())
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to render Packer template", err.Error())
+		return
+	}
+	state.Rendered = types.StringValue(rendered)
+
+	diags = resp.State.Set(ctx, &state)
+	resp.Diagnostics.Append(diags...)
+}
+
+func (t dataSourceTemplateType) spec() templateSpec {
+	var spec templateSpec
+	for _, p := range t.RequiredPlugins {
+		spec.RequiredPlugins = append(spec.RequiredPlugins, templateRequiredPluginSpec{
+			Name: p.Name.ValueString(), Source: p.Source.ValueString(), Version: p.Version.ValueString(),
+		})
+	}
+	for _, v := range t.Variables {
+		spec.Variables = append(spec.Variables, templateVariableSpec{
+			Name:        v.Name.ValueString(),
+			Type:        v.Type.ValueString(),
+			Default:     v.Default.ValueString(),
+			Description: v.Description.ValueString(),
+			Sensitive:   v.Sensitive.ValueBool(),
+		})
+	}
+	for _, s := range t.Sources {
+		spec.Sources = append(spec.Sources, templateSourceSpec{
+			Type: s.Type.ValueString(), Name: s.Name.ValueString(), Config: s.Config.ValueString(),
+		})
+	}
+	for _, b := range t.Builds {
+		build := templateBuildSpec{Name: b.Name.ValueString(), Sources: b.Sources}
+		for _, p := range b.Provisioners {
+			build.Provisioners = append(build.Provisioners, templatePluginSpec{Type: p.Type.ValueString(), Config: p.Config.ValueString()})
+		}
+		for _, p := range b.PostProcessors {
+			build.PostProcessors = append(build.PostProcessors, templatePluginSpec{Type: p.Type.ValueString(), Config: p.Config.ValueString()})
+		}
+		spec.Builds = append(spec.Builds, build)
+	}
+	return spec
+}
@@ -14,11 +14,14 @@ import (
 	"github.com/hashicorp/terraform-plugin-framework/provider"
 
 	"github.com/hashicorp/terraform-plugin-framework/diag"
-	"github.com/toowoxx/go-lib-userspace-common/cmds"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
 )
 
 type dataSourceVersionType struct {
-	Version string `tfsdk:"version"`
+	Version         types.String `tfsdk:"version"`
+	Binary          types.String `tfsdk:"binary"`
+	PluginDirectory types.String `tfsdk:"plugin_directory"`
 }
 
 func (r dataSourceVersion) Schema(_ context.Context, _ datasource.SchemaRequest, resp *datasource.SchemaResponse) {
@@ -29,6 +32,15 @@ func (r dataSourceVersion) Schema(_ context.Context, _ datasource.SchemaRequest,
 					Description: "Packer version in use",
 					Computed:    true,
 				},
+				"binary": schema.StringAttribute{
+					Description: "Name of an entry of the provider's `binaries` to query, or `embedded` for the " +
+						"embedded Packer build. Defaults to the provider's Packer binary.",
+					Optional: true,
+				},
+				"plugin_directory": schema.StringAttribute{
+					Description: "The provider's `plugin_directory`, or null when Packer uses its own plugin directory.",
+					Computed:    true,
+				},
 			},
 		},
 	}
@@ -41,8 +53,8 @@ func (r dataSourceVersionType) NewDataSource(_ context.Context, p provider.Provi
 }
 
 type dataSourceVersion struct {
-	p            tfProvider
-	packerBinary string
+	p        tfProvider
+	binaries *binaryResolver
 }
 
 func (r dataSourceVersion) Metadata(_ context.Context, _ datasource.MetadataRequest, resp *datasource.MetadataResponse) {
@@ -55,37 +67,52 @@ func (r *dataSourceVersion) Configure(_ context.Context, req datasource.Configur
 	if req.ProviderData == nil {
 		return
 	}
-	if settings, ok := req.ProviderData.(providerSettings); ok {
-		r.packerBinary = settings.PackerBinary
+	if data, ok := req.ProviderData.(*providerData); ok {
+		r.binaries = data.binaries
 	}
 }
 
-func (r dataSourceVersion) Read(ctx context.Context, _ datasource.ReadRequest, resp *datasource.ReadResponse) {
+func (r dataSourceVersion) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
 	resourceState := dataSourceVersionType{}
-	exe := r.packerBinary
-	if exe == "" {
-		exe, _ = os.Executable()
+	diags := req.Config.Get(ctx, &resourceState)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	settings, diags := r.binaries.get(ctx)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
 	}
-	// Pass through current env and disable checkpoint to avoid network calls
-	env := packer_interop.EnvVars(map[string]string{}, true)
-	output, err := cmds.RunCommandWithEnvReturnOutput(exe, env, "version")
+	bin, err := settings.binaryFor(resourceState.Binary.ValueString())
 	if err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root("binary"), "Unknown Packer binary", err.Error())
+		return
+	}
+	info, err := binaryInfos.get(bin)
+	if err != nil {
+		if bin == "" {
+			bin, _ = os.Executable()
+		}
 		resp.Diagnostics.AddError(
 			"Failed to run packer",
-			fmt.Sprintf("Command: %s version\nError: %v\nOutput:\n%s", exe, err, strings.TrimSpace(string(output))),
+			fmt.Sprintf("Command: %s version\nError: %v\nOutput:\n%s", bin, err, strings.TrimSpace(string(info.Output))),
 		)
 		return
 	}
 
-	if len(output) == 0 {
+	if len(info.Output) == 0 {
 		resp.Diagnostics.AddError("Unexpected output", "Packer did not output anything")
 		return
 	}
 
-	resourceState.Version = strings.TrimPrefix(
-		strings.TrimSpace(strings.TrimPrefix(string(output), "Packer")), "v")
+	resourceState.Version = types.StringValue(info.Version)
+	resourceState.PluginDirectory = types.StringNull()
+	if dir := packer_interop.PluginDirectory(); dir != "" {
+		resourceState.PluginDirectory = types.StringValue(dir)
+	}
 
-	diags := resp.State.Set(ctx, &resourceState)
+	diags = resp.State.Set(ctx, &resourceState)
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
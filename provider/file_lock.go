@@ -0,0 +1,83 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// fileLock is an OS-level lock on a file, taken with flock(2) on Unix and
+// LockFileEx on Windows. The kernel releases it when its holder exits, so a
+// crashed process cannot leave a stale lock behind.
+type fileLock struct {
+	f *os.File
+}
+
+// tryLockFile takes a lock on path, creating the file if needed, without
+// waiting. ok is false if another process holds a conflicting lock.
+func tryLockFile(path string, shared bool) (lock *fileLock, ok bool, err error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, false, err
+	}
+	if ok, err = tryLockHandle(f, shared); err != nil || !ok {
+		_ = f.Close()
+		return nil, false, err
+	}
+	lock = &fileLock{f: f}
+	// The previous holder may have removed the file, e.g. when pruning the
+	// cache entry it guards; a lock on a removed file guards nothing.
+	if !lockFileCurrent(path, f) {
+		_ = lock.Unlock()
+		return nil, false, nil
+	}
+	return lock, true, nil
+}
+
+// lockFileCurrent reports whether path still names the open file f.
+func lockFileCurrent(path string, f *os.File) bool {
+	opened, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	return err == nil && os.SameFile(opened, current)
+}
+
+// lockFileContext tries to lock path every retryDelay until it succeeds or
+// ctx is done.
+func lockFileContext(ctx context.Context, path string, shared bool, retryDelay time.Duration) (*fileLock, error) {
+	for {
+		lock, ok, err := tryLockFile(path, shared)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return lock, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// setHolder replaces the content of the locked file with holder. It writes
+// through the handle that holds the lock, which Windows requires.
+func (l *fileLock) setHolder(holder string) error {
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := l.f.WriteAt([]byte(holder), 0)
+	return err
+}
+
+// Unlock releases the lock and closes the file.
+func (l *fileLock) Unlock() error {
+	err := unlockHandle(l.f)
+	if closeErr := l.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
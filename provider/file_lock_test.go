@@ -0,0 +1,67 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "entry.lock")
+
+	first, ok, err := tryLockFile(path, true)
+	if err != nil || !ok {
+		t.Fatalf("could not take a shared lock: %v", err)
+	}
+	second, ok, err := tryLockFile(path, true)
+	if err != nil || !ok {
+		t.Fatalf("shared locks should not conflict: %v", err)
+	}
+	if _, ok, err := tryLockFile(path, false); err != nil || ok {
+		t.Errorf("an exclusive lock should wait for the shared ones, got %v (%v)", ok, err)
+	}
+	_ = first.Unlock()
+	_ = second.Unlock()
+
+	exclusive, err := lockFileContext(context.Background(), path, false, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = exclusive.Unlock() }()
+	for _, holder := range []string{"a longer holder", "pid 1"} {
+		if err := exclusive.setHolder(holder); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := os.ReadFile(path); err != nil || string(got) != holder {
+			t.Errorf("lock file = %q (%v), want %q", got, err, holder)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := lockFileContext(ctx, path, true, time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("a shared lock should wait for the exclusive one, got %v", err)
+	}
+}
+
+func TestFileLockIgnoresRemovedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "entry.lock")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	if err := os.Remove(path); err != nil {
+		t.Skipf("an open file cannot be removed here: %v", err)
+	}
+	if lockFileCurrent(path, f) {
+		t.Error("a removed lock file should not be current")
+	}
+	lock, ok, err := tryLockFile(path, false)
+	if err != nil || !ok {
+		t.Fatalf("a lock file created again should be locked: %v", err)
+	}
+	_ = lock.Unlock()
+}
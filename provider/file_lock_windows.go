@@ -0,0 +1,39 @@
+//go:build windows
+
+package provider
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockedRange is the byte range that is locked. It lies far beyond the
+// holder information at the start of the file, which other processes can
+// then still read.
+const (
+	lockedRangeOffsetHigh = 0x7fffffff
+	lockedRangeLength     = 1
+)
+
+func tryLockHandle(f *os.File, shared bool) (bool, error) {
+	flags := uint32(windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if !shared {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	ol := &windows.Overlapped{OffsetHigh: lockedRangeOffsetHigh}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, lockedRangeLength, 0, ol)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, windows.ERROR_LOCK_VIOLATION), errors.Is(err, windows.ERROR_IO_PENDING):
+		return false, nil
+	}
+	return false, err
+}
+
+func unlockHandle(f *os.File) error {
+	ol := &windows.Overlapped{OffsetHigh: lockedRangeOffsetHigh}
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, lockedRangeLength, 0, ol)
+}
@@ -0,0 +1,172 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"terraform-provider-packer/crypto_util"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// Values of the on_plugin_change attribute of packer_image.
+const (
+	pluginChangeRebuild = "rebuild"
+	pluginChangeWarn    = "warn"
+)
+
+var pluginChangePolicies = []string{pluginChangeRebuild, pluginChangeWarn}
+
+// imagePlugin is an entry of the plugins attribute of packer_image, keyed
+// by the plugin's source address.
+type imagePlugin struct {
+	Name     string `tfsdk:"name"`
+	Version  string `tfsdk:"version"`
+	Checksum string `tfsdk:"checksum"`
+}
+
+var imagePluginType = types.ObjectType{AttrTypes: map[string]attr.Type{
+	"name":     types.StringType,
+	"version":  types.StringType,
+	"checksum": types.StringType,
+}}
+
+// usedPlugins returns the installed plugins that Packer uses for the
+// required_plugins of the template in dir and file when run with env, keyed
+// by source. missing lists the sources of required plugins that are not
+// installed (yet).
+func usedPlugins(dir string, file string, env map[string]string) (used map[string]imagePlugin, missing []string, err error) {
+	pluginDirs, err := packerPluginDirs(env)
+	if err != nil {
+		return nil, nil, err
+	}
+	required, err := requiredPlugins(dir, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	used = map[string]imagePlugin{}
+	for _, p := range required {
+		bin, v := findInstalledPlugin(pluginDirs, p)
+		if bin == "" {
+			missing = append(missing, p.Source)
+			continue
+		}
+		checksum, err := pluginChecksum(bin)
+		if err != nil {
+			return nil, nil, err
+		}
+		used[p.Source] = imagePlugin{Name: p.Name, Version: v.String(), Checksum: checksum}
+	}
+	return used, missing, nil
+}
+
+// pluginChecksum returns the SHA-256 of the plugin binary bin, from the
+// _SHA256SUM file that Packer verifies before loading it where present.
+func pluginChecksum(bin string) (string, error) {
+	if sum, err := os.ReadFile(bin + "_SHA256SUM"); err == nil {
+		if checksum, err := normalizeChecksum(string(sum)); err == nil {
+			return checksum, nil
+		}
+	}
+	checksum, err := crypto_util.FileSHA256(bin)
+	if err != nil {
+		return "", fmt.Errorf("could not hash plugin %s: %v", bin, err)
+	}
+	return checksum, nil
+}
+
+// pluginChanges describes how the plugins in current differ from those a
+// build recorded in prior. Plugins in missing are not installed and thus
+// not known to differ.
+func pluginChanges(prior map[string]imagePlugin, current map[string]imagePlugin, missing []string) []string {
+	var changes []string
+	for source, now := range current {
+		before, ok := prior[source]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("%s %s was added", source, now.Version))
+		case before.Version != now.Version:
+			changes = append(changes, fmt.Sprintf("%s changed from %s to %s", source, before.Version, now.Version))
+		case before.Checksum != now.Checksum:
+			changes = append(changes, fmt.Sprintf("%s %s has a different checksum", source, now.Version))
+		}
+	}
+	for source := range prior {
+		if _, ok := current[source]; !ok && !containsString(missing, source) {
+			changes = append(changes, fmt.Sprintf("%s is no longer required", source))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// pluginsValue converts used to the value of the plugins attribute.
+func pluginsValue(ctx context.Context, used map[string]imagePlugin) (types.Map, diag.Diagnostics) {
+	return types.MapValueFrom(ctx, imagePluginType, used)
+}
+
+// recordPlugins stores the plugins the build of resourceState used. Plugins
+// that cannot be determined are reported as a warning and left null.
+func (r resourceImage) recordPlugins(ctx context.Context, resourceState *resourceImageType, diags *diag.Diagnostics) {
+	resourceState.Plugins = types.MapNull(imagePluginType)
+	env, _ := r.pluginEnv(resourceState)
+	used, _, err := usedPlugins(r.getDir(resourceState.Directory), r.getFileParam(resourceState), env)
+	if err != nil {
+		diags.AddWarning("Could not record the Packer plugins", err.Error())
+		return
+	}
+	value, valueDiags := pluginsValue(ctx, used)
+	diags.Append(valueDiags...)
+	if !valueDiags.HasError() {
+		resourceState.Plugins = value
+	}
+}
+
+// detectPluginChanges compares the plugins recorded by the last build in
+// prior with those installed for planned now.
+func (r resourceImage) detectPluginChanges(ctx context.Context, planned *resourceImageType, prior *resourceImageType) ([]string, error) {
+	if prior.Plugins.IsNull() || prior.Plugins.IsUnknown() {
+		// Built before plugins were recorded.
+		return nil, nil
+	}
+	recorded := map[string]imagePlugin{}
+	if diags := prior.Plugins.ElementsAs(ctx, &recorded, false); diags.HasError() {
+		return nil, fmt.Errorf("could not read the recorded plugins: %s", diags.Errors()[0].Detail())
+	}
+	if planned.TemplateContent.IsUnknown() || planned.Files.IsUnknown() {
+		return nil, nil
+	}
+	if !planned.Source.IsNull() && r.sourceDir == "" {
+		// The source is not known yet or could not be fetched.
+		return nil, nil
+	}
+	r, cleanup, err := r.withScratchDir(planned)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	if _, legacy := r.legacyTemplatePath(planned); legacy && planned.UpgradeLegacyJSON.ValueBool() {
+		// The plugins of the converted template are only known after the
+		// conversion, which runs with the build.
+		return nil, nil
+	}
+	env, _ := r.pluginEnv(planned)
+	used, missing, err := usedPlugins(r.getDir(planned.Directory), r.getFileParam(planned), env)
+	if err != nil {
+		return nil, err
+	}
+	return pluginChanges(recorded, used, missing), nil
+}
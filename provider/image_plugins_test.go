@@ -0,0 +1,86 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"terraform-provider-packer/crypto_util"
+)
+
+func TestUsedPlugins(t *testing.T) {
+	usePlatform(t, "linux", "amd64")
+	dir := writeTemplate(t)
+	pluginDir := t.TempDir()
+	env := map[string]string{"PACKER_PLUGIN_PATH": pluginDir}
+
+	used, missing, err := usedPlugins(dir, ".", env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(used) != 0 || !reflect.DeepEqual(missing, []string{"github.com/hashicorp/docker"}) {
+		t.Fatalf("without plugins: used %v, missing %v", used, missing)
+	}
+
+	installPlugin(t, pluginDir, "github.com/hashicorp/docker", "docker_v1.0.8")
+	installPlugin(t, pluginDir, "github.com/hashicorp/docker", "docker_v1.0.10")
+	used, missing, err = usedPlugins(dir, ".", env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(pluginDir, "github.com", "hashicorp", "docker", "packer-plugin-docker_v1.0.10_x5.0_linux_amd64")
+	digest, err := crypto_util.FileSHA256(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]imagePlugin{"github.com/hashicorp/docker": {Name: "docker", Version: "1.0.10", Checksum: digest}}
+	if !reflect.DeepEqual(used, want) || len(missing) != 0 {
+		t.Errorf("used %v, missing %v; want the newest version %v", used, missing, want)
+	}
+
+	// The _SHA256SUM file Packer verifies takes precedence over hashing.
+	sum := "ab" + digest[2:]
+	if err := os.WriteFile(bin+"_SHA256SUM", []byte(sum+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	used, _, _ = usedPlugins(dir, ".", env)
+	if used["github.com/hashicorp/docker"].Checksum != sum {
+		t.Errorf("checksum = %s, want the one of the _SHA256SUM file", used["github.com/hashicorp/docker"].Checksum)
+	}
+}
+
+func TestPluginChanges(t *testing.T) {
+	docker := imagePlugin{Name: "docker", Version: "1.0.9", Checksum: "aa"}
+	amazon := imagePlugin{Name: "amazon", Version: "1.2.0", Checksum: "bb"}
+	prior := map[string]imagePlugin{"github.com/hashicorp/docker": docker, "github.com/hashicorp/amazon": amazon}
+
+	if changes := pluginChanges(prior, prior, nil); len(changes) != 0 {
+		t.Errorf("same plugins should not change, got %v", changes)
+	}
+	// A plugin that is not installed yet is not known to differ.
+	if changes := pluginChanges(prior, map[string]imagePlugin{"github.com/hashicorp/docker": docker}, []string{"github.com/hashicorp/amazon"}); len(changes) != 0 {
+		t.Errorf("missing plugins should not change, got %v", changes)
+	}
+
+	upgraded := amazon
+	upgraded.Version = "1.3.0"
+	rebuilt := docker
+	rebuilt.Checksum = "cc"
+	current := map[string]imagePlugin{
+		"github.com/hashicorp/docker":  rebuilt,
+		"github.com/hashicorp/amazon":  upgraded,
+		"github.com/hashicorp/ansible": {Name: "ansible", Version: "1.1.0"},
+	}
+	want := []string{
+		"github.com/hashicorp/amazon changed from 1.2.0 to 1.3.0",
+		"github.com/hashicorp/ansible 1.1.0 was added",
+		"github.com/hashicorp/docker 1.0.9 has a different checksum",
+	}
+	if changes := pluginChanges(prior, current, nil); !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %v, want %v", changes, want)
+	}
+	if changes := pluginChanges(prior, map[string]imagePlugin{"github.com/hashicorp/docker": docker}, nil); !reflect.DeepEqual(changes, []string{"github.com/hashicorp/amazon is no longer required"}) {
+		t.Errorf("changes = %v, want amazon removed", changes)
+	}
+}
@@ -0,0 +1,150 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	tfpath "github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// inlineTemplateName returns the file name template_content is written to,
+// which tells Packer the format of the template: HCL, HCL in JSON syntax or
+// a legacy JSON template, which has builders at the top level.
+func inlineTemplateName(content string) string {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(content), &object); err != nil {
+		return "template.pkr.hcl"
+	}
+	if _, legacy := object["builders"]; legacy {
+		return "template.json"
+	}
+	return "template.pkr.json"
+}
+
+// validateInlineFileName checks that name, a key of files, stays inside
+// the scratch directory.
+func validateInlineFileName(name string) error {
+	if name == "" {
+		return fmt.Errorf("file name is empty")
+	}
+	slashed := filepath.ToSlash(name)
+	if path.IsAbs(slashed) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return fmt.Errorf("%q must be a relative path", name)
+	}
+	if cleaned := path.Clean(slashed); cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return fmt.Errorf("%q must stay inside the working directory", name)
+	}
+	return nil
+}
+
+// usesScratchDir reports whether Packer runs in a scratch directory with the
+// template_content and files of resourceState.
+func usesScratchDir(resourceState *resourceImageType) bool {
+	return !resourceState.TemplateContent.IsNull() && !resourceState.TemplateContent.IsUnknown()
+}
+
+// writeScratchDir writes template_content and files of resourceState into a
+// new directory and returns it. The caller removes it.
+func writeScratchDir(resourceState *resourceImageType) (string, error) {
+	dir, err := os.MkdirTemp("", "terraform-provider-packer-run-*")
+	if err != nil {
+		return "", fmt.Errorf("could not create a scratch directory: %v", err)
+	}
+	write := func(name string, content string) error {
+		if err := validateInlineFileName(name); err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(target, []byte(content), 0o644)
+	}
+	for name, value := range resourceState.Files.Elements() {
+		content, ok := value.(types.String)
+		if !ok || content.IsNull() || content.IsUnknown() {
+			_ = os.RemoveAll(dir)
+			return "", fmt.Errorf("content of file %q is not known", name)
+		}
+		if err := write(name, content.ValueString()); err != nil {
+			_ = os.RemoveAll(dir)
+			return "", fmt.Errorf("could not write file %q: %v", name, err)
+		}
+	}
+	content := resourceState.TemplateContent.ValueString()
+	if err := write(inlineTemplateName(content), content); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", fmt.Errorf("could not write template_content: %v", err)
+	}
+	return dir, nil
+}
+
+// withScratchDir returns a copy of r that runs Packer in a scratch directory
+// holding the template_content and files of resourceState, if it has any,
+// and the function that removes the directory.
+func (r resourceImage) withScratchDir(resourceState *resourceImageType) (resourceImage, func(), error) {
+	if !usesScratchDir(resourceState) {
+		return r, func() {}, nil
+	}
+	dir, err := writeScratchDir(resourceState)
+	if err != nil {
+		return r, nil, err
+	}
+	r.scratchDir = dir
+	return r, func() { _ = os.RemoveAll(dir) }, nil
+}
+
+// Ensure the Resource satisfies the resource.ResourceWithValidateConfig interface.
+var _ resource.ResourceWithValidateConfig = (*resourceImage)(nil)
+
+func (r resourceImage) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	// Attributes are read one by one; environment, say, may be unknown here.
+	var cfg resourceImageType
+	for name, target := range map[string]any{
+		"template_content": &cfg.TemplateContent,
+		"files":            &cfg.Files,
+		"file":             &cfg.File,
+		"directory":        &cfg.Directory,
+		"source":           &cfg.Source,
+	} {
+		resp.Diagnostics.Append(req.Config.GetAttribute(ctx, tfpath.Root(name), target)...)
+	}
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	validateInlineTemplate(&cfg, &resp.Diagnostics)
+	validateTemplateSource(&cfg, &resp.Diagnostics)
+}
+
+func validateInlineTemplate(cfg *resourceImageType, diags *diag.Diagnostics) {
+	if !cfg.TemplateContent.IsNull() {
+		conflict := func(name string, value types.String) {
+			if !value.IsNull() {
+				diags.AddAttributeError(tfpath.Root(name), "Conflicting attributes",
+					fmt.Sprintf("%s cannot be combined with template_content, which Packer runs in a scratch directory.", name))
+			}
+		}
+		conflict("file", cfg.File)
+		conflict("directory", cfg.Directory)
+		conflict("source", cfg.Source)
+	} else if !cfg.Files.IsNull() {
+		diags.AddAttributeError(tfpath.Root("files"), "Missing template_content",
+			"files are written next to template_content and require it.")
+	}
+	if cfg.Files.IsUnknown() {
+		return
+	}
+	for name := range cfg.Files.Elements() {
+		if err := validateInlineFileName(name); err != nil {
+			diags.AddAttributeError(tfpath.Root("files"), "Invalid file name", err.Error())
+		}
+	}
+}
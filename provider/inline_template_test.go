@@ -0,0 +1,75 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestInlineTemplateName(t *testing.T) {
+	for content, want := range map[string]string{
+		`source "null" "x" { communicator = "none" }`:              "template.pkr.hcl",
+		`{"source": {"null": {"x": {"communicator": "none"}}}}`:    "template.pkr.json",
+		`{"builders": [{"type": "null", "communicator": "none"}]}`: "template.json",
+	} {
+		if got := inlineTemplateName(content); got != want {
+			t.Errorf("inlineTemplateName(%q) = %s, want %s", content, got, want)
+		}
+	}
+}
+
+func TestWriteScratchDir(t *testing.T) {
+	state := &resourceImageType{
+		TemplateContent: types.StringValue(`build { sources = ["source.null.x"] }`),
+		Files: types.MapValueMust(types.StringType, map[string]attr.Value{
+			"scripts/setup.sh": types.StringValue("#!/bin/sh\ntrue\n"),
+		}),
+	}
+	r, cleanup, err := resourceImage{}.withScratchDir(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := r.getDir(state.Directory)
+	if got, err := os.ReadFile(filepath.Join(dir, "template.pkr.hcl")); err != nil || string(got) != state.TemplateContent.ValueString() {
+		t.Errorf("template = %q (%v)", got, err)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "scripts", "setup.sh")); err != nil || string(got) != "#!/bin/sh\ntrue\n" {
+		t.Errorf("scripts/setup.sh = %q (%v)", got, err)
+	}
+	if file := r.getFileParam(state); file != "template.pkr.hcl" {
+		t.Errorf("file parameter = %s, want the template", file)
+	}
+	cleanup()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("scratch directory should be removed, stat: %v", err)
+	}
+
+	state.Files = types.MapValueMust(types.StringType, map[string]attr.Value{"../escape": types.StringValue("")})
+	if _, _, err := (resourceImage{}).withScratchDir(state); err == nil {
+		t.Error("files outside the scratch directory should be rejected")
+	}
+}
+
+func TestValidateInlineTemplate(t *testing.T) {
+	files := types.MapValueMust(types.StringType, map[string]attr.Value{"setup.sh": types.StringValue("true")})
+	for name, c := range map[string]struct {
+		cfg   resourceImageType
+		valid bool
+	}{
+		"template":       {resourceImageType{TemplateContent: types.StringValue("build {}"), Files: files}, true},
+		"file":           {resourceImageType{TemplateContent: types.StringNull(), File: types.StringValue("a.pkr.hcl"), Files: types.MapNull(types.StringType)}, true},
+		"conflict":       {resourceImageType{TemplateContent: types.StringValue("build {}"), File: types.StringValue("a.pkr.hcl"), Files: types.MapNull(types.StringType)}, false},
+		"files only":     {resourceImageType{TemplateContent: types.StringNull(), Files: files}, false},
+		"absolute files": {resourceImageType{TemplateContent: types.StringValue("build {}"), Files: types.MapValueMust(types.StringType, map[string]attr.Value{"/etc/passwd": types.StringValue("")})}, false},
+	} {
+		var diags diag.Diagnostics
+		validateInlineTemplate(&c.cfg, &diags)
+		if diags.HasError() == c.valid {
+			t.Errorf("%s: valid = %v, diagnostics %v", name, !diags.HasError(), diags)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// hcl2UpgradeWarningPrefix starts the comment hcl2_upgrade writes above a
+// block it could not convert.
+const hcl2UpgradeWarningPrefix = "# could not parse template for following block:"
+
+// legacyTemplatePath returns the template of resourceState if Packer reads
+// it as a legacy JSON template: a file that is not named *.pkr.hcl or
+// *.pkr.json.
+func (r resourceImage) legacyTemplatePath(resourceState *resourceImageType) (string, bool) {
+	target := r.getFileParam(resourceState)
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(r.getDir(resourceState.Directory), target)
+	}
+	if strings.HasSuffix(target, ".pkr.hcl") || strings.HasSuffix(target, ".pkr.json") {
+		return "", false
+	}
+	if info, err := os.Stat(target); err != nil || info.IsDir() {
+		return "", false
+	}
+	// Packer runs in directory, so the path must not be relative to it.
+	target, err := filepath.Abs(target)
+	return target, err == nil
+}
+
+// runPacker runs Packer like RunCommandInDirWithEnvReturnOutput, but keeps
+// its standard output apart, where fix writes the fixed template.
+func runPacker(exe string, dir string, env map[string]string, params ...string) (stdout []byte, stderr []byte, err error) {
+	cmd := exec.Command(exe, params...)
+	if dir != "." {
+		cmd.Dir = dir
+	}
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
+	}
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err = cmd.Run()
+	return out.Bytes(), errOut.Bytes(), err
+}
+
+// upgradeWarnings returns the reasons hcl2_upgrade gave in upgraded for the
+// blocks it could not convert.
+func upgradeWarnings(upgraded string) []string {
+	var warnings []string
+	for _, line := range strings.Split(upgraded, "\n") {
+		if reason, ok := strings.CutPrefix(strings.TrimSpace(line), hcl2UpgradeWarningPrefix); ok {
+			if unquoted, err := strconv.Unquote(strings.TrimSpace(reason)); err == nil {
+				reason = unquoted
+			}
+			warnings = append(warnings, strings.TrimSpace(reason))
+		}
+	}
+	return warnings
+}
+
+// resolvePathRoot replaces path.root in upgraded, which hcl2_upgrade writes
+// for {{template_dir}}, with templateDir, since the converted template is
+// not next to the original one.
+func resolvePathRoot(upgraded string, templateDir string) string {
+	return strings.ReplaceAll(upgraded, "${path.root}", strings.ReplaceAll(filepath.ToSlash(templateDir), "${", "$${"))
+}
+
+// withUpgradedTemplate returns a copy of r that builds the HCL2 conversion
+// of the legacy JSON template of resourceState, if upgrade_legacy_json is
+// set, and the function that removes it. The template is converted with
+// `packer fix` and `packer hcl2_upgrade` into a scratch directory; Packer
+// keeps running in the template's directory. The converted template is
+// stored in resourceState, and blocks hcl2_upgrade could not convert are
+// reported as warnings.
+func (r resourceImage) withUpgradedTemplate(resourceState *resourceImageType, diags *diag.Diagnostics) (resourceImage, func(), error) {
+	resourceState.UpgradedTemplate = types.StringNull()
+	if !resourceState.UpgradeLegacyJSON.ValueBool() {
+		return r, func() {}, nil
+	}
+	template, legacy := r.legacyTemplatePath(resourceState)
+	if !legacy {
+		return r, func() {}, nil
+	}
+	exe, err := r.settings.packerExecutable(resourceState.Binary.ValueString())
+	if err != nil {
+		return r, nil, err
+	}
+	env, err := r.packerEnv(resourceState)
+	if err != nil {
+		return r, nil, err
+	}
+	dir := r.getDir(resourceState.Directory)
+
+	scratch, err := os.MkdirTemp("", "terraform-provider-packer-upgrade-*")
+	if err != nil {
+		return r, nil, fmt.Errorf("could not create a scratch directory: %v", err)
+	}
+	cleanup := func() { _ = os.RemoveAll(scratch) }
+
+	fixed, stderr, err := runPacker(exe, dir, env, "fix", template)
+	if err != nil {
+		cleanup()
+		return r, nil, fmt.Errorf("packer fix %s failed: %v; output: %s", template, err, bytes.TrimSpace(append(fixed, stderr...)))
+	}
+	fixedPath := filepath.Join(scratch, "fixed.json")
+	if err := os.WriteFile(fixedPath, fixed, 0o644); err != nil {
+		cleanup()
+		return r, nil, err
+	}
+	name := strings.TrimSuffix(filepath.Base(template), filepath.Ext(template))
+	upgradedPath := filepath.Join(scratch, name+".pkr.hcl")
+	stdout, stderr, err := runPacker(exe, dir, env, "hcl2_upgrade", "-output-file="+upgradedPath, fixedPath)
+	if err != nil {
+		cleanup()
+		return r, nil, fmt.Errorf("packer hcl2_upgrade %s failed: %v; output: %s", template, err, bytes.TrimSpace(append(stdout, stderr...)))
+	}
+	upgraded, err := os.ReadFile(upgradedPath)
+	if err != nil {
+		cleanup()
+		return r, nil, fmt.Errorf("packer hcl2_upgrade did not write %s: %v", upgradedPath, err)
+	}
+	for _, warning := range upgradeWarnings(string(upgraded)) {
+		diags.AddWarning(
+			"Legacy JSON template not fully converted",
+			fmt.Sprintf("packer hcl2_upgrade left a block of %s as it was: %s", template, warning),
+		)
+	}
+	resourceState.UpgradedTemplate = types.StringValue(string(upgraded))
+
+	resolved := resolvePathRoot(string(upgraded), filepath.Dir(template))
+	if err := os.WriteFile(upgradedPath, []byte(resolved), 0o644); err != nil {
+		cleanup()
+		return r, nil, err
+	}
+	r.upgradedFile = upgradedPath
+	return r, cleanup, nil
+}
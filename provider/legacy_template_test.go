@@ -0,0 +1,120 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// fakeUpgradePacker returns a Packer that fixes templates by printing them
+// and upgrades them to a template with a block it could not convert.
+func fakeUpgradePacker(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake Packer is a shell script")
+	}
+	bin := filepath.Join(t.TempDir(), "packer")
+	script := `#!/bin/sh
+case "$1" in
+fix) cat "$2" ;;
+hcl2_upgrade)
+  cat > "${2#-output-file=}" <<'EOF'
+# could not parse template for following block: "template: hcl2_upgrade:2: function \"consul_key\" not defined"
+build {
+  provisioner "shell-local" {
+    inline = ["ls ${path.root}"]
+  }
+}
+EOF
+  ;;
+esac
+`
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return bin
+}
+
+func TestWithUpgradedTemplate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.json"), []byte(`{"builders": [{"type": "null"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "build.pkr.hcl"), []byte("build {}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r := resourceImage{settings: providerSettings{PackerBinary: fakeUpgradePacker(t)}}
+	state := &resourceImageType{
+		Directory:         types.StringValue(dir),
+		File:              types.StringValue("app.json"),
+		UpgradeLegacyJSON: types.BoolValue(true),
+	}
+
+	var diags diag.Diagnostics
+	upgraded, cleanup, err := r.withUpgradedTemplate(state, &diags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := upgraded.getFileParam(state)
+	if filepath.Base(file) != "app.pkr.hcl" || filepath.Dir(file) == dir {
+		t.Errorf("file parameter = %s, want app.pkr.hcl in a scratch directory", file)
+	}
+	if got := upgraded.getDir(state.Directory); got != dir {
+		t.Errorf("Packer should keep running in %s, got %s", dir, got)
+	}
+	built, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(built), "ls "+filepath.ToSlash(dir)) {
+		t.Errorf("path.root should refer to the template's directory in the built template:\n%s", built)
+	}
+	if !strings.Contains(state.UpgradedTemplate.ValueString(), "ls ${path.root}") {
+		t.Errorf("upgraded_template should be the converted template as is, got %s", state.UpgradedTemplate)
+	}
+	if len(diags) != 1 || !strings.Contains(diags[0].Detail(), `function "consul_key" not defined`) {
+		t.Errorf("expected a warning for the block that was not converted, got %v", diags)
+	}
+	cleanup()
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("the converted template should be removed, stat: %v", err)
+	}
+
+	state.File = types.StringValue("build.pkr.hcl")
+	unchanged, cleanup, err := r.withUpgradedTemplate(state, &diags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	if file := unchanged.getFileParam(state); file != "build.pkr.hcl" || !state.UpgradedTemplate.IsNull() {
+		t.Errorf("HCL2 templates should be built as they are, got %s and %s", file, state.UpgradedTemplate)
+	}
+}
+
+func TestUpgradeWarnings(t *testing.T) {
+	upgraded := `
+# could not parse template for following block: "template: hcl2_upgrade:2: bad character U+0060 '` + "`" + `'"
+source "null" "x" {}
+
+  # could not parse template for following block: "template: hcl2_upgrade:2: function \"consul_key\" not defined"
+  provisioner "shell-local" {}
+`
+	want := []string{
+		"template: hcl2_upgrade:2: bad character U+0060 '`'",
+		`template: hcl2_upgrade:2: function "consul_key" not defined`,
+	}
+	got := upgradeWarnings(upgraded)
+	if len(got) != len(want) {
+		t.Fatalf("upgradeWarnings = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("warning %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
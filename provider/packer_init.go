@@ -0,0 +1,380 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"terraform-provider-packer/packer_interop"
+
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Values of the init_mode attribute of packer_image.
+const (
+	initModeAlways   = "always"
+	initModeIfNeeded = "if_needed"
+	initModeUpgrade  = "upgrade"
+	initModeNever    = "never"
+)
+
+var initModes = []string{initModeAlways, initModeIfNeeded, initModeUpgrade, initModeNever}
+
+// initRequest describes a `packer init` of a packer_image.
+type initRequest struct {
+	// Dir is the working directory and File the template argument, which
+	// may be a directory such as ".".
+	Dir  string
+	File string
+	Mode string
+	// Env is the environment Packer runs with; it determines the plugin
+	// directories.
+	Env map[string]string
+}
+
+type initRun struct {
+	mu   sync.Mutex
+	done bool
+}
+
+// initCoordinator runs `packer init` at most once per template and mode in
+// the provider process, i.e. once per apply, and never concurrently with
+// another init into the same plugin directory, in this process or another.
+type initCoordinator struct {
+	// lockTimeout bounds the wait for another init into the plugin
+	// directory.
+	lockTimeout time.Duration
+	mu          sync.Mutex
+	runs        map[string]*initRun
+}
+
+func newInitCoordinator(lockTimeout time.Duration) *initCoordinator {
+	return &initCoordinator{lockTimeout: lockTimeout, runs: map[string]*initRun{}}
+}
+
+func (c *initCoordinator) run(key string) *initRun {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.runs[key]
+	if !ok {
+		r = &initRun{}
+		c.runs[key] = r
+	}
+	return r
+}
+
+// ensure makes sure the plugins of the template in req are installed,
+// calling init, with upgrade for the upgrade mode, when req.Mode asks for
+// it. Failed inits are attempted again by the next caller.
+func (c *initCoordinator) ensure(ctx context.Context, req initRequest, init func(upgrade bool) error) error {
+	if req.Mode == initModeNever {
+		return nil
+	}
+	if c == nil {
+		return init(req.Mode == initModeUpgrade)
+	}
+	pluginDirs, err := packerPluginDirs(req.Env)
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(req.Dir)
+	if err != nil {
+		return err
+	}
+	key := strings.Join([]string{dir, req.File, req.Mode, strings.Join(pluginDirs, string(os.PathListSeparator))}, "\x00")
+	r := c.run(key)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done {
+		return nil
+	}
+
+	satisfied := func() bool {
+		if req.Mode != initModeIfNeeded {
+			return false
+		}
+		required, err := requiredPlugins(req.Dir, req.File)
+		// Templates whose requirements cannot be read are initialized.
+		return err == nil && pluginsInstalled(pluginDirs, required)
+	}
+	if satisfied() {
+		r.done = true
+		return nil
+	}
+	// Packer installs into the first plugin directory.
+	lock, err := lockDir(ctx, pluginDirs[0], c.lockTimeout)
+	if err != nil {
+		return fmt.Errorf("could not lock the Packer plugin directory: %v", err)
+	}
+	defer func() { _ = lock.Unlock() }()
+	// Another process may have installed the plugins while we waited.
+	if satisfied() {
+		r.done = true
+		return nil
+	}
+	if err := init(req.Mode == initModeUpgrade); err != nil {
+		return err
+	}
+	r.done = true
+	return nil
+}
+
+// packerPluginDirs returns the directories Packer loads plugins from when
+// run with env, mirroring Packer's own lookup: PACKER_PLUGIN_PATH, else the
+// plugins directory in Packer's config directory.
+func packerPluginDirs(env map[string]string) ([]string, error) {
+	if p := env["PACKER_PLUGIN_PATH"]; p != "" {
+		return filepath.SplitList(p), nil
+	}
+	configDir := ""
+	switch {
+	case env["PACKER_CONFIG_DIR"] != "":
+		configDir = filepath.Join(env["PACKER_CONFIG_DIR"], ".packer.d")
+	case runtime.GOOS == "windows":
+		home := env["APPDATA"]
+		if home == "" {
+			home = env["HOME"]
+		}
+		if home == "" {
+			return nil, fmt.Errorf("neither APPDATA nor HOME is set; cannot locate the Packer plugin directory")
+		}
+		configDir = filepath.Join(home, "packer.d")
+	default:
+		home := env["HOME"]
+		if home == "" {
+			return nil, fmt.Errorf("HOME is not set; cannot locate the Packer plugin directory")
+		}
+		if _, err := os.Stat(filepath.Join(home, ".packer.d")); err == nil {
+			configDir = filepath.Join(home, ".packer.d")
+		} else if xdg := env["XDG_CONFIG_HOME"]; xdg != "" {
+			configDir = filepath.Join(xdg, "packer")
+		} else {
+			configDir = filepath.Join(home, ".config", "packer")
+		}
+	}
+	return []string{filepath.Join(configDir, "plugins")}, nil
+}
+
+// requiredPlugin is an entry of a template's required_plugins block.
+type requiredPlugin struct {
+	Name        string
+	Source      string
+	Constraints version.Constraints
+}
+
+// requiredPlugins reads the required_plugins of the HCL2 template file,
+// relative to dir; a directory file means all *.pkr.hcl and *.pkr.json
+// files in it. Legacy JSON templates cannot require plugins.
+func requiredPlugins(dir string, file string) ([]requiredPlugin, error) {
+	target := file
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(dir, file)
+	}
+	var files []string
+	if info, err := os.Stat(target); err != nil {
+		return nil, err
+	} else if info.IsDir() {
+		for _, pattern := range []string{"*.pkr.hcl", "*.pkr.json"} {
+			matches, _ := filepath.Glob(filepath.Join(target, pattern))
+			files = append(files, matches...)
+		}
+	} else if strings.HasSuffix(target, ".pkr.hcl") || strings.HasSuffix(target, ".pkr.json") {
+		files = []string{target}
+	}
+
+	parser := hclparse.NewParser()
+	var required []requiredPlugin
+	for _, f := range files {
+		var parsed *hcl.File
+		var diags hcl.Diagnostics
+		if strings.HasSuffix(f, ".json") {
+			parsed, diags = parser.ParseJSONFile(f)
+		} else {
+			parsed, diags = parser.ParseHCLFile(f)
+		}
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		content, _, diags := parsed.Body.PartialContent(&hcl.BodySchema{
+			Blocks: []hcl.BlockHeaderSchema{{Type: "packer"}},
+		})
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		for _, packerBlock := range content.Blocks {
+			inner, _, diags := packerBlock.Body.PartialContent(&hcl.BodySchema{
+				Blocks: []hcl.BlockHeaderSchema{{Type: "required_plugins"}},
+			})
+			if diags.HasErrors() {
+				return nil, diags
+			}
+			for _, block := range inner.Blocks {
+				plugins, err := decodeRequiredPlugins(block.Body)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", f, err)
+				}
+				required = append(required, plugins...)
+			}
+		}
+	}
+	return required, nil
+}
+
+func decodeRequiredPlugins(body hcl.Body) ([]requiredPlugin, error) {
+	attrs, diags := body.JustAttributes()
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	var plugins []requiredPlugin
+	for name, attr := range attrs {
+		value, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		if !value.Type().IsObjectType() || !value.IsWhollyKnown() || value.IsNull() {
+			return nil, fmt.Errorf("required plugin %q must be an object with source and version", name)
+		}
+		p := requiredPlugin{Name: name}
+		if value.Type().HasAttribute("source") {
+			if s := value.GetAttr("source"); s.Type() == cty.String && !s.IsNull() {
+				p.Source = s.AsString()
+			}
+		}
+		if p.Source == "" {
+			return nil, fmt.Errorf("required plugin %q has no source", name)
+		}
+		if value.Type().HasAttribute("version") {
+			if v := value.GetAttr("version"); v.Type() == cty.String && !v.IsNull() && v.AsString() != "" {
+				c, err := version.NewConstraint(v.AsString())
+				if err != nil {
+					return nil, fmt.Errorf("required plugin %q: %v", name, err)
+				}
+				p.Constraints = c
+			}
+		}
+		plugins = append(plugins, p)
+	}
+	return plugins, nil
+}
+
+// pluginsInstalled reports whether every plugin in required is installed
+// for the current platform in one of pluginDirs, in the layout of
+// `packer init`: <dir>/<source>/packer-plugin-<name>_v<version>_x<api>_<os>_<arch>.
+func pluginsInstalled(pluginDirs []string, required []requiredPlugin) bool {
+	for _, p := range required {
+		if !pluginInstalled(pluginDirs, p) {
+			return false
+		}
+	}
+	return true
+}
+
+func pluginInstalled(pluginDirs []string, p requiredPlugin) bool {
+	bin, _ := findInstalledPlugin(pluginDirs, p)
+	return bin != ""
+}
+
+// findInstalledPlugin returns the binary and version of the newest installed
+// plugin that satisfies p, which is the one Packer loads, or "" if there is
+// none.
+func findInstalledPlugin(pluginDirs []string, p requiredPlugin) (string, *version.Version) {
+	goos, goarch := currentPlatform()
+	suffix := "_" + goos + "_" + goarch
+	if goos == "windows" {
+		suffix += ".exe"
+	}
+	prefix := "packer-plugin-" + strings.TrimPrefix(path.Base(p.Source), "packer-plugin-") + "_v"
+	var best string
+	var bestVersion *version.Version
+	for _, dir := range pluginDirs {
+		sourceDir := filepath.Join(dir, filepath.FromSlash(p.Source))
+		entries, err := os.ReadDir(sourceDir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+				continue
+			}
+			// <version>_x<api>
+			rest := strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix)
+			raw, _, ok := strings.Cut(rest, "_x")
+			if !ok {
+				continue
+			}
+			v, err := version.NewVersion(raw)
+			if err != nil {
+				continue
+			}
+			if p.Constraints != nil && !p.Constraints.Check(v) {
+				continue
+			}
+			if bestVersion == nil || v.GreaterThan(bestVersion) {
+				best, bestVersion = filepath.Join(sourceDir, name), v
+			}
+		}
+	}
+	return best, bestVersion
+}
+
+// isolatedPluginDirectory is the plugin directory of a packer_image with
+// isolate_plugins, inside the configured download cache.
+func (r resourceImage) isolatedPluginDirectory(id string) string {
+	cacheDir := r.cacheDir
+	if cacheDir == "" {
+		cacheDir = downloadOptions{}.cacheBaseDir()
+	}
+	return filepath.Join(cacheDir, isolatedPluginsDirName, id)
+}
+
+// pluginEnv returns the environment Packer runs with for resourceState and
+// the provider-managed plugin directory it uses, if any: the one of the
+// resource with isolate_plugins, else the provider's plugin_directory unless
+// the resource's environment overrides PACKER_PLUGIN_PATH.
+func (r resourceImage) pluginEnv(resourceState *resourceImageType) (map[string]string, string) {
+	envVars := packer_interop.EnvVars(resourceState.Environment, !resourceState.IgnoreEnvironment.ValueBool())
+	if resourceState.IsolatePlugins.ValueBool() && resourceState.ID.ValueString() != "" {
+		dir := r.isolatedPluginDirectory(resourceState.ID.ValueString())
+		envVars[packer_interop.PackerPluginPath] = dir
+		return envVars, dir
+	}
+	if shared := packer_interop.PluginDirectory(); shared != "" && envVars[packer_interop.PackerPluginPath] == shared {
+		return envVars, shared
+	}
+	return envVars, ""
+}
+
+// packerEnv returns the environment of pluginEnv and creates the plugin
+// directory it uses.
+func (r resourceImage) packerEnv(resourceState *resourceImageType) (map[string]string, error) {
+	if resourceState.IsolatePlugins.ValueBool() && resourceState.ID.ValueString() == "" {
+		return nil, fmt.Errorf("isolated plugin directory requires a resource ID")
+	}
+	envVars, dir := r.pluginEnv(resourceState)
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("could not create the plugin directory %s: %v", dir, err)
+		}
+	}
+	if resourceState.IsolatePlugins.ValueBool() {
+		// Packer runs the plugins it finds there, so nobody else may be
+		// able to place any, like in the download cache.
+		for _, p := range []string{filepath.Dir(dir), dir} {
+			_ = os.Chmod(p, 0o755)
+			if err := checkPrivatePath(p); err != nil {
+				return nil, fmt.Errorf("refusing to use the isolated plugin directory: %v", err)
+			}
+		}
+	}
+	return envVars, nil
+}
@@ -0,0 +1,302 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"terraform-provider-packer/packer_interop"
+
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+const templateWithPlugins = `
+packer {
+  required_plugins {
+    docker = {
+      version = ">= 1.0.0"
+      source  = "github.com/hashicorp/docker"
+    }
+  }
+}
+
+source "null" "example" {
+  communicator = "none"
+}
+`
+
+// writeTemplate writes a template requiring the docker plugin into a new
+// directory and returns the directory.
+func writeTemplate(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "build.pkr.hcl"), []byte(templateWithPlugins), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// installPlugin creates an installed plugin binary in the layout of
+// `packer init` for the current platform.
+func installPlugin(t *testing.T, pluginDir string, source string, name string) {
+	t.Helper()
+	goos, goarch := currentPlatform()
+	file := "packer-plugin-" + name + "_x5.0_" + goos + "_" + goarch
+	if goos == "windows" {
+		file += ".exe"
+	}
+	dir := filepath.Join(pluginDir, filepath.FromSlash(source))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, file), []byte("plugin"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequiredPlugins(t *testing.T) {
+	dir := writeTemplate(t)
+	for _, file := range []string{".", "build.pkr.hcl"} {
+		required, err := requiredPlugins(dir, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(required) != 1 || required[0].Source != "github.com/hashicorp/docker" || required[0].Constraints == nil {
+			t.Errorf("requiredPlugins(%q) = %+v, want the docker plugin", file, required)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "legacy.json"), []byte(`{"builders":[]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if required, err := requiredPlugins(dir, "legacy.json"); err != nil || len(required) != 0 {
+		t.Errorf("legacy JSON templates should not require plugins, got %+v, %v", required, err)
+	}
+}
+
+func TestPluginsInstalled(t *testing.T) {
+	pluginDir := t.TempDir()
+	required, err := requiredPlugins(writeTemplate(t), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pluginsInstalled([]string{pluginDir}, required) {
+		t.Error("no plugin is installed yet")
+	}
+	installPlugin(t, pluginDir, "github.com/hashicorp/docker", "docker_v0.9.0")
+	if pluginsInstalled([]string{pluginDir}, required) {
+		t.Error("docker 0.9.0 does not satisfy >= 1.0.0")
+	}
+	installPlugin(t, pluginDir, "github.com/hashicorp/docker", "docker_v1.0.8")
+	if !pluginsInstalled([]string{t.TempDir(), pluginDir}, required) {
+		t.Error("docker 1.0.8 satisfies >= 1.0.0")
+	}
+}
+
+func TestPackerPluginDirs(t *testing.T) {
+	home := t.TempDir()
+	cases := []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{"PACKER_PLUGIN_PATH": "/opt/plugins", "HOME": home}, "/opt/plugins"},
+		{map[string]string{"PACKER_CONFIG_DIR": "/etc/packer", "HOME": home}, filepath.Join("/etc/packer", ".packer.d", "plugins")},
+		{map[string]string{"XDG_CONFIG_HOME": "/xdg", "HOME": home}, filepath.Join("/xdg", "packer", "plugins")},
+		{map[string]string{"HOME": home}, filepath.Join(home, ".config", "packer", "plugins")},
+	}
+	usePlatform(t, "linux", "amd64")
+	for _, c := range cases {
+		dirs, err := packerPluginDirs(c.env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dirs) != 1 || dirs[0] != c.want {
+			t.Errorf("packerPluginDirs(%v) = %v, want %s", c.env, dirs, c.want)
+		}
+	}
+}
+
+func TestInitCoordinatorRunsInitOncePerTemplate(t *testing.T) {
+	dir := writeTemplate(t)
+	env := map[string]string{"PACKER_PLUGIN_PATH": t.TempDir()}
+	c := newInitCoordinator(defaultCacheLockTimeout)
+
+	var calls atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := c.ensure(context.Background(), initRequest{Dir: dir, File: ".", Mode: initModeAlways, Env: env},
+				func(bool) error {
+					calls.Add(1)
+					return nil
+				})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Errorf("packer init ran %d times, want once", calls.Load())
+	}
+
+	var upgraded bool
+	err := c.ensure(context.Background(), initRequest{Dir: dir, File: ".", Mode: initModeUpgrade, Env: env},
+		func(upgrade bool) error {
+			upgraded = upgrade
+			return nil
+		})
+	if err != nil || !upgraded {
+		t.Errorf("the upgrade mode should run init -upgrade, got upgrade=%v, %v", upgraded, err)
+	}
+
+	err = c.ensure(context.Background(), initRequest{Dir: dir, File: ".", Mode: initModeNever, Env: env},
+		func(bool) error {
+			t.Error("the never mode should not run init")
+			return nil
+		})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInitCoordinatorSkipsInstalledPlugins(t *testing.T) {
+	dir := writeTemplate(t)
+	pluginDir := t.TempDir()
+	env := map[string]string{"PACKER_PLUGIN_PATH": pluginDir}
+	c := newInitCoordinator(defaultCacheLockTimeout)
+
+	failures := 0
+	req := initRequest{Dir: dir, File: ".", Mode: initModeIfNeeded, Env: env}
+	err := c.ensure(context.Background(), req, func(bool) error {
+		failures++
+		return errors.New("registry unreachable")
+	})
+	if err == nil {
+		t.Fatal("expected the failing init to be reported")
+	}
+
+	// Installed meanwhile, e.g. by another process: no init is needed
+	// and the failure is not remembered.
+	installPlugin(t, pluginDir, "github.com/hashicorp/docker", "docker_v1.0.8")
+	err = c.ensure(context.Background(), req, func(bool) error {
+		t.Error("init should be skipped when the required plugins are installed")
+		return nil
+	})
+	if err != nil || failures != 1 {
+		t.Errorf("got %v after %d failed inits", err, failures)
+	}
+}
+
+func TestPackerEnvPluginDirectory(t *testing.T) {
+	useTempCacheDir(t)
+	shared := filepath.Join(t.TempDir(), "plugins")
+	packer_interop.SetPluginDirectory(shared)
+	t.Cleanup(func() { packer_interop.SetPluginDirectory("") })
+
+	var r resourceImage
+	state := &resourceImageType{ID: types.StringValue("image-1"), IgnoreEnvironment: types.BoolValue(true)}
+	env, err := r.packerEnv(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env[packer_interop.PackerPluginPath] != shared {
+		t.Errorf("PACKER_PLUGIN_PATH = %q, want the provider's %q", env[packer_interop.PackerPluginPath], shared)
+	}
+	if _, err := os.Stat(shared); err != nil {
+		t.Errorf("plugin_directory was not created: %v", err)
+	}
+
+	state.Environment = map[string]string{packer_interop.PackerPluginPath: "/opt/plugins"}
+	if env, err := r.packerEnv(state); err != nil || env[packer_interop.PackerPluginPath] != "/opt/plugins" {
+		t.Errorf("environment should override plugin_directory, got %q (%v)", env[packer_interop.PackerPluginPath], err)
+	}
+
+	state.IsolatePlugins = types.BoolValue(true)
+	env, err = r.packerEnv(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	isolated := r.isolatedPluginDirectory("image-1")
+	if env[packer_interop.PackerPluginPath] != isolated {
+		t.Errorf("PACKER_PLUGIN_PATH = %q, want the isolated %q", env[packer_interop.PackerPluginPath], isolated)
+	}
+	if _, err := os.Stat(isolated); err != nil {
+		t.Errorf("isolated plugin directory was not created: %v", err)
+	}
+	if isolated == r.isolatedPluginDirectory("image-2") {
+		t.Error("resources should not share an isolated plugin directory")
+	}
+}
+
+func TestIsolatedPluginDirectoryFollowsCacheDir(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "ci", "packer-binaries")
+	config := providerConfig(t, map[string]tftypes.Value{
+		"packer_binary_cache_dir": tftypes.NewValue(tftypes.String, cacheDir),
+	})
+	var resp provider.ConfigureResponse
+	(&tfProvider{}).Configure(context.Background(), provider.ConfigureRequest{Config: config}, &resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+	var r resourceImage
+	r.Configure(context.Background(), resource.ConfigureRequest{ProviderData: resp.ResourceData}, &resource.ConfigureResponse{})
+	want := filepath.Join(cacheDir, "isolated-plugins", "image-1")
+	if got := r.isolatedPluginDirectory("image-1"); got != want {
+		t.Errorf("isolated plugin directory = %s, want %s inside packer_binary_cache_dir", got, want)
+	}
+
+	state := &resourceImageType{ID: types.StringValue("image-1"), IsolatePlugins: types.BoolValue(true)}
+	if _, err := r.packerEnv(state); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := listCacheEntries(cacheDir); err != nil || len(entries) != 0 {
+		t.Errorf("isolated plugin directories should not be cache entries, got %v (%v)", entries, err)
+	}
+}
+
+func TestIsolatedPluginDirectoryMustBePrivate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions are not checked on Windows")
+	}
+	r := resourceImage{cacheDir: t.TempDir()}
+	state := &resourceImageType{ID: types.StringValue("image-1"), IsolatePlugins: types.BoolValue(true)}
+	if _, err := r.packerEnv(state); err != nil {
+		t.Fatal(err)
+	}
+
+	// A link could lead Packer to plugins that someone else controls.
+	link := filepath.Join(r.cacheDir, "isolated-plugins", "image-2")
+	if err := os.Symlink(t.TempDir(), link); err != nil {
+		t.Fatal(err)
+	}
+	state.ID = types.StringValue("image-2")
+	if _, err := r.packerEnv(state); err == nil || !strings.Contains(err.Error(), "symbolic link") {
+		t.Errorf("expected a linked isolated plugin directory to be refused, got %v", err)
+	}
+}
+
+func TestInitLockFollowsCacheLockTimeout(t *testing.T) {
+	config := providerConfig(t, map[string]tftypes.Value{
+		"packer_binary_cache_lock_timeout": tftypes.NewValue(tftypes.String, "2m"),
+	})
+	var resp provider.ConfigureResponse
+	(&tfProvider{}).Configure(context.Background(), provider.ConfigureRequest{Config: config}, &resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+	if got := resp.ResourceData.(*providerData).inits.lockTimeout; got != 2*time.Minute {
+		t.Errorf("init lock timeout = %s, want packer_binary_cache_lock_timeout", got)
+	}
+}
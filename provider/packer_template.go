@@ -0,0 +1,244 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// templateSpec is a Packer HCL2 template as declared by packer_template.
+// Block bodies are JSON objects.
+type templateSpec struct {
+	RequiredPlugins []templateRequiredPluginSpec
+	Variables       []templateVariableSpec
+	Sources         []templateSourceSpec
+	Builds          []templateBuildSpec
+}
+
+type templateRequiredPluginSpec struct {
+	Name, Source, Version string
+}
+
+type templateVariableSpec struct {
+	Name        string
+	Type        string
+	Default     string
+	Description string
+	Sensitive   bool
+}
+
+type templateSourceSpec struct {
+	Type, Name, Config string
+}
+
+type templateBuildSpec struct {
+	Name           string
+	Sources        []string
+	Provisioners   []templatePluginSpec
+	PostProcessors []templatePluginSpec
+}
+
+// templatePluginSpec is a provisioner or post-processor of a build.
+type templatePluginSpec struct {
+	Type, Config string
+}
+
+// renderTemplate writes spec as a Packer HCL2 template.
+func renderTemplate(spec templateSpec) (string, error) {
+	f := hclwrite.NewEmptyFile()
+	root := f.Body()
+
+	if len(spec.RequiredPlugins) > 0 {
+		plugins := map[string]cty.Value{}
+		for _, p := range spec.RequiredPlugins {
+			if !hclsyntax.ValidIdentifier(p.Name) {
+				return "", fmt.Errorf("required plugin name %q is not a valid identifier", p.Name)
+			}
+			requirement := map[string]cty.Value{"source": cty.StringVal(p.Source)}
+			if p.Version != "" {
+				requirement["version"] = cty.StringVal(p.Version)
+			}
+			plugins[p.Name] = cty.ObjectVal(requirement)
+		}
+		required := root.AppendNewBlock("packer", nil).Body().AppendNewBlock("required_plugins", nil).Body()
+		names := make([]string, 0, len(plugins))
+		for name := range plugins {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			required.SetAttributeValue(name, plugins[name])
+		}
+		root.AppendNewline()
+	}
+
+	for _, v := range spec.Variables {
+		body := root.AppendNewBlock("variable", []string{v.Name}).Body()
+		if v.Type != "" {
+			expr, diags := hclsyntax.ParseExpression([]byte(v.Type), "type", hcl.InitialPos)
+			if !diags.HasErrors() {
+				_, diags = typeexpr.TypeConstraint(expr)
+			}
+			if diags.HasErrors() {
+				return "", fmt.Errorf("variable %q: invalid type %q: %s", v.Name, v.Type, diags.Error())
+			}
+			body.SetAttributeRaw("type", hclwrite.TokensForIdentifier(v.Type))
+		}
+		if v.Default != "" {
+			value, err := decodeTemplateJSON(v.Default)
+			if err != nil {
+				return "", fmt.Errorf("variable %q: default: %v", v.Name, err)
+			}
+			// Packer does not interpolate defaults.
+			body.SetAttributeValue("default", value)
+		}
+		if v.Description != "" {
+			body.SetAttributeValue("description", cty.StringVal(v.Description))
+		}
+		if v.Sensitive {
+			body.SetAttributeValue("sensitive", cty.True)
+		}
+		root.AppendNewline()
+	}
+
+	for _, s := range spec.Sources {
+		block := root.AppendNewBlock("source", []string{s.Type, s.Name})
+		if err := writeTemplateBody(block.Body(), s.Config); err != nil {
+			return "", fmt.Errorf("source %q %q: %v", s.Type, s.Name, err)
+		}
+		root.AppendNewline()
+	}
+
+	for i, b := range spec.Builds {
+		body := root.AppendNewBlock("build", nil).Body()
+		if b.Name != "" {
+			body.SetAttributeValue("name", cty.StringVal(b.Name))
+		}
+		sources := make([]cty.Value, len(b.Sources))
+		for j, s := range b.Sources {
+			sources[j] = cty.StringVal(s)
+		}
+		if len(sources) > 0 {
+			body.SetAttributeValue("sources", cty.ListVal(sources))
+		}
+		for _, p := range b.Provisioners {
+			block := body.AppendNewBlock("provisioner", []string{p.Type})
+			if err := writeTemplateBody(block.Body(), p.Config); err != nil {
+				return "", fmt.Errorf("build %d: provisioner %q: %v", i, p.Type, err)
+			}
+		}
+		for _, p := range b.PostProcessors {
+			block := body.AppendNewBlock("post-processor", []string{p.Type})
+			if err := writeTemplateBody(block.Body(), p.Config); err != nil {
+				return "", fmt.Errorf("build %d: post-processor %q: %v", i, p.Type, err)
+			}
+		}
+		root.AppendNewline()
+	}
+
+	rendered := bytes.TrimRight(hclwrite.Format(f.Bytes()), "\n")
+	rendered = append(rendered, '\n')
+	if _, diags := hclparse.NewParser().ParseHCL(rendered, "template.pkr.hcl"); diags.HasErrors() {
+		return "", fmt.Errorf("rendered template is invalid: %s", diags.Error())
+	}
+	return string(rendered), nil
+}
+
+// decodeTemplateJSON decodes a JSON value, as produced by jsonencode().
+func decodeTemplateJSON(raw string) (cty.Value, error) {
+	typ, err := ctyjson.ImpliedType([]byte(raw))
+	if err != nil {
+		return cty.NilVal, fmt.Errorf("invalid JSON: %v", err)
+	}
+	return ctyjson.Unmarshal([]byte(raw), typ)
+}
+
+// writeTemplateBody writes the JSON object config into body. Lists of
+// objects become repeated nested blocks, e.g. source_ami_filter = [{...}];
+// everything else becomes an attribute. Strings are written as templates, so
+// that "${var.region}" refers to a Packer variable.
+func writeTemplateBody(body *hclwrite.Body, config string) error {
+	if config == "" {
+		return nil
+	}
+	value, err := decodeTemplateJSON(config)
+	if err != nil {
+		return err
+	}
+	return writeTemplateObject(body, value)
+}
+
+func writeTemplateObject(body *hclwrite.Body, value cty.Value) error {
+	if !value.Type().IsObjectType() && !value.Type().IsMapType() {
+		return fmt.Errorf("expected a JSON object, got %s", value.Type().FriendlyName())
+	}
+	for it := value.ElementIterator(); it.Next(); {
+		key, v := it.Element()
+		name := key.AsString()
+		if !hclsyntax.ValidIdentifier(name) {
+			return fmt.Errorf("%q is not a valid attribute or block name", name)
+		}
+		if v.IsNull() {
+			continue
+		}
+		if isBlockList(v) {
+			for nested := v.ElementIterator(); nested.Next(); {
+				_, element := nested.Element()
+				if err := writeTemplateObject(body.AppendNewBlock(name, nil).Body(), element); err != nil {
+					return fmt.Errorf("%s: %v", name, err)
+				}
+			}
+			continue
+		}
+		body.SetAttributeRaw(name, templateTokens(v))
+	}
+	return nil
+}
+
+// isBlockList reports whether v is a non-empty list of objects.
+func isBlockList(v cty.Value) bool {
+	if !(v.Type().IsTupleType() || v.Type().IsListType()) || v.LengthInt() == 0 {
+		return false
+	}
+	for it := v.ElementIterator(); it.Next(); {
+		_, element := it.Element()
+		if element.IsNull() || !(element.Type().IsObjectType() || element.Type().IsMapType()) {
+			return false
+		}
+	}
+	return true
+}
+
+// templateTokens returns the tokens of v with its strings written as
+// Packer templates, exactly as given: "${var.region}" refers to a Packer
+// variable and "$${", HCL's escape, is a literal "${".
+func templateTokens(v cty.Value) hclwrite.Tokens {
+	tokens := hclwrite.TokensForValue(v)
+	for _, t := range tokens {
+		if t.Type == hclsyntax.TokenQuotedLit {
+			t.Bytes = unescapeTemplateIntroducers(t.Bytes)
+		}
+	}
+	return tokens
+}
+
+// unescapeTemplateIntroducers reverts hclwrite's doubling of the "$" or "%"
+// before every "{", which writes "${" as "$${" and "$${" as "$$${".
+func unescapeTemplateIntroducers(lit []byte) []byte {
+	out := make([]byte, 0, len(lit))
+	for i, c := range lit {
+		if (c == '$' || c == '%') && i+2 < len(lit) && lit[i+1] == c && lit[i+2] == '{' {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	rendered, err := renderTemplate(templateSpec{
+		RequiredPlugins: []templateRequiredPluginSpec{{Name: "amazon", Source: "github.com/hashicorp/amazon", Version: ">= 1.2.0"}},
+		Variables: []templateVariableSpec{
+			{Name: "region", Type: "string", Default: `"eu-west-1"`},
+			{Name: "tags", Type: "map(string)", Sensitive: true},
+		},
+		Sources: []templateSourceSpec{{
+			Type: "amazon-ebs",
+			Name: "ubuntu",
+			Config: `{"region": "${var.region}", "ami_name": "app-$${literal}", "tags": {"Team": "ops"},
+				"source_ami_filter": [{"owners": ["099720109477"], "most_recent": true}]}`,
+		}},
+		Builds: []templateBuildSpec{{
+			Sources:        []string{"source.amazon-ebs.ubuntu"},
+			Provisioners:   []templatePluginSpec{{Type: "shell", Config: `{"inline": ["echo \"hi\""]}`}},
+			PostProcessors: []templatePluginSpec{{Type: "manifest"}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`amazon = {`,
+		`source  = "github.com/hashicorp/amazon"`,
+		`variable "region" {`,
+		`type    = string`,
+		`default = "eu-west-1"`,
+		`type      = map(string)`,
+		`source "amazon-ebs" "ubuntu" {`,
+		`region   = "${var.region}"`,
+		`ami_name = "app-$${literal}"`,
+		`source_ami_filter {`,
+		`most_recent = true`,
+		`sources = ["source.amazon-ebs.ubuntu"]`,
+		`provisioner "shell" {`,
+		`inline = ["echo \"hi\""]`,
+		`post-processor "manifest" {`,
+	} {
+		if !strings.Contains(rendered, want) {
+			t.Errorf("rendered template lacks %q:\n%s", want, rendered)
+		}
+	}
+}
+
+func TestRenderTemplateRejectsInvalidInput(t *testing.T) {
+	for name, spec := range map[string]templateSpec{
+		"type":       {Variables: []templateVariableSpec{{Name: "x", Type: "strin g"}}},
+		"json":       {Sources: []templateSourceSpec{{Type: "null", Name: "x", Config: "{"}}},
+		"not object": {Sources: []templateSourceSpec{{Type: "null", Name: "x", Config: `["a"]`}}},
+		"key":        {Sources: []templateSourceSpec{{Type: "null", Name: "x", Config: `{"not valid": 1}`}}},
+	} {
+		if _, err := renderTemplate(spec); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestRenderTemplateEscapesTemplateSequences(t *testing.T) {
+	rendered, err := renderTemplate(templateSpec{
+		Sources: []templateSourceSpec{{
+			Type:   "null",
+			Name:   "x",
+			Config: `{"ref": "${var.region}", "literal": "$${var.region}", "dollar": "$$${var.region}", "directive": "%%{if}"}`,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, diags := hclsyntax.ParseConfig([]byte(rendered), "rendered.pkr.hcl", hcl.InitialPos)
+	if diags.HasErrors() {
+		t.Fatalf("rendered template does not parse: %v\n%s", diags, rendered)
+	}
+	attrs, _ := file.Body.(*hclsyntax.Body).Blocks[0].Body.JustAttributes()
+	if refs := attrs["ref"].Expr.Variables(); len(refs) != 1 || refs[0].RootName() != "var" {
+		t.Errorf("ref should refer to a Packer variable, refers to %v", refs)
+	}
+	for name, want := range map[string]string{
+		"literal":   "${var.region}",
+		"dollar":    "$${var.region}",
+		"directive": "%{if}",
+	} {
+		v, diags := attrs[name].Expr.Value(nil)
+		if diags.HasErrors() || v.AsString() != want {
+			t.Errorf("%s = %#v (%v), want the literal %q", name, v, diags, want)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"terraform-provider-packer/crypto_util"
+
+	"github.com/hashicorp/go-version"
+)
+
+// pluginDescription is the part of a plugin's `describe` output the
+// provider uses.
+type pluginDescription struct {
+	Version    string `json:"version"`
+	APIVersion string `json:"api_version"`
+}
+
+// installedPlugin is a plugin binary installed by installLocalPlugin.
+type installedPlugin struct {
+	Path    string
+	Version string
+}
+
+// pluginName returns the name of the plugin with the source address
+// source, e.g. docker for github.com/hashicorp/docker.
+func pluginName(source string) (string, error) {
+	parts := strings.Split(source, "/")
+	if len(parts) < 3 {
+		return "", fmt.Errorf("plugin source %q must have the form <host>/<namespace>/<name>, e.g. github.com/hashicorp/docker", source)
+	}
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("invalid plugin source %q", source)
+		}
+	}
+	return strings.TrimPrefix(path.Base(source), "packer-plugin-"), nil
+}
+
+// describePlugin runs the plugin binary at bin with `describe`.
+func describePlugin(bin string) (pluginDescription, error) {
+	out, err := runCommandWithEnvCapture(bin, map[string]string{}, "describe")
+	if err != nil {
+		return pluginDescription{}, fmt.Errorf("could not describe plugin %s: %v; output: %s", bin, err, strings.TrimSpace(string(out)))
+	}
+	var desc pluginDescription
+	if err := json.Unmarshal(out, &desc); err != nil {
+		return pluginDescription{}, fmt.Errorf("plugin %s did not describe itself: %v", bin, err)
+	}
+	if _, err := version.NewVersion(desc.Version); err != nil {
+		return pluginDescription{}, fmt.Errorf("plugin %s reports an invalid version %q: %v", bin, desc.Version, err)
+	}
+	if !strings.HasPrefix(desc.APIVersion, "x") {
+		return pluginDescription{}, fmt.Errorf("plugin %s reports an invalid API version %q", bin, desc.APIVersion)
+	}
+	return desc, nil
+}
+
+// pluginOwnersDirName is the directory next to installed plugins that
+// records which packer_plugin resources installed them.
+const pluginOwnersDirName = ".packer_plugin-owners"
+
+// pluginInstalledExternally is the owner recorded for a plugin that was
+// installed before any packer_plugin resource installed it, e.g. by
+// `packer init`.
+const pluginInstalledExternally = "external"
+
+// pluginOwnersDirectory returns the directory that holds a file per owner
+// of the installed plugin bin. Its name does not start with packer-plugin-,
+// so Packer does not take it for a plugin.
+func pluginOwnersDirectory(bin string) string {
+	return filepath.Join(filepath.Dir(bin), pluginOwnersDirName, strings.TrimPrefix(filepath.Base(bin), "packer-plugin-"))
+}
+
+// recordPluginOwner records owner as an owner of the plugin bin, which is
+// about to be installed. A plugin that is already installed without owners
+// is recorded as installed externally first, so that it is never removed.
+func recordPluginOwner(bin string, owner string) error {
+	dir := pluginOwnersDirectory(bin)
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not read the owners of %s: %v", bin, err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("could not record the owner of %s: %v", bin, err)
+	}
+	if _, statErr := os.Stat(bin); statErr == nil && len(entries) == 0 {
+		if err := os.WriteFile(filepath.Join(dir, pluginInstalledExternally), nil, 0o644); err != nil {
+			return fmt.Errorf("could not record the owner of %s: %v", bin, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, owner), nil, 0o644); err != nil {
+		return fmt.Errorf("could not record the owner of %s: %v", bin, err)
+	}
+	return nil
+}
+
+// installLocalPlugin installs the plugin binary fetched from src, a local
+// path or go-getter source, into pluginDir as the plugin with the source
+// address source, like `packer plugins install --path`: the binary is named after
+// the version and API version it describes and gets a _SHA256SUM file, so
+// that Packer loads it. checksum is the SHA-256 the binary must match.
+// owner, the ID of the packer_plugin resource, is recorded as an owner of
+// the installed plugin; see removePlugin. src is fetched inside the
+// download cache of opts, and the plugin directory locked for at most its
+// lock timeout.
+func installLocalPlugin(ctx context.Context, src string, checksum string, source string, pluginDir string, owner string, opts downloadOptions) (installedPlugin, error) {
+	name, err := pluginName(source)
+	if err != nil {
+		return installedPlugin{}, err
+	}
+	if checksum, err = normalizeChecksum(checksum); err != nil {
+		return installedPlugin{}, err
+	}
+
+	stagingDir := filepath.Join(opts.cacheBaseDir(), pluginDownloadsDirName)
+	if err := os.MkdirAll(stagingDir, 0o755); err != nil {
+		return installedPlugin{}, fmt.Errorf("could not create directory %q: %v", stagingDir, err)
+	}
+	fetched, staging, err := fetchWithGetter(ctx, src, stagingDir)
+	if err != nil {
+		return installedPlugin{}, err
+	}
+	defer func() { _ = os.RemoveAll(staging) }()
+	bin, err := findPluginInDir(fetched)
+	if err != nil {
+		return installedPlugin{}, err
+	}
+	digest, err := crypto_util.FileSHA256(bin)
+	if err != nil {
+		return installedPlugin{}, fmt.Errorf("could not hash %s: %v", src, err)
+	}
+	if digest != checksum {
+		return installedPlugin{}, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", src, checksum, digest)
+	}
+	if err := os.Chmod(bin, 0o755); err != nil {
+		return installedPlugin{}, err
+	}
+	desc, err := describePlugin(bin)
+	if err != nil {
+		return installedPlugin{}, err
+	}
+
+	goos, goarch := currentPlatform()
+	fileName := fmt.Sprintf("packer-plugin-%s_v%s_%s_%s_%s", name, desc.Version, desc.APIVersion, goos, goarch)
+	if goos == "windows" {
+		fileName += ".exe"
+	}
+	targetDir := filepath.Join(pluginDir, filepath.FromSlash(source))
+	if err := os.MkdirAll(targetDir, 0o755); err != nil {
+		return installedPlugin{}, fmt.Errorf("could not create plugin directory %s: %v", targetDir, err)
+	}
+	// Inits and other installs into the same directory wait for this one.
+	lock, err := lockDir(ctx, pluginDir, opts.lockTimeout())
+	if err != nil {
+		return installedPlugin{}, fmt.Errorf("could not lock the Packer plugin directory: %v", err)
+	}
+	defer func() { _ = lock.Unlock() }()
+
+	target := filepath.Join(targetDir, fileName)
+	if err := recordPluginOwner(target, owner); err != nil {
+		return installedPlugin{}, err
+	}
+	if err := copyExecutable(bin, target); err != nil {
+		_ = os.Remove(filepath.Join(pluginOwnersDirectory(target), owner))
+		return installedPlugin{}, err
+	}
+	if err := os.WriteFile(target+"_SHA256SUM", []byte(digest), 0o644); err != nil {
+		_ = os.Remove(target)
+		return installedPlugin{}, fmt.Errorf("could not write the checksum of %s: %v", target, err)
+	}
+	return installedPlugin{Path: target, Version: desc.Version}, nil
+}
+
+// findPluginInDir returns fetched if it is a file, else the only plugin
+// binary (packer-plugin-*) in the fetched directory.
+func findPluginInDir(fetched string) (string, error) {
+	info, err := os.Stat(fetched)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return fetched, nil
+	}
+	var found []string
+	err = filepath.WalkDir(fetched, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (d.Name() == ".git" || d.Name() == ".hg") {
+			return filepath.SkipDir
+		}
+		if d.Type().IsRegular() && strings.HasPrefix(d.Name(), "packer-plugin-") && !strings.HasSuffix(d.Name(), "SUM") {
+			found = append(found, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not list fetched files: %v", err)
+	}
+	if len(found) != 1 {
+		return "", fmt.Errorf("expected exactly one packer-plugin-* file in the fetched source, found %d", len(found))
+	}
+	return found[0], nil
+}
+
+// copyExecutable copies src to dst through a temporary file, so that Packer
+// never loads a partially written plugin.
+func copyExecutable(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".install-*")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file next to %s: %v", dst, err)
+	}
+	if _, err := io.Copy(tmp, in); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("could not copy plugin to %s: %v", dst, err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o755); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("could not install plugin to %s: %v", dst, err)
+	}
+	return nil
+}
+
+// removePlugin removes owner from the owners of a plugin installed by
+// installLocalPlugin into pluginDir, and the plugin itself once no other
+// packer_plugin resource owns it and it was not installed externally.
+// removed reports whether the plugin was removed. A plugin that is already
+// gone is not an error. The plugin directory is locked for at most the
+// lock timeout of opts.
+func removePlugin(ctx context.Context, bin string, pluginDir string, owner string, opts downloadOptions) (removed bool, err error) {
+	lock, err := lockDir(ctx, pluginDir, opts.lockTimeout())
+	if err != nil {
+		return false, fmt.Errorf("could not lock the Packer plugin directory: %v", err)
+	}
+	defer func() { _ = lock.Unlock() }()
+
+	dir := pluginOwnersDirectory(bin)
+	if err := os.Remove(filepath.Join(dir, owner)); err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("could not remove the owner of %s: %v", bin, err)
+	}
+	remaining, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("could not read the owners of %s: %v", bin, err)
+	}
+	if len(remaining) > 0 {
+		return false, nil
+	}
+	for _, p := range []string{bin, bin + "_SHA256SUM", dir} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	// Only succeeds once no other plugin has owners.
+	_ = os.Remove(filepath.Dir(dir))
+	return true, nil
+}
@@ -0,0 +1,177 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"terraform-provider-packer/crypto_util"
+)
+
+// fakePlugin writes a plugin script that describes itself as version v and
+// returns it with its checksum.
+func fakePlugin(t *testing.T, v string) (string, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake plugins are shell scripts")
+	}
+	bin := filepath.Join(t.TempDir(), "packer-plugin-docker")
+	script := "#!/bin/sh\necho '{\"version\":\"" + v + "\",\"sdk_version\":\"0.5.2\",\"api_version\":\"x5.0\"}'\n"
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := crypto_util.FileSHA256(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bin, digest
+}
+
+func TestInstallLocalPlugin(t *testing.T) {
+	useTempCacheDir(t)
+	usePlatform(t, "linux", "amd64")
+	bin, digest := fakePlugin(t, "1.0.9")
+	pluginDir := t.TempDir()
+
+	installed, err := installLocalPlugin(context.Background(), bin, digest, "github.com/hashicorp/docker", pluginDir, "a", downloadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(pluginDir, "github.com", "hashicorp", "docker", "packer-plugin-docker_v1.0.9_x5.0_linux_amd64")
+	if installed.Path != want || installed.Version != "1.0.9" {
+		t.Errorf("installed %+v, want %s at 1.0.9", installed, want)
+	}
+	sum, err := os.ReadFile(want + "_SHA256SUM")
+	if err != nil || string(sum) != digest {
+		t.Errorf("checksum file = %q (%v), want %s", sum, err, digest)
+	}
+	required, err := requiredPlugins(writeTemplate(t), "build.pkr.hcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pluginsInstalled([]string{pluginDir}, required) {
+		t.Error("the installed plugin should satisfy the template's required_plugins")
+	}
+
+	if removed, err := removePlugin(context.Background(), installed.Path, pluginDir, "a", downloadOptions{}); err != nil || !removed {
+		t.Fatalf("removePlugin = %v, %v; want removed", removed, err)
+	}
+	if _, err := os.Stat(want); !os.IsNotExist(err) {
+		t.Errorf("plugin should be removed, stat: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(pluginDir, "github.com", "hashicorp", "docker", pluginOwnersDirName)); !os.IsNotExist(err) {
+		t.Errorf("owners should be removed, stat: %v", err)
+	}
+	if _, err := removePlugin(context.Background(), installed.Path, pluginDir, "a", downloadOptions{}); err != nil {
+		t.Errorf("removing a removed plugin: %v", err)
+	}
+}
+
+func TestRemovePluginKeepsPluginOfOtherOwners(t *testing.T) {
+	useTempCacheDir(t)
+	usePlatform(t, "linux", "amd64")
+	bin, digest := fakePlugin(t, "1.0.9")
+	pluginDir := t.TempDir()
+	ctx := context.Background()
+
+	for _, owner := range []string{"a", "b"} {
+		if _, err := installLocalPlugin(ctx, bin, digest, "github.com/hashicorp/docker", pluginDir, owner, downloadOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	installed := filepath.Join(pluginDir, "github.com", "hashicorp", "docker", "packer-plugin-docker_v1.0.9_x5.0_linux_amd64")
+	if removed, err := removePlugin(ctx, installed, pluginDir, "a", downloadOptions{}); err != nil || removed {
+		t.Fatalf("removePlugin = %v, %v; want kept for the other owner", removed, err)
+	}
+	if _, err := os.Stat(installed); err != nil {
+		t.Fatalf("plugin should be kept: %v", err)
+	}
+	if removed, err := removePlugin(ctx, installed, pluginDir, "b", downloadOptions{}); err != nil || !removed {
+		t.Fatalf("removePlugin = %v, %v; want removed by the last owner", removed, err)
+	}
+	if _, err := os.Stat(installed); !os.IsNotExist(err) {
+		t.Errorf("plugin should be removed, stat: %v", err)
+	}
+}
+
+func TestRemovePluginKeepsExternallyInstalledPlugin(t *testing.T) {
+	useTempCacheDir(t)
+	usePlatform(t, "linux", "amd64")
+	bin, digest := fakePlugin(t, "1.0.9")
+	pluginDir := t.TempDir()
+	ctx := context.Background()
+
+	// As installed by packer init.
+	installed := filepath.Join(pluginDir, "github.com", "hashicorp", "docker", "packer-plugin-docker_v1.0.9_x5.0_linux_amd64")
+	if err := os.MkdirAll(filepath.Dir(installed), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyExecutable(bin, installed); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := installLocalPlugin(ctx, bin, digest, "github.com/hashicorp/docker", pluginDir, "a", downloadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := removePlugin(ctx, installed, pluginDir, "a", downloadOptions{}); err != nil || removed {
+		t.Fatalf("removePlugin = %v, %v; want kept", removed, err)
+	}
+	if _, err := os.Stat(installed); err != nil {
+		t.Errorf("plugin installed by packer init should be kept: %v", err)
+	}
+}
+
+func TestInstallLocalPluginFromDirectory(t *testing.T) {
+	usePlatform(t, "linux", "amd64")
+	bin, digest := fakePlugin(t, "1.0.9")
+	pluginDir := t.TempDir()
+	cacheDir := t.TempDir()
+
+	installed, err := installLocalPlugin(context.Background(), filepath.Dir(bin), digest, "github.com/hashicorp/docker",
+		pluginDir, "a", downloadOptions{CacheDir: cacheDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if installed.Version != "1.0.9" {
+		t.Errorf("installed %+v, want 1.0.9", installed)
+	}
+	if info, err := os.Lstat(installed.Path); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("the plugin should be installed as a file, got %v (%v)", info, err)
+	}
+	if entries, err := os.ReadDir(filepath.Join(cacheDir, "plugin-downloads")); err != nil || len(entries) != 0 {
+		t.Errorf("the plugin should be fetched inside the download cache and cleaned up, got %v (%v)", entries, err)
+	}
+}
+
+func TestInstallLocalPluginRejectsChecksumMismatch(t *testing.T) {
+	useTempCacheDir(t)
+	bin, _ := fakePlugin(t, "1.0.9")
+	pluginDir := t.TempDir()
+	_, err := installLocalPlugin(context.Background(), bin, strings.Repeat("0", 64), "github.com/hashicorp/docker", pluginDir, "a", downloadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	if entries, _ := os.ReadDir(pluginDir); len(entries) != 0 {
+		t.Errorf("nothing should be installed, found %d entries", len(entries))
+	}
+}
+
+func TestPluginName(t *testing.T) {
+	for source, want := range map[string]string{
+		"github.com/hashicorp/docker":               "docker",
+		"github.com/hashicorp/packer-plugin-docker": "docker",
+		"example.com/team/vsphere":                  "vsphere",
+	} {
+		if got, err := pluginName(source); err != nil || got != want {
+			t.Errorf("pluginName(%q) = %q, %v; want %q", source, got, err, want)
+		}
+	}
+	for _, source := range []string{"docker", "hashicorp/docker", "github.com/../docker", "github.com//docker"} {
+		if _, err := pluginName(source); err == nil {
+			t.Errorf("pluginName(%q) should fail", source)
+		}
+	}
+}
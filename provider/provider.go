@@ -2,20 +2,28 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"terraform-provider-packer/packer_interop"
 
+	"github.com/hashicorp/go-version"
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	provider_schema "github.com/hashicorp/terraform-plugin-framework/provider/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 
 	"github.com/hashicorp/terraform-plugin-framework/provider"
 
 	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
 )
 
 func New() provider.Provider {
@@ -23,7 +31,6 @@ func New() provider.Provider {
 }
 
 type tfProvider struct {
-	packerBinary string
 }
 
 func (p *tfProvider) Metadata(_ context.Context, _ provider.MetadataRequest, resp *provider.MetadataResponse) {
@@ -42,9 +49,16 @@ func (p *tfProvider) Schema(_ context.Context, _ provider.SchemaRequest, respons
 					Optional: true,
 				},
 				"packer_binary_url": provider_schema.StringAttribute{
-					Description: "Optional http(s) URL to download a Packer-compatible binary from, " +
-						"used instead of the embedded one. The URL may serve a raw executable or a zip archive " +
-						"containing one (a file named `packer`/`packer.exe`, or a single-file archive). " +
+					Description: "Optional URL to download a Packer-compatible binary from, " +
+						"used instead of the embedded one. Besides http(s) URLs, any go-getter source is accepted, " +
+						"e.g. a local path, `file::`, `git::https://...?ref=v1.2.0` or an archive with `?archive=` " +
+						"and `?checksum=`. " +
+						"The URL may serve a raw executable or an archive " +
+						"containing one (zip, tar, or tar compressed with gzip, xz or zstd), or an executable compressed with gzip, xz or zstd. Unless " +
+						"`packer_binary_archive_path` is set, the archive must contain a file named " +
+						"`packer`/`packer.exe` or exactly one file. " +
+						"The placeholders `{os}`, `{arch}` and `{version}` are replaced with the platform the provider " +
+						"runs on (e.g. `linux`, `amd64`) and `packer_binary_version`. " +
 						"Downloads are cached locally and reused; changing the URL or checksum triggers a fresh download. " +
 						"Conflicts with `packer_binary`. " +
 						"This provider is an independent project and is not affiliated with or endorsed by HashiCorp. " +
@@ -55,9 +69,213 @@ func (p *tfProvider) Schema(_ context.Context, _ provider.SchemaRequest, respons
 				"packer_binary_checksum": provider_schema.StringAttribute{
 					Description: "Optional SHA-256 checksum (hex, optionally prefixed with `sha256:`) used to verify " +
 						"the file downloaded from `packer_binary_url`. The checksum is computed over the downloaded " +
-						"artifact itself (e.g. the zip archive, not the binary inside it). Requires `packer_binary_url`.",
+						"artifact itself (e.g. the zip archive, not the binary inside it). For go-getter sources that " +
+						"yield a directory (git repositories, archives unpacked with `?archive=`), it is computed over " +
+						"the selected executable. With `packer_binary`, the local file is verified before it is used. " +
+						"Requires `packer_binary` or `packer_binary_url`.",
 					Optional: true,
 				},
+				"packer_binary_checksums": provider_schema.MapAttribute{
+					Description: "Optional per-platform SHA-256 checksums for the artifact downloaded from " +
+						"`packer_binary_url`, keyed by `os_arch` (e.g. `linux_amd64`, `darwin_arm64`). The entry for " +
+						"the platform the provider runs on is used and must exist. Requires `packer_binary_url`. " +
+						"Conflicts with `packer_binary_checksum` and `packer_binary_checksums_url`.",
+					ElementType: types.StringType,
+					Optional:    true,
+				},
+				"packer_binary_version": provider_schema.StringAttribute{
+					Description: "Optional version substituted for the `{version}` placeholder in " +
+						"`packer_binary_url`, `packer_binary_checksums_url` and `packer_binary_checksums_signature_url`.",
+					Optional: true,
+				},
+				"packer_binary_archive_path": provider_schema.StringAttribute{
+					Description: "Optional path of the executable inside the archive or directory fetched from " +
+						"`packer_binary_url` or `packer_binary_oci`, e.g. `bin/packer`. Must be relative and stay inside " +
+						"the archive. Requires `packer_binary_url` or `packer_binary_oci`.",
+					Optional: true,
+				},
+				"packer_binary_oci": provider_schema.StringAttribute{
+					Description: "Optional OCI artifact to pull the Packer binary from, as `registry/repository:tag` or " +
+						"`registry/repository@sha256:...` (e.g. `registry.local/tools/packer:1.9.4`). For an image index, " +
+						"the manifest matching the platform the provider runs on is used. The artifact must have a single " +
+						"layer or one titled `packer`/`packer.exe` or named after the platform (e.g. `packer_linux_amd64.zip`); " +
+						"the layer may hold a raw executable or an archive. It is verified by its digest and cached like " +
+						"downloads. Only https registries are supported; use `packer_binary_ca_bundle` for private CAs. " +
+						"Conflicts with `packer_binary` and `packer_binary_url`.",
+					Optional: true,
+				},
+				"packer_binary_oci_username": provider_schema.StringAttribute{
+					Description: "Optional username for the registry of `packer_binary_oci`, used for basic auth or to " +
+						"obtain a bearer token, as the registry requests.",
+					Optional: true,
+				},
+				"packer_binary_oci_password": provider_schema.StringAttribute{
+					Description: "Optional password for `packer_binary_oci_username`.",
+					Optional:    true,
+					Sensitive:   true,
+				},
+				"packer_binary_oci_token": provider_schema.StringAttribute{
+					Description: "Optional bearer token sent to the registry of `packer_binary_oci` as is. " +
+						"Conflicts with `packer_binary_oci_username`.",
+					Optional:  true,
+					Sensitive: true,
+				},
+				"packer_binary_mirrors": provider_schema.ListAttribute{
+					Description: "Optional http(s) URLs serving the same artifact as an http(s) `packer_binary_url`, tried in order " +
+						"when it cannot be downloaded. The same placeholders as in `packer_binary_url` are supported. " +
+						"Requires `packer_binary_url`.",
+					ElementType: types.StringType,
+					Optional:    true,
+				},
+				"packer_binary_download_retries": provider_schema.Int64Attribute{
+					Description: "Number of additional attempts per URL after a transient download failure " +
+						"(network errors, HTTP 408, 429 and 5xx, interrupted transfers), with exponential backoff. " +
+						"Interrupted transfers are resumed with HTTP range requests where the server supports them. " +
+						"Defaults to 2.",
+					Optional: true,
+				},
+				"packer_binary_download_headers": provider_schema.MapAttribute{
+					Description: "Optional HTTP headers sent with every download request, including mirrors, " +
+						"checksums files and signatures, e.g. `Authorization = \"Bearer ...\"`.",
+					ElementType: types.StringType,
+					Optional:    true,
+					Sensitive:   true,
+				},
+				"packer_binary_ca_bundle": provider_schema.StringAttribute{
+					Description: "Optional PEM-encoded CA certificates trusted for downloads in addition to the " +
+						"system roots, e.g. for a private artifact store.",
+					Optional: true,
+				},
+				"packer_binary_download_timeout": provider_schema.StringAttribute{
+					Description: "Optional overall timeout for each download including retries, as a Go duration " +
+						"such as `10m`. Unlimited by default.",
+					Optional: true,
+				},
+				"binaries": provider_schema.MapNestedAttribute{
+					Description: "Optional named Packer binaries that `packer_image` and `packer_version` can select with " +
+						"their `binary` attribute, e.g. to migrate templates from the embedded build to a newer Packer one " +
+						"at a time. Resources without `binary` use the provider's default binary; `binary = \"embedded\"` " +
+						"selects the embedded build. Downloads use the provider's download and cache settings.",
+					Optional: true,
+					NestedObject: provider_schema.NestedAttributeObject{
+						Attributes: map[string]provider_schema.Attribute{
+							"path": provider_schema.StringAttribute{
+								Description: "Path to a Packer binary. Conflicts with `url`.",
+								Optional:    true,
+							},
+							"url": provider_schema.StringAttribute{
+								Description: "URL or go-getter source to download the binary from, like `packer_binary_url`. " +
+									"The `{os}` and `{arch}` placeholders are supported. Conflicts with `path`.",
+								Optional: true,
+							},
+							"checksum": provider_schema.StringAttribute{
+								Description: "Optional SHA-256 checksum of the file at `path` or of the artifact downloaded from `url`.",
+								Optional:    true,
+							},
+							"archive_path": provider_schema.StringAttribute{
+								Description: "Optional path of the executable inside the archive downloaded from `url`.",
+								Optional:    true,
+							},
+						},
+					},
+				},
+				"packer_binary_discovery": provider_schema.BoolAttribute{
+					Description: "Discover a Packer binary instead of configuring one: the binary named by the " +
+						"`PACKER_BINARY` or `TPP_PACKER_BINARY` environment variable is used if set; otherwise the " +
+						"newest `packer` in `PATH` or `packer_binary_search_paths` that satisfies " +
+						"`packer_binary_version_constraint` is chosen. Conflicts with `packer_binary`, " +
+						"`packer_binary_url` and `packer_binary_oci`.",
+					Optional: true,
+				},
+				"packer_binary_search_paths": provider_schema.ListAttribute{
+					Description: "Optional directories searched for a `packer` executable after `PATH` " +
+						"when `packer_binary_discovery` is enabled.",
+					ElementType: types.StringType,
+					Optional:    true,
+				},
+				"packer_binary_version_constraint": provider_schema.StringAttribute{
+					Description: "Optional version constraint the provider's default Packer binary must satisfy, e.g. " +
+						"`>= 1.9.0, < 2.0.0`. Applies to every way of providing it, including the embedded build, but not " +
+						"to the entries of `binaries`; " +
+						"with `packer_binary_discovery`, binaries that do not satisfy it are skipped. Version suffixes " +
+						"such as `-mpl` are ignored when checking it.",
+					Optional: true,
+				},
+				"allow_embedded": provider_schema.BoolAttribute{
+					Description: "Whether the embedded Packer build (`1.10.0-mpl`) may be used when no other binary is " +
+						"configured or discovered. Defaults to `true`; set it to `false` to make the provider fail " +
+						"instead of silently falling back to the embedded build.",
+					Optional: true,
+				},
+				"plugin_directory": provider_schema.StringAttribute{
+					Description: "Directory that every Packer invocation of the provider uses for its plugins, " +
+						"exported as `PACKER_PLUGIN_PATH`, so that builds use the same plugins on every machine. It is " +
+						"created when first needed. Relative paths are relative to Terraform's working directory. " +
+						"Defaults to Packer's own plugin directory.",
+					Optional: true,
+				},
+				"max_concurrent_builds": provider_schema.Int64Attribute{
+					Description: "Maximum number of `packer_image` builds that run at the same time in this provider, " +
+						"regardless of Terraform's `-parallelism`. Unlimited when unset.",
+					Optional: true,
+				},
+				"packer_binary_cache_dir": provider_schema.StringAttribute{
+					Description: "Optional directory for cached downloads. Defaults to " +
+						"`terraform-provider-packer/downloaded-binaries` in the user cache directory.",
+					Optional: true,
+				},
+				"packer_binary_cache_max_size_mb": provider_schema.Int64Attribute{
+					Description: "Optional maximum total size of the download cache in megabytes. Least recently used " +
+						"entries are removed when the binaries are provided; the entries in use or used in the last " +
+						"10 minutes are always kept.",
+					Optional: true,
+				},
+				"packer_binary_cache_max_entries": provider_schema.Int64Attribute{
+					Description: "Optional maximum number of entries in the download cache. Least recently used " +
+						"entries are removed when the binaries are provided; the entries in use or used in the last " +
+						"10 minutes are always kept.",
+					Optional: true,
+				},
+				"packer_binary_cache_lock_timeout": provider_schema.StringAttribute{
+					Description: "Optional maximum time to wait for another Terraform process that is downloading the " +
+						"same binary into the cache or installing plugins into the same plugin directory, as a Go " +
+						"duration such as `5m`. Defaults to `15m`.",
+					Optional: true,
+				},
+				"packer_binary_cache_disabled": provider_schema.BoolAttribute{
+					Description: "Download into a fresh temporary directory on every run instead of the cache, " +
+						"e.g. on ephemeral CI runners. The directory is removed when the provider exits. Conflicts with the other `packer_binary_cache_*` attributes.",
+					Optional: true,
+				},
+				"packer_binary_checksums_url": provider_schema.StringAttribute{
+					Description: "Optional http(s) URL of a signed checksums file (`SHA256SUMS` format, as written by " +
+						"`sha256sum`) that lists the artifact downloaded from `packer_binary_url` by its file name. " +
+						"The file's detached signature is verified with the configured public key before the " +
+						"checksum is used, so upgrading only requires changing the URLs. " +
+						"Requires `packer_binary_url` and exactly one of `packer_binary_pgp_public_key`, " +
+						"`packer_binary_ssh_public_key` or `packer_binary_minisign_public_key`. " +
+						"Conflicts with `packer_binary_checksum`.",
+					Optional: true,
+				},
+				"packer_binary_checksums_signature_url": provider_schema.StringAttribute{
+					Description: "Optional http(s) URL of the detached signature of `packer_binary_checksums_url`. " +
+						"Defaults to the checksums URL with `.sig` appended (`.minisig` for minisign).",
+					Optional: true,
+				},
+				"packer_binary_pgp_public_key": provider_schema.StringAttribute{
+					Description: "ASCII-armored OpenPGP public key used to verify the signature of " +
+						"`packer_binary_checksums_url`. Binary and armored signatures are accepted.",
+					Optional: true,
+				},
+				"packer_binary_ssh_public_key": provider_schema.StringAttribute{
+					Description: "SSH public key (`authorized_keys` format) used to verify the signature of " +
+						"`packer_binary_checksums_url`, created with `ssh-keygen -Y sign -n file`.",
+					Optional: true,
+				},
+				"packer_binary_minisign_public_key": provider_schema.StringAttribute{
+					Description: "minisign public key used to verify the signature of `packer_binary_checksums_url`.",
+					Optional:    true,
+				},
 			},
 		},
 	}
@@ -67,17 +285,40 @@ func (p *tfProvider) DataSources(_ context.Context) []func() datasource.DataSour
 	return []func() datasource.DataSource{
 		func() datasource.DataSource { return &dataSourceVersion{p: *p} },
 		func() datasource.DataSource { return &dataSourceFiles{p: *p} },
+		func() datasource.DataSource { return &dataSourceTemplate{p: *p} },
 	}
 }
 
 func (p *tfProvider) Resources(_ context.Context) []func() resource.Resource {
 	return []func() resource.Resource{
 		func() resource.Resource { return &resourceImage{p: *p} },
+		func() resource.Resource { return &resourcePlugin{p: *p} },
 	}
 }
 
+// defaultDownloadRetries applies when packer_binary_download_retries is unset.
+const defaultDownloadRetries = 2
+
+// providerData is what Configure hands to resources and data sources.
+type providerData struct {
+	binaries *binaryResolver
+	builds   *buildLimiter
+	inits    *initCoordinator
+	sources  *sourceFetcher
+	// cacheDir is downloadOptions.cacheBaseDir of the provider's
+	// configuration.
+	cacheDir string
+	// lockTimeout is downloadOptions.lockTimeout of the provider's
+	// configuration.
+	lockTimeout time.Duration
+}
+
 type providerSettings struct {
 	PackerBinary string
+	// Binaries maps the names of the provider's binaries map to resolved
+	// executables.
+	Binaries      map[string]string
+	AllowEmbedded bool
 }
 
 func runCommandWithEnvCapture(bin string, env map[string]string, args ...string) ([]byte, error) {
@@ -108,12 +349,126 @@ func knownStringValue(v types.String) string {
 	return v.ValueString()
 }
 
+func knownStringList(ctx context.Context, v types.List) ([]string, diag.Diagnostics) {
+	if v.IsNull() || v.IsUnknown() {
+		return nil, nil
+	}
+	var l []string
+	diags := v.ElementsAs(ctx, &l, false)
+	return l, diags
+}
+
+func knownStringMap(ctx context.Context, v types.Map) (map[string]string, diag.Diagnostics) {
+	if v.IsNull() || v.IsUnknown() {
+		return nil, nil
+	}
+	m := map[string]string{}
+	diags := v.ElementsAs(ctx, &m, false)
+	return m, diags
+}
+
+// binarySelectionAttributes are the provider attributes that shape which
+// Packer binary is used, as opposed to how it is fetched or how builds run.
+var binarySelectionAttributes = map[string]bool{
+	"packer_binary":                         true,
+	"packer_binary_url":                     true,
+	"packer_binary_checksum":                true,
+	"packer_binary_checksums":               true,
+	"packer_binary_version":                 true,
+	"packer_binary_archive_path":            true,
+	"packer_binary_mirrors":                 true,
+	"binaries":                              true,
+	"packer_binary_discovery":               true,
+	"packer_binary_search_paths":            true,
+	"packer_binary_version_constraint":      true,
+	"allow_embedded":                        true,
+	"packer_binary_oci":                     true,
+	"packer_binary_oci_username":            true,
+	"packer_binary_oci_password":            true,
+	"packer_binary_oci_token":               true,
+	"packer_binary_checksums_url":           true,
+	"packer_binary_checksums_signature_url": true,
+	"packer_binary_pgp_public_key":          true,
+	"packer_binary_ssh_public_key":          true,
+	"packer_binary_minisign_public_key":     true,
+}
+
+// unknownAttributes returns the sorted names of the attributes of config
+// among names whose values are not yet fully known, e.g. because they come
+// from another resource that has not been created.
+func unknownAttributes(config tftypes.Value, names map[string]bool) []string {
+	var attrs map[string]tftypes.Value
+	if err := config.As(&attrs); err != nil {
+		return nil
+	}
+	var unknown []string
+	for name, v := range attrs {
+		if names[name] && !v.IsFullyKnown() {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// newProviderData returns the data shared with resources and data sources,
+// which resolve their binaries with resolver and keep their files in the
+// download cache and wait for its locks as opts say, and points Packer at
+// pluginDir.
+func newProviderData(resolver *binaryResolver, maxBuilds int, pluginDir string, opts downloadOptions) *providerData {
+	packer_interop.SetPluginDirectory(pluginDir)
+	return &providerData{
+		binaries:    resolver,
+		builds:      newBuildLimiter(maxBuilds),
+		inits:       newInitCoordinator(opts.lockTimeout()),
+		sources:     newSourceFetcher(opts.cacheBaseDir()),
+		cacheDir:    opts.cacheBaseDir(),
+		lockTimeout: opts.lockTimeout(),
+	}
+}
+
 func (p *tfProvider) Configure(ctx context.Context, req provider.ConfigureRequest, resp *provider.ConfigureResponse) {
 	// Read provider config
 	var cfg struct {
-		PackerBinary         types.String `tfsdk:"packer_binary"`
-		PackerBinaryURL      types.String `tfsdk:"packer_binary_url"`
-		PackerBinaryChecksum types.String `tfsdk:"packer_binary_checksum"`
+		PackerBinary            types.String `tfsdk:"packer_binary"`
+		PackerBinaryURL         types.String `tfsdk:"packer_binary_url"`
+		PackerBinaryChecksum    types.String `tfsdk:"packer_binary_checksum"`
+		PackerBinaryChecksums   types.Map    `tfsdk:"packer_binary_checksums"`
+		PackerBinaryVersion     types.String `tfsdk:"packer_binary_version"`
+		PackerBinaryArchivePath types.String `tfsdk:"packer_binary_archive_path"`
+
+		Binaries types.Map `tfsdk:"binaries"`
+
+		PackerBinaryDiscovery         types.Bool   `tfsdk:"packer_binary_discovery"`
+		PackerBinarySearchPaths       types.List   `tfsdk:"packer_binary_search_paths"`
+		PackerBinaryVersionConstraint types.String `tfsdk:"packer_binary_version_constraint"`
+		AllowEmbedded                 types.Bool   `tfsdk:"allow_embedded"`
+
+		MaxConcurrentBuilds types.Int64  `tfsdk:"max_concurrent_builds"`
+		PluginDirectory     types.String `tfsdk:"plugin_directory"`
+
+		PackerBinaryOCI         types.String `tfsdk:"packer_binary_oci"`
+		PackerBinaryOCIUsername types.String `tfsdk:"packer_binary_oci_username"`
+		PackerBinaryOCIPassword types.String `tfsdk:"packer_binary_oci_password"`
+		PackerBinaryOCIToken    types.String `tfsdk:"packer_binary_oci_token"`
+
+		PackerBinaryMirrors         types.List   `tfsdk:"packer_binary_mirrors"`
+		PackerBinaryDownloadRetries types.Int64  `tfsdk:"packer_binary_download_retries"`
+		PackerBinaryDownloadHeaders types.Map    `tfsdk:"packer_binary_download_headers"`
+		PackerBinaryCABundle        types.String `tfsdk:"packer_binary_ca_bundle"`
+		PackerBinaryDownloadTimeout types.String `tfsdk:"packer_binary_download_timeout"`
+
+		PackerBinaryCacheDir         types.String `tfsdk:"packer_binary_cache_dir"`
+		PackerBinaryCacheMaxSizeMB   types.Int64  `tfsdk:"packer_binary_cache_max_size_mb"`
+		PackerBinaryCacheMaxEntries  types.Int64  `tfsdk:"packer_binary_cache_max_entries"`
+		PackerBinaryCacheLockTimeout types.String `tfsdk:"packer_binary_cache_lock_timeout"`
+		PackerBinaryCacheDisabled    types.Bool   `tfsdk:"packer_binary_cache_disabled"`
+
+		PackerBinaryChecksumsURL          types.String `tfsdk:"packer_binary_checksums_url"`
+		PackerBinaryChecksumsSignatureURL types.String `tfsdk:"packer_binary_checksums_signature_url"`
+		PackerBinaryPGPPublicKey          types.String `tfsdk:"packer_binary_pgp_public_key"`
+		PackerBinarySSHPublicKey          types.String `tfsdk:"packer_binary_ssh_public_key"`
+		PackerBinaryMinisignPublicKey     types.String `tfsdk:"packer_binary_minisign_public_key"`
 	}
 	diags := req.Config.Get(ctx, &cfg)
 	resp.Diagnostics.Append(diags...)
@@ -124,6 +479,166 @@ func (p *tfProvider) Configure(ctx context.Context, req provider.ConfigureReques
 	binPath := knownStringValue(cfg.PackerBinary)
 	binURL := knownStringValue(cfg.PackerBinaryURL)
 	checksum := knownStringValue(cfg.PackerBinaryChecksum)
+	platformChecksums, diags := knownStringMap(ctx, cfg.PackerBinaryChecksums)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	binVersion := knownStringValue(cfg.PackerBinaryVersion)
+	mirrors, diags := knownStringList(ctx, cfg.PackerBinaryMirrors)
+	resp.Diagnostics.Append(diags...)
+	headers, diags := knownStringMap(ctx, cfg.PackerBinaryDownloadHeaders)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	opts := downloadOptions{
+		Mirrors:  mirrors,
+		Retries:  defaultDownloadRetries,
+		Headers:  headers,
+		CABundle: knownStringValue(cfg.PackerBinaryCABundle),
+	}
+	if !cfg.PackerBinaryDownloadRetries.IsNull() && !cfg.PackerBinaryDownloadRetries.IsUnknown() {
+		opts.Retries = int(cfg.PackerBinaryDownloadRetries.ValueInt64())
+		if opts.Retries < 0 {
+			resp.Diagnostics.AddAttributeError(
+				path.Root("packer_binary_download_retries"),
+				"Invalid provider configuration",
+				"packer_binary_download_retries must not be negative.",
+			)
+			return
+		}
+	}
+	if timeout := knownStringValue(cfg.PackerBinaryDownloadTimeout); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d <= 0 {
+			resp.Diagnostics.AddAttributeError(
+				path.Root("packer_binary_download_timeout"),
+				"Invalid provider configuration",
+				fmt.Sprintf("packer_binary_download_timeout must be a positive duration such as \"10m\", got %q.", timeout),
+			)
+			return
+		}
+		opts.Timeout = d
+	}
+	if timeout := knownStringValue(cfg.PackerBinaryCacheLockTimeout); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d <= 0 {
+			resp.Diagnostics.AddAttributeError(
+				path.Root("packer_binary_cache_lock_timeout"),
+				"Invalid provider configuration",
+				fmt.Sprintf("packer_binary_cache_lock_timeout must be a positive duration such as \"5m\", got %q.", timeout),
+			)
+			return
+		}
+		opts.LockTimeout = d
+	}
+	pluginDir := knownStringValue(cfg.PluginDirectory)
+	if pluginDir != "" {
+		abs, err := filepath.Abs(pluginDir)
+		if err != nil {
+			resp.Diagnostics.AddAttributeError(
+				path.Root("plugin_directory"),
+				"Invalid provider configuration",
+				fmt.Sprintf("Could not resolve plugin_directory %q: %v", pluginDir, err),
+			)
+			return
+		}
+		pluginDir = abs
+	}
+	maxBuilds := 0
+	if v := cfg.MaxConcurrentBuilds; !v.IsNull() && !v.IsUnknown() {
+		if v.ValueInt64() < 1 {
+			resp.Diagnostics.AddAttributeError(
+				path.Root("max_concurrent_builds"),
+				"Invalid provider configuration",
+				"max_concurrent_builds must be at least 1.",
+			)
+			return
+		}
+		maxBuilds = int(v.ValueInt64())
+	}
+	opts.CacheDir = knownStringValue(cfg.PackerBinaryCacheDir)
+
+	// Guessing with an unknown attribute that shapes which binary is used
+	// would silently pick the wrong binary.
+	if unknown := unknownAttributes(req.Config.Raw, binarySelectionAttributes); len(unknown) > 0 {
+		if req.ClientCapabilities.DeferralAllowed {
+			resp.Deferred = &provider.Deferred{Reason: provider.DeferredReasonProviderConfigUnknown}
+			return
+		}
+		resolver := newBinaryResolver(func(_ context.Context, diags *diag.Diagnostics) providerSettings {
+			diags.AddError(
+				"Unknown Packer binary configuration",
+				fmt.Sprintf(
+					"The provider attributes %s depend on values that are not known yet, so the Packer binary "+
+						"cannot be chosen. Apply the resources they depend on first (for example with -target), "+
+						"or use a Terraform version that supports deferred actions.",
+					strings.Join(unknown, ", "),
+				),
+			)
+			return providerSettings{}
+		})
+		data := newProviderData(resolver, maxBuilds, pluginDir, opts)
+		resp.DataSourceData = data
+		resp.ResourceData = data
+		return
+	}
+
+	opts.DisableCache = cfg.PackerBinaryCacheDisabled.ValueBool()
+	var limits cacheLimits
+	if v := cfg.PackerBinaryCacheMaxSizeMB; !v.IsNull() && !v.IsUnknown() {
+		limits.MaxBytes = v.ValueInt64() * 1024 * 1024
+	}
+	if v := cfg.PackerBinaryCacheMaxEntries; !v.IsNull() && !v.IsUnknown() {
+		limits.MaxEntries = int(v.ValueInt64())
+	}
+	if limits.MaxBytes < 0 || limits.MaxEntries < 0 {
+		resp.Diagnostics.AddError(
+			"Invalid provider configuration",
+			"packer_binary_cache_max_size_mb and packer_binary_cache_max_entries must not be negative.",
+		)
+		return
+	}
+	if opts.DisableCache && (opts.CacheDir != "" || limits.enabled() || opts.LockTimeout > 0) {
+		resp.Diagnostics.AddError(
+			"Conflicting provider configuration",
+			"packer_binary_cache_disabled conflicts with packer_binary_cache_dir, "+
+				"packer_binary_cache_max_size_mb, packer_binary_cache_max_entries and packer_binary_cache_lock_timeout.",
+		)
+		return
+	}
+	archivePath := knownStringValue(cfg.PackerBinaryArchivePath)
+	ociRef := knownStringValue(cfg.PackerBinaryOCI)
+	discover := cfg.PackerBinaryDiscovery.ValueBool()
+	searchPaths, diags := knownStringList(ctx, cfg.PackerBinarySearchPaths)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	allowEmbedded := cfg.AllowEmbedded.IsNull() || cfg.AllowEmbedded.ValueBool()
+	var constraints version.Constraints
+	if raw := knownStringValue(cfg.PackerBinaryVersionConstraint); raw != "" {
+		var err error
+		if constraints, err = version.NewConstraint(raw); err != nil {
+			resp.Diagnostics.AddAttributeError(
+				path.Root("packer_binary_version_constraint"),
+				"Invalid provider configuration",
+				fmt.Sprintf("packer_binary_version_constraint is not a valid version constraint: %v", err),
+			)
+			return
+		}
+	}
+	registryAuth := ociAuth{
+		Username: knownStringValue(cfg.PackerBinaryOCIUsername),
+		Password: knownStringValue(cfg.PackerBinaryOCIPassword),
+		Token:    knownStringValue(cfg.PackerBinaryOCIToken),
+	}
+	checksumsURL := knownStringValue(cfg.PackerBinaryChecksumsURL)
+	signatureURL := knownStringValue(cfg.PackerBinaryChecksumsSignatureURL)
+	pgpKey := knownStringValue(cfg.PackerBinaryPGPPublicKey)
+	sshKey := knownStringValue(cfg.PackerBinarySSHPublicKey)
+	minisignKey := knownStringValue(cfg.PackerBinaryMinisignPublicKey)
 
 	if binPath != "" && binURL != "" {
 		resp.Diagnostics.AddError(
@@ -132,61 +647,304 @@ func (p *tfProvider) Configure(ctx context.Context, req provider.ConfigureReques
 		)
 		return
 	}
-	if checksum != "" && binURL == "" {
+	if discover && (binPath != "" || binURL != "" || ociRef != "") {
+		resp.Diagnostics.AddError(
+			"Conflicting provider configuration",
+			"packer_binary_discovery conflicts with packer_binary, packer_binary_url and packer_binary_oci.",
+		)
+		return
+	}
+	if len(searchPaths) > 0 && !discover {
 		resp.Diagnostics.AddError(
 			"Invalid provider configuration",
-			"packer_binary_checksum requires packer_binary_url to be set.",
+			"packer_binary_search_paths requires packer_binary_discovery to be enabled.",
+		)
+		return
+	}
+	if ociRef != "" && (binPath != "" || binURL != "") {
+		resp.Diagnostics.AddError(
+			"Conflicting provider configuration",
+			"packer_binary_oci conflicts with packer_binary and packer_binary_url. Configure at most one of them.",
+		)
+		return
+	}
+	if ociRef == "" && (registryAuth.Username != "" || registryAuth.Password != "" || registryAuth.Token != "") {
+		resp.Diagnostics.AddError(
+			"Invalid provider configuration",
+			"packer_binary_oci_username, packer_binary_oci_password and packer_binary_oci_token "+
+				"require packer_binary_oci to be set.",
+		)
+		return
+	}
+	if registryAuth.Token != "" && registryAuth.Username != "" {
+		resp.Diagnostics.AddError(
+			"Conflicting provider configuration",
+			"packer_binary_oci_token and packer_binary_oci_username are mutually exclusive. Configure at most one of them.",
+		)
+		return
+	}
+	if checksum != "" && binURL == "" && binPath == "" && ociRef == "" {
+		resp.Diagnostics.AddError(
+			"Invalid provider configuration",
+			"packer_binary_checksum requires packer_binary, packer_binary_url or packer_binary_oci to be set.",
+		)
+		return
+	}
+	if len(mirrors) > 0 && binURL == "" {
+		resp.Diagnostics.AddError(
+			"Invalid provider configuration",
+			"packer_binary_mirrors requires packer_binary_url to be set.",
+		)
+		return
+	}
+	if len(platformChecksums) > 0 && binURL == "" {
+		resp.Diagnostics.AddError(
+			"Invalid provider configuration",
+			"packer_binary_checksums requires packer_binary_url to be set.",
+		)
+		return
+	}
+	if len(platformChecksums) > 0 && (checksum != "" || checksumsURL != "") {
+		resp.Diagnostics.AddError(
+			"Conflicting provider configuration",
+			"packer_binary_checksums conflicts with packer_binary_checksum and packer_binary_checksums_url. "+
+				"Configure only one way of verifying the download.",
+		)
+		return
+	}
+	if archivePath != "" && binURL == "" && ociRef == "" {
+		resp.Diagnostics.AddError(
+			"Invalid provider configuration",
+			"packer_binary_archive_path requires packer_binary_url or packer_binary_oci to be set.",
+		)
+		return
+	}
+	if checksumsURL != "" && binURL == "" {
+		resp.Diagnostics.AddError(
+			"Invalid provider configuration",
+			"packer_binary_checksums_url requires packer_binary_url to be set.",
+		)
+		return
+	}
+	if checksumsURL != "" && checksum != "" {
+		resp.Diagnostics.AddError(
+			"Conflicting provider configuration",
+			"packer_binary_checksums_url and packer_binary_checksum are mutually exclusive. Configure at most one of them.",
+		)
+		return
+	}
+	if checksumsURL == "" && (signatureURL != "" || pgpKey != "" || sshKey != "" || minisignKey != "") {
+		resp.Diagnostics.AddError(
+			"Invalid provider configuration",
+			"packer_binary_checksums_signature_url and the packer_binary_*_public_key attributes "+
+				"require packer_binary_checksums_url to be set.",
 		)
 		return
 	}
 
-	// Resolve binary to use and validate
-	bin := binPath
-	if binURL != "" {
-		downloaded, err := ensureDownloadedPackerBinary(ctx, binURL, checksum)
+	goos, goarch := currentPlatform()
+	for _, u := range []*string{&binURL, &checksumsURL, &signatureURL} {
+		expanded, err := expandBinaryURL(*u, goos, goarch, binVersion)
 		if err != nil {
-			resp.Diagnostics.AddError(
-				"Failed to download Packer binary",
-				fmt.Sprintf("Could not provide a Packer binary from packer_binary_url.\nURL: %s\nError: %v", binURL, err),
-			)
+			resp.Diagnostics.AddError("Invalid provider configuration", err.Error())
 			return
 		}
-		bin = downloaded
+		*u = expanded
 	}
-	if bin != "" {
-		// Validate external packer with pass-through env; do not force embedded re-exec
-		envExternal := map[string]string{"CHECKPOINT_DISABLE": "1"}
-		if out, err := runCommandWithEnvCapture(bin, envExternal, "version"); err != nil {
-			resp.Diagnostics.AddError(
-				"Invalid packer_binary",
-				fmt.Sprintf(
-					"Failed to execute provided Packer binary.\nBinary: %s\nError: %v\nOutput:\n%s",
-					bin,
-					err,
-					strings.TrimSpace(string(out)),
-				),
-			)
+	for i, mirror := range opts.Mirrors {
+		expanded, err := expandBinaryURL(mirror, goos, goarch, binVersion)
+		if err != nil {
+			resp.Diagnostics.AddError("Invalid provider configuration", err.Error())
 			return
 		}
-	} else {
-		exe, _ := os.Executable()
-		// Validate embedded packer with re-exec env
-		envEmbedded := packer_interop.EnvVars(map[string]string{}, true)
-		if out, err := runCommandWithEnvCapture(exe, envEmbedded, "version"); err != nil {
-			resp.Diagnostics.AddError(
-				"Embedded Packer unavailable",
-				fmt.Sprintf(
-					"Failed to execute embedded Packer.\nError: %v\nOutput:\n%s",
-					err,
-					strings.TrimSpace(string(out)),
-				),
-			)
+		opts.Mirrors[i] = expanded
+	}
+	if len(platformChecksums) > 0 {
+		var err error
+		if checksum, err = checksumForPlatform(platformChecksums, platformKey(goos, goarch)); err != nil {
+			resp.Diagnostics.AddError("Unsupported platform", err.Error())
 			return
 		}
 	}
 
-	p.packerBinary = bin
-	settings := providerSettings{PackerBinary: p.packerBinary}
-	resp.DataSourceData = settings
-	resp.ResourceData = settings
+	var namedBinaries map[string]namedBinaryModel
+	if !cfg.Binaries.IsNull() && !cfg.Binaries.IsUnknown() {
+		resp.Diagnostics.Append(cfg.Binaries.ElementsAs(ctx, &namedBinaries, false)...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+	}
+
+	// Providing the binaries may download them; defer it until an operation
+	// runs Packer so that plans which do not build pay nothing.
+	resolver := newBinaryResolver(func(ctx context.Context, diags *diag.Diagnostics) providerSettings {
+		// Resolve binary to use and validate
+		bin := binPath
+		if binPath != "" && checksum != "" {
+			verified, err := verifyLocalPackerBinary(binPath, checksum)
+			if err != nil {
+				diags.AddError(
+					"Invalid packer_binary",
+					fmt.Sprintf("Could not verify packer_binary against packer_binary_checksum.\nError: %v", err),
+				)
+				return providerSettings{}
+			}
+			bin = verified
+		}
+		if checksumsURL != "" {
+			verifier, err := newSignatureVerifier(pgpKey, sshKey, minisignKey)
+			if err != nil {
+				diags.AddError("Invalid provider configuration", err.Error())
+				return providerSettings{}
+			}
+			checksum, err = resolveSignedChecksum(ctx, binURL, checksumsSource{
+				URL:          checksumsURL,
+				SignatureURL: signatureURL,
+				Verifier:     verifier,
+			}, opts)
+			if err != nil {
+				diags.AddError(
+					"Failed to verify Packer binary checksums",
+					fmt.Sprintf("Could not obtain a verified checksum for packer_binary_url.\nChecksums URL: %s\nError: %v", checksumsURL, err),
+				)
+				return providerSettings{}
+			}
+		}
+		if binURL != "" {
+			opts.ArchivePath = archivePath
+			downloaded, err := ensureDownloadedPackerBinary(ctx, binURL, checksum, opts)
+			if err != nil {
+				diags.AddError(
+					"Failed to download Packer binary",
+					fmt.Sprintf("Could not provide a Packer binary from packer_binary_url.\nURL: %s\nError: %v", binURL, err),
+				)
+				return providerSettings{}
+			}
+			bin = downloaded
+		}
+		if ociRef != "" {
+			opts.ArchivePath = archivePath
+			pulled, err := ensureOCIPackerBinary(ctx, ociRef, checksum, registryAuth, opts)
+			if err != nil {
+				diags.AddError(
+					"Failed to pull Packer binary",
+					fmt.Sprintf("Could not provide a Packer binary from packer_binary_oci.\nReference: %s\nError: %v", ociRef, err),
+				)
+				return providerSettings{}
+			}
+			bin = pulled
+		}
+		if discover {
+			found, err := discoverPackerBinary(discoveryOptions{SearchPaths: searchPaths, Constraints: constraints})
+			switch {
+			case err == nil:
+				bin = found.Path
+			case errors.Is(err, errNoPackerBinaryFound) && allowEmbedded:
+				diags.AddWarning(
+					"No Packer binary discovered",
+					fmt.Sprintf("Falling back to the embedded Packer build. Set allow_embedded = false to fail instead.\nDetails: %v", err),
+				)
+			default:
+				diags.AddError("Failed to discover Packer binary", err.Error())
+				return providerSettings{}
+			}
+		}
+		if bin == "" && !allowEmbedded {
+			diags.AddError(
+				"Embedded Packer not allowed",
+				"No Packer binary is configured or was discovered, and allow_embedded is false. Configure "+
+					"packer_binary, packer_binary_url, packer_binary_oci or packer_binary_discovery.",
+			)
+			return providerSettings{}
+		}
+
+		// Validates the binary by running `version`; the result is memoized for
+		// the resources and data sources using it.
+		binInfo, err := binaryInfos.get(bin)
+		if err != nil {
+			if bin != "" {
+				diags.AddError(
+					"Invalid packer_binary",
+					fmt.Sprintf(
+						"Failed to execute provided Packer binary.\nBinary: %s\nError: %v\nOutput:\n%s",
+						bin,
+						err,
+						strings.TrimSpace(string(binInfo.Output)),
+					),
+				)
+			} else {
+				diags.AddError(
+					"Embedded Packer unavailable",
+					fmt.Sprintf(
+						"Failed to execute embedded Packer.\nError: %v\nOutput:\n%s",
+						err,
+						strings.TrimSpace(string(binInfo.Output)),
+					),
+				)
+			}
+			return providerSettings{}
+		}
+		if constraints != nil {
+			v, err := parsePackerVersion(binInfo.Output)
+			if err != nil {
+				diags.AddError("Unknown Packer version", err.Error())
+				return providerSettings{}
+			}
+			if !satisfiesConstraints(constraints, v) {
+				binary := bin
+				if binary == "" {
+					binary = "the embedded build"
+				}
+				diags.AddError(
+					"Unsupported Packer version",
+					fmt.Sprintf("Packer %s (%s) does not satisfy packer_binary_version_constraint %q.", v, binary, constraints),
+				)
+				return providerSettings{}
+			}
+		}
+
+		binaries := make(map[string]string, len(namedBinaries))
+		for name, m := range namedBinaries {
+			resolved, err := resolveNamedBinary(ctx, name, m, opts)
+			if err != nil {
+				diags.AddError(
+					"Invalid Packer binary",
+					fmt.Sprintf("Could not provide binary %q.\nError: %v", name, err),
+				)
+				return providerSettings{}
+			}
+			if info, err := binaryInfos.get(resolved); err != nil {
+				diags.AddError(
+					"Invalid Packer binary",
+					fmt.Sprintf(
+						"Failed to execute binary %q.\nBinary: %s\nError: %v\nOutput:\n%s",
+						name, resolved, err, strings.TrimSpace(string(info.Output)),
+					),
+				)
+				return providerSettings{}
+			}
+			binaries[name] = resolved
+		}
+
+		if !opts.DisableCache && limits.enabled() {
+			// Only downloaded binaries live in the cache; keeping the
+			// directories of local paths as well is harmless.
+			keep := []string{filepath.Dir(bin)}
+			for _, resolved := range binaries {
+				keep = append(keep, filepath.Dir(resolved))
+			}
+			if _, err := pruneDownloadCache(opts.cacheBaseDir(), limits, keep...); err != nil {
+				diags.AddWarning("Failed to prune Packer binary cache", err.Error())
+			}
+		}
+
+		return providerSettings{
+			PackerBinary:  bin,
+			Binaries:      binaries,
+			AllowEmbedded: allowEmbedded,
+		}
+	})
+	data := newProviderData(resolver, maxBuilds, pluginDir, opts)
+	resp.DataSourceData = data
+	resp.ResourceData = data
 }
@@ -0,0 +1,111 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+// providerConfig builds a provider configuration with every attribute null
+// except those in values.
+func providerConfig(t *testing.T, values map[string]tftypes.Value) tfsdk.Config {
+	t.Helper()
+	ctx := context.Background()
+	var schemaResp provider.SchemaResponse
+	(&tfProvider{}).Schema(ctx, provider.SchemaRequest{}, &schemaResp)
+	objectType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	attrs := map[string]tftypes.Value{}
+	for name, typ := range objectType.AttributeTypes {
+		attrs[name] = tftypes.NewValue(typ, nil)
+	}
+	for name, v := range values {
+		attrs[name] = v
+	}
+	return tfsdk.Config{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objectType, attrs)}
+}
+
+func TestConfigureDefersUnknownBinary(t *testing.T) {
+	config := providerConfig(t, map[string]tftypes.Value{
+		"packer_binary_url": tftypes.NewValue(tftypes.String, tftypes.UnknownValue),
+	})
+
+	req := provider.ConfigureRequest{Config: config}
+	req.ClientCapabilities.DeferralAllowed = true
+	var resp provider.ConfigureResponse
+	(&tfProvider{}).Configure(context.Background(), req, &resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+	if resp.Deferred == nil || resp.Deferred.Reason != provider.DeferredReasonProviderConfigUnknown {
+		t.Errorf("Deferred = %+v, want provider config unknown", resp.Deferred)
+	}
+
+	req.ClientCapabilities.DeferralAllowed = false
+	resp = provider.ConfigureResponse{}
+	(&tfProvider{}).Configure(context.Background(), req, &resp)
+	if resp.Diagnostics.HasError() || resp.Deferred != nil {
+		t.Fatalf("Configure should succeed without deferring, got %v, %+v", resp.Diagnostics, resp.Deferred)
+	}
+	data, ok := resp.ResourceData.(*providerData)
+	if !ok {
+		t.Fatalf("ResourceData is %T, want *providerData", resp.ResourceData)
+	}
+	_, diags := data.binaries.get(context.Background())
+	if !diags.HasError() || !strings.Contains(diags.Errors()[0].Detail(), "packer_binary_url") {
+		t.Errorf("expected an error naming packer_binary_url, got %v", diags)
+	}
+}
+
+func TestConfigureOnlyDefersUnknownBinarySelection(t *testing.T) {
+	config := providerConfig(t, map[string]tftypes.Value{
+		"max_concurrent_builds": tftypes.NewValue(tftypes.Number, tftypes.UnknownValue),
+		"plugin_directory":      tftypes.NewValue(tftypes.String, tftypes.UnknownValue),
+	})
+	req := provider.ConfigureRequest{Config: config}
+	req.ClientCapabilities.DeferralAllowed = true
+	var resp provider.ConfigureResponse
+	(&tfProvider{}).Configure(context.Background(), req, &resp)
+	if resp.Diagnostics.HasError() || resp.Deferred != nil {
+		t.Errorf("got %v, %+v; want a configured provider", resp.Diagnostics, resp.Deferred)
+	}
+}
+
+func TestConfigureUnknownBinaryKeepsSettings(t *testing.T) {
+	config := providerConfig(t, map[string]tftypes.Value{
+		"packer_binary":         tftypes.NewValue(tftypes.String, tftypes.UnknownValue),
+		"max_concurrent_builds": tftypes.NewValue(tftypes.Number, 2),
+	})
+	var resp provider.ConfigureResponse
+	(&tfProvider{}).Configure(context.Background(), provider.ConfigureRequest{Config: config}, &resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+	data := resp.ResourceData.(*providerData)
+	if data.builds == nil || cap(data.builds.slots) != 2 || data.inits == nil || data.sources == nil {
+		t.Errorf("providerData = %+v, want the same data as a known configuration", data)
+	}
+}
+
+func TestBinarySelectionAttributesExist(t *testing.T) {
+	var schemaResp provider.SchemaResponse
+	(&tfProvider{}).Schema(context.Background(), provider.SchemaRequest{}, &schemaResp)
+	for name := range binarySelectionAttributes {
+		if _, ok := schemaResp.Schema.Attributes[name]; !ok {
+			t.Errorf("%s is not a provider attribute", name)
+		}
+	}
+}
+
+func TestConfigureKnownConfigIsNotDeferred(t *testing.T) {
+	req := provider.ConfigureRequest{Config: providerConfig(t, nil)}
+	req.ClientCapabilities.DeferralAllowed = true
+	var resp provider.ConfigureResponse
+	(&tfProvider{}).Configure(context.Background(), req, &resp)
+	if resp.Diagnostics.HasError() || resp.Deferred != nil {
+		t.Errorf("got %v, %+v; want a configured provider", resp.Diagnostics, resp.Deferred)
+	}
+}
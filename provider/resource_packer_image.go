@@ -17,8 +17,11 @@ import (
 	"github.com/hashicorp/terraform-plugin-framework/attr"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/dynamicplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/mapplanmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
 
 	"github.com/hashicorp/terraform-plugin-framework/provider"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
@@ -46,6 +49,18 @@ type resourceImageType struct {
 	PackerVersion      types.String      `tfsdk:"packer_version"`
 	ManifestPath       types.String      `tfsdk:"manifest_path"`
 	Manifest           types.Dynamic     `tfsdk:"manifest"`
+	Binary             types.String      `tfsdk:"binary"`
+	ConcurrencyGroup   types.String      `tfsdk:"concurrency_group"`
+	InitMode           types.String      `tfsdk:"init_mode"`
+	IsolatePlugins     types.Bool        `tfsdk:"isolate_plugins"`
+	Plugins            types.Map         `tfsdk:"plugins"`
+	OnPluginChange     types.String      `tfsdk:"on_plugin_change"`
+	TemplateContent    types.String      `tfsdk:"template_content"`
+	Files              types.Map         `tfsdk:"files"`
+	Source             types.String      `tfsdk:"source"`
+	SourceRevision     types.String      `tfsdk:"source_revision"`
+	UpgradeLegacyJSON  types.Bool        `tfsdk:"upgrade_legacy_json"`
+	UpgradedTemplate   types.String      `tfsdk:"upgraded_template"`
 }
 
 type resourceImageTypeV0 struct {
@@ -92,6 +107,42 @@ type resourceImageTypeV2 struct {
 	Name               types.String      `tfsdk:"name"`
 }
 
+// Version 3 state (before manifest support)
+type resourceImageTypeV3 struct {
+	ID                 types.String      `tfsdk:"id"`
+	Variables          types.Dynamic     `tfsdk:"variables"`
+	SensitiveVariables types.Dynamic     `tfsdk:"sensitive_variables"`
+	AdditionalParams   []string          `tfsdk:"additional_params"`
+	Directory          types.String      `tfsdk:"directory"`
+	File               types.String      `tfsdk:"file"`
+	Environment        map[string]string `tfsdk:"environment"`
+	IgnoreEnvironment  types.Bool        `tfsdk:"ignore_environment"`
+	Triggers           map[string]string `tfsdk:"triggers"`
+	Force              types.Bool        `tfsdk:"force"`
+	BuildUUID          types.String      `tfsdk:"build_uuid"`
+	Name               types.String      `tfsdk:"name"`
+	PackerVersion      types.String      `tfsdk:"packer_version"`
+}
+
+// Version 4 state (before write-only sensitive_variables)
+type resourceImageTypeV4 struct {
+	ID                 types.String      `tfsdk:"id"`
+	Variables          types.Dynamic     `tfsdk:"variables"`
+	SensitiveVariables types.Dynamic     `tfsdk:"sensitive_variables"`
+	AdditionalParams   []string          `tfsdk:"additional_params"`
+	Directory          types.String      `tfsdk:"directory"`
+	File               types.String      `tfsdk:"file"`
+	Environment        map[string]string `tfsdk:"environment"`
+	IgnoreEnvironment  types.Bool        `tfsdk:"ignore_environment"`
+	Triggers           map[string]string `tfsdk:"triggers"`
+	Force              types.Bool        `tfsdk:"force"`
+	BuildUUID          types.String      `tfsdk:"build_uuid"`
+	Name               types.String      `tfsdk:"name"`
+	PackerVersion      types.String      `tfsdk:"packer_version"`
+	ManifestPath       types.String      `tfsdk:"manifest_path"`
+	Manifest           types.Dynamic     `tfsdk:"manifest"`
+}
+
 func (r resourceImageType) NewResource(_ context.Context, p provider.Provider) (resource.Resource, diag.Diagnostics) {
 	return &resourceImage{
 		p: *(p.(*tfProvider)),
@@ -99,8 +150,26 @@ func (r resourceImageType) NewResource(_ context.Context, p provider.Provider) (
 }
 
 type resourceImage struct {
-	p            tfProvider
-	packerBinary string
+	p        tfProvider
+	binaries *binaryResolver
+	builds   *buildLimiter
+	inits    *initCoordinator
+	sources  *sourceFetcher
+	// cacheDir holds the isolated plugin directories; see
+	// isolatedPluginDirectory.
+	cacheDir string
+	// settings holds the resolved binaries during an operation that runs
+	// Packer; see withBinaries.
+	settings providerSettings
+	// scratchDir is the directory Packer runs in for template_content; see
+	// withScratchDir.
+	scratchDir string
+	// sourceDir is the checkout of source that directory is relative to;
+	// see withSource.
+	sourceDir string
+	// upgradedFile is the HCL2 conversion of a legacy JSON template that
+	// Packer builds instead; see withUpgradedTemplate.
+	upgradedFile string
 }
 
 func (r resourceImage) Metadata(_ context.Context, _ resource.MetadataRequest, resp *resource.MetadataResponse) {
@@ -113,20 +182,36 @@ func (r *resourceImage) Configure(_ context.Context, req resource.ConfigureReque
 	if req.ProviderData == nil {
 		return
 	}
-	if settings, ok := req.ProviderData.(providerSettings); ok {
-		r.packerBinary = settings.PackerBinary
+	if data, ok := req.ProviderData.(*providerData); ok {
+		r.binaries = data.binaries
+		r.builds = data.builds
+		r.inits = data.inits
+		r.sources = data.sources
+		r.cacheDir = data.cacheDir
 	}
 }
 
+// withBinaries returns a copy of r with the provider's binaries resolved,
+// which happens on the first operation of the provider that runs Packer.
+func (r resourceImage) withBinaries(ctx context.Context, diags *diag.Diagnostics) resourceImage {
+	settings, resolveDiags := r.binaries.get(ctx)
+	diags.Append(resolveDiags...)
+	r.settings = settings
+	return r
+}
+
 func (r resourceImage) Schema(_ context.Context, _ resource.SchemaRequest, response *resource.SchemaResponse) {
 	*response = resource.SchemaResponse{
 		Schema: schema.Schema{
 			Attributes: map[string]schema.Attribute{
 				"id": schema.StringAttribute{
 					Computed: true,
+					PlanModifiers: []planmodifier.String{
+						stringplanmodifier.UseStateForUnknown(),
+					},
 				},
 				"name": schema.StringAttribute{
-					Description: "Name of this build. This value is not passed to Packer.",
+					Description: "Name of this build. This value is not passed to Packer; changing it does not trigger a build.",
 					Optional:    true,
 				},
 				"variables": schema.DynamicAttribute{
@@ -149,13 +234,60 @@ func (r resourceImage) Schema(_ context.Context, _ resource.SchemaRequest, respo
 					Optional:    true,
 				},
 				"directory": schema.StringAttribute{
-					Description: "Working directory to run Packer inside. Default is cwd.",
-					Optional:    true,
+					Description: "Working directory to run Packer inside. Default is cwd. With `source`, it is " +
+						"relative to the fetched source.",
+					Optional: true,
 				},
 				"file": schema.StringAttribute{
 					Description: "Packer file to use for building",
 					Optional:    true,
 				},
+				"template_content": schema.StringAttribute{
+					Description: "Packer template to build, in HCL, HCL's JSON syntax or the legacy JSON format, e.g. " +
+						"rendered with `templatefile()`. Packer then runs in a scratch directory that holds only this " +
+						"template and `files`, and is removed after the run. Conflicts with `file`, `directory` and `source`.",
+					Optional: true,
+				},
+				"files": schema.MapAttribute{
+					Description: "Files written next to `template_content` before Packer runs, such as provisioning " +
+						"scripts, as relative path to content. Requires `template_content`.",
+					ElementType: types.StringType,
+					Optional:    true,
+				},
+				"source": schema.StringAttribute{
+					Description: "go-getter address of the templates to build, fetched into a cache before Packer " +
+						"runs in them, e.g. `git::https://example.com/templates.git?ref=v1.2.0`, a local bare " +
+						"repository (`git::/srv/templates.git`) or an archive (`https://example.com/templates.tar.gz`). " +
+						"A subdirectory is selected with `//`, e.g. `git::https://example.com/templates.git//ubuntu`, " +
+						"or with `directory`. Conflicts with `template_content`.",
+					Optional: true,
+				},
+				"source_revision": schema.StringAttribute{
+					Description: "Revision of `source` the last build used: the commit of a git source, else the " +
+						"`sha256:` digest of the fetched files. The source is fetched again when a plan changes the " +
+						"image; a new revision, e.g. of a branch or a moved tag, then runs a new build. Plans without " +
+						"other changes do not fetch the source.",
+					Computed: true,
+					PlanModifiers: []planmodifier.String{
+						stringplanmodifier.UseStateForUnknown(),
+					},
+				},
+				"upgrade_legacy_json": schema.BoolAttribute{
+					Description: "Converts a legacy JSON template with `packer fix` and `packer hcl2_upgrade` into a " +
+						"scratch directory before each run and builds the converted HCL2 template instead; Packer " +
+						"still runs in `directory`. Blocks that cannot be converted are reported as warnings. " +
+						"HCL2 templates are built as they are. Plugin changes of converted templates are not detected " +
+						"at plan time. Changing this runs a new build.",
+					Optional: true,
+				},
+				"upgraded_template": schema.StringAttribute{
+					Description: "HCL2 template the legacy JSON template was converted to by the last build with " +
+						"`upgrade_legacy_json`, e.g. to migrate it; null if no template was converted.",
+					Computed: true,
+					PlanModifiers: []planmodifier.String{
+						stringplanmodifier.UseStateForUnknown(),
+					},
+				},
 				"force": schema.BoolAttribute{
 					Description: "Force overwriting existing images",
 					Optional:    true,
@@ -177,6 +309,9 @@ func (r resourceImage) Schema(_ context.Context, _ resource.SchemaRequest, respo
 				"build_uuid": schema.StringAttribute{
 					Description: "UUID that is updated whenever the build has finished. This allows detecting changes.",
 					Computed:    true,
+					PlanModifiers: []planmodifier.String{
+						stringplanmodifier.UseStateForUnknown(),
+					},
 				},
 				"packer_version": schema.StringAttribute{
 					Description: "Detected Packer version used for this resource. Changing this forces replacement.",
@@ -187,12 +322,78 @@ func (r resourceImage) Schema(_ context.Context, _ resource.SchemaRequest, respo
 					},
 				},
 				"manifest_path": schema.StringAttribute{
-					Description: "Path to the Packer manifest JSON to read after build. If set, a manifest must be written to that path. If unset, the provider passes a temporary path via environment variable TPP_MANIFEST_PATH; if Packer does not create it, the manifest remains null.",
+					Description: "Path to the Packer manifest JSON to read after build. If set, a manifest must be written to that path. If unset, the provider passes a temporary path via environment variable TPP_MANIFEST_PATH; if Packer does not create it, the manifest remains null. Changing only this path does not trigger a build; the manifest is read again on the next build.",
 					Optional:    true,
 				},
+				"binary": schema.StringAttribute{
+					Description: "Name of an entry of the provider's `binaries` to build with, or `embedded` for the " +
+						"embedded Packer build. Defaults to the provider's Packer binary. Changing it runs a new build.",
+					Optional: true,
+				},
+				"concurrency_group": schema.StringAttribute{
+					Description: "Builds of `packer_image` resources with the same concurrency group run one at a " +
+						"time, e.g. those sharing a cloud account quota or a hypervisor. Other builds run in parallel, " +
+						"up to the provider's `max_concurrent_builds`. Changing it does not run a new build.",
+					Optional: true,
+				},
+				"init_mode": schema.StringAttribute{
+					Description: "When to run `packer init` before a build: `if_needed` (default) skips it when the " +
+						"plugins in the template's `required_plugins` are already installed, `always` runs it, `upgrade` " +
+						"runs `packer init -upgrade` and `never` skips it. Init runs at most once per template and apply, " +
+						"and never concurrently with another init into the same plugin directory. Changing it does not " +
+						"run a new build.",
+					Optional: true,
+					Validators: []validator.String{
+						OneOfStringValidator{Values: initModes},
+					},
+				},
+				"isolate_plugins": schema.BoolAttribute{
+					Description: "Gives this resource a plugin directory of its own instead of the provider's " +
+						"`plugin_directory`, so that its `packer init` cannot affect other builds. The directory is " +
+						"removed when the resource is destroyed. Changing this runs a new build.",
+					Optional: true,
+				},
+				"plugins": schema.MapNestedAttribute{
+					Description: "Plugins of the template's `required_plugins` that the last build used, keyed by " +
+						"source address. When the installed plugins differ at plan time, e.g. after a plugin upgrade, " +
+						"`on_plugin_change` decides what happens. Plugins that are not installed at plan time are not " +
+						"compared.",
+					Computed: true,
+					NestedObject: schema.NestedAttributeObject{
+						Attributes: map[string]schema.Attribute{
+							"name": schema.StringAttribute{
+								Description: "Name of the plugin in `required_plugins`.",
+								Computed:    true,
+							},
+							"version": schema.StringAttribute{
+								Description: "Version of the plugin.",
+								Computed:    true,
+							},
+							"checksum": schema.StringAttribute{
+								Description: "SHA-256 checksum of the plugin binary.",
+								Computed:    true,
+							},
+						},
+					},
+					PlanModifiers: []planmodifier.Map{
+						mapplanmodifier.UseStateForUnknown(),
+					},
+				},
+				"on_plugin_change": schema.StringAttribute{
+					Description: "What a plan does when the installed plugins differ from the `plugins` of the last " +
+						"build: `rebuild` (default) plans an update that runs a new build, `warn` only warns. Changing " +
+						"it does not run a new build.",
+					Optional: true,
+					Validators: []validator.String{
+						OneOfStringValidator{Values: pluginChangePolicies},
+					},
+				},
 				"manifest": schema.DynamicAttribute{
 					Description: "Packer manifest content decoded as a dynamic value. Access fields directly in Terraform.",
 					Computed:    true,
+					PlanModifiers: []planmodifier.Dynamic{
+						dynamicplanmodifier.UseStateForUnknown(),
+					},
 				},
 			},
 			Version: 5,
@@ -266,6 +467,8 @@ func (r resourceImage) UpgradeState(ctx context.Context) map[int64]resource.Stat
 					Force:             priorStateData.Force,
 					BuildUUID:         priorStateData.BuildUUID,
 					Name:              priorStateData.Name,
+					Plugins:           types.MapNull(imagePluginType),
+					Files:             types.MapNull(types.StringType),
 				}
 				resp.Diagnostics.Append(resp.State.Set(ctx, upgradedStateData)...)
 			},
@@ -330,6 +533,8 @@ func (r resourceImage) UpgradeState(ctx context.Context) map[int64]resource.Stat
 					Force:              priorStateData.Force,
 					BuildUUID:          priorStateData.BuildUUID,
 					Name:               priorStateData.Name,
+					Plugins:            types.MapNull(imagePluginType),
+					Files:              types.MapNull(types.StringType),
 				}
 				resp.Diagnostics.Append(resp.State.Set(ctx, upgradedStateData)...)
 			},
@@ -369,6 +574,8 @@ func (r resourceImage) UpgradeState(ctx context.Context) map[int64]resource.Stat
 					Force:              prior.Force,
 					BuildUUID:          prior.BuildUUID,
 					Name:               prior.Name,
+					Plugins:            types.MapNull(imagePluginType),
+					Files:              types.MapNull(types.StringType),
 					PackerVersion:      types.StringNull(),
 					ManifestPath:       types.StringNull(),
 					Manifest:           types.DynamicNull(),
@@ -396,7 +603,7 @@ func (r resourceImage) UpgradeState(ctx context.Context) map[int64]resource.Stat
 				},
 			},
 			StateUpgrader: func(ctx context.Context, req resource.UpgradeStateRequest, resp *resource.UpgradeStateResponse) {
-				var prior resourceImageType
+				var prior resourceImageTypeV3
 				resp.Diagnostics.Append(req.State.Get(ctx, &prior)...)
 				if resp.Diagnostics.HasError() {
 					return
@@ -414,6 +621,8 @@ func (r resourceImage) UpgradeState(ctx context.Context) map[int64]resource.Stat
 					Force:              prior.Force,
 					BuildUUID:          prior.BuildUUID,
 					Name:               prior.Name,
+					Plugins:            types.MapNull(imagePluginType),
+					Files:              types.MapNull(types.StringType),
 					PackerVersion:      prior.PackerVersion,
 					ManifestPath:       types.StringNull(),
 					Manifest:           types.DynamicNull(),
@@ -443,7 +652,7 @@ func (r resourceImage) UpgradeState(ctx context.Context) map[int64]resource.Stat
 				},
 			},
 			StateUpgrader: func(ctx context.Context, req resource.UpgradeStateRequest, resp *resource.UpgradeStateResponse) {
-				var prior resourceImageType
+				var prior resourceImageTypeV4
 				resp.Diagnostics.Append(req.State.Get(ctx, &prior)...)
 				if resp.Diagnostics.HasError() {
 					return
@@ -462,6 +671,8 @@ func (r resourceImage) UpgradeState(ctx context.Context) map[int64]resource.Stat
 					Force:              prior.Force,
 					BuildUUID:          prior.BuildUUID,
 					Name:               prior.Name,
+					Plugins:            types.MapNull(imagePluginType),
+					Files:              types.MapNull(types.StringType),
 					PackerVersion:      prior.PackerVersion,
 					ManifestPath:       prior.ManifestPath,
 					Manifest:           prior.Manifest,
@@ -477,6 +688,12 @@ func (r resourceImage) ImportState(ctx context.Context, req resource.ImportState
 }
 
 func (r resourceImage) getDir(dir types.String) string {
+	if r.scratchDir != "" {
+		return r.scratchDir
+	}
+	if r.sourceDir != "" {
+		return filepath.Join(r.sourceDir, filepath.FromSlash(dir.ValueString()))
+	}
 	dirVal := dir.ValueString()
 	if dir.IsUnknown() || len(dirVal) == 0 {
 		dirVal = "."
@@ -485,6 +702,12 @@ func (r resourceImage) getDir(dir types.String) string {
 }
 
 func (r resourceImage) getFileParam(resourceState *resourceImageType) string {
+	if r.upgradedFile != "" {
+		return r.upgradedFile
+	}
+	if r.scratchDir != "" {
+		return inlineTemplateName(resourceState.TemplateContent.ValueString())
+	}
 	if resourceState.File.IsNull() || len(resourceState.File.ValueString()) == 0 {
 		return "."
 	} else {
@@ -523,20 +746,35 @@ func RunCommandInDirWithEnvReturnOutput(
 	return output, err
 }
 
-func (r resourceImage) packerInit(resourceState *resourceImageType, diags *diag.Diagnostics) error {
-	envVars := packer_interop.EnvVars(resourceState.Environment, !resourceState.IgnoreEnvironment.ValueBool())
-
-	params := []string{"init"}
-	params = append(params, r.getFileParam(resourceState))
-
-	exe := r.getPackerExecutable()
-	output, err := RunCommandInDirWithEnvReturnOutput(diags, exe, r.getDir(resourceState.Directory), envVars, params...)
-
+func (r resourceImage) packerInit(ctx context.Context, resourceState *resourceImageType, diags *diag.Diagnostics) error {
+	envVars, err := r.packerEnv(resourceState)
 	if err != nil {
-		return errors.Wrap(err, "could not run packer command ; output: "+string(output))
+		return err
 	}
 
-	return nil
+	exe, err := r.settings.packerExecutable(resourceState.Binary.ValueString())
+	if err != nil {
+		return err
+	}
+	mode := resourceState.InitMode.ValueString()
+	if mode == "" {
+		mode = initModeIfNeeded
+	}
+	dir := r.getDir(resourceState.Directory)
+	file := r.getFileParam(resourceState)
+	req := initRequest{Dir: dir, File: file, Mode: mode, Env: envVars}
+	return r.inits.ensure(ctx, req, func(upgrade bool) error {
+		params := []string{"init"}
+		if upgrade {
+			params = append(params, "-upgrade")
+		}
+		params = append(params, file)
+		output, err := RunCommandInDirWithEnvReturnOutput(diags, exe, dir, envVars, params...)
+		if err != nil {
+			return errors.Wrap(err, "could not run packer command ; output: "+string(output))
+		}
+		return nil
+	})
 }
 
 func (r resourceImage) getManifestPath(resourceState *resourceImageType) (path string, fromUser bool, err error) {
@@ -563,7 +801,10 @@ func (r resourceImage) getManifestPath(resourceState *resourceImageType) (path s
 }
 
 func (r resourceImage) packerBuild(resourceState *resourceImageType, diags *diag.Diagnostics, manifestPath string) error {
-	envVars := packer_interop.EnvVars(resourceState.Environment, !resourceState.IgnoreEnvironment.ValueBool())
+	envVars, err := r.packerEnv(resourceState)
+	if err != nil {
+		return err
+	}
 	if manifestPath != "" {
 		envVars[packer_interop.TPPManifestPath] = manifestPath
 	}
@@ -588,7 +829,10 @@ func (r resourceImage) packerBuild(resourceState *resourceImageType, diags *diag
 	params = append(params, resourceState.AdditionalParams...)
 	params = append(params, r.getFileParam(resourceState))
 
-	exe := r.getPackerExecutable()
+	exe, err := r.settings.packerExecutable(resourceState.Binary.ValueString())
+	if err != nil {
+		return err
+	}
 	output, err := RunCommandInDirWithEnvReturnOutput(diags, exe, r.getDir(resourceState.Directory), envVars, params...)
 	if err != nil {
 		return errors.Wrap(err, "could not run packer command; output: "+string(output))
@@ -597,14 +841,6 @@ func (r resourceImage) packerBuild(resourceState *resourceImageType, diags *diag
 	return nil
 }
 
-func (r resourceImage) getPackerExecutable() string {
-	if r.packerBinary != "" {
-		return r.packerBinary
-	}
-	exe, _ := os.Executable()
-	return exe
-}
-
 func createParametersFromVariables(variables *types.Dynamic) ([]string, error) {
 	var params []string
 	if !variables.IsNull() && !variables.IsUnknown() &&
@@ -641,6 +877,56 @@ func createParametersFromVariables(variables *types.Dynamic) ([]string, error) {
 	return params, nil
 }
 
+// buildInputsChanged reports whether any attribute that is passed to Packer
+// differs between the prior state and the plan. Attributes that are not
+// compared here (name, manifest_path) are metadata-only: changing them
+// updates state without running a build. Write-only sensitive_variables are
+// never persisted, so changes to them cannot be detected and do not count.
+// plugins and source_revision differ when ModifyPlan detected changed
+// plugins or a new revision of the source.
+func buildInputsChanged(plan *resourceImageType, state *resourceImageType) bool {
+	return !plan.Variables.Equal(state.Variables) ||
+		!sameStringSet(plan.AdditionalParams, state.AdditionalParams) ||
+		plan.Directory.ValueString() != state.Directory.ValueString() ||
+		!plan.File.Equal(state.File) ||
+		!plan.TemplateContent.Equal(state.TemplateContent) ||
+		!plan.Files.Equal(state.Files) ||
+		!plan.Source.Equal(state.Source) ||
+		!plan.SourceRevision.Equal(state.SourceRevision) ||
+		plan.UpgradeLegacyJSON.ValueBool() != state.UpgradeLegacyJSON.ValueBool() ||
+		!reflect.DeepEqual(nilIfEmpty(plan.Environment), nilIfEmpty(state.Environment)) ||
+		plan.IgnoreEnvironment.ValueBool() != state.IgnoreEnvironment.ValueBool() ||
+		!reflect.DeepEqual(nilIfEmpty(plan.Triggers), nilIfEmpty(state.Triggers)) ||
+		plan.Force.ValueBool() != state.Force.ValueBool() ||
+		!plan.Binary.Equal(state.Binary) ||
+		plan.IsolatePlugins.ValueBool() != state.IsolatePlugins.ValueBool() ||
+		!plan.Plugins.Equal(state.Plugins)
+}
+
+func sameStringSet(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := map[string]int{}
+	for _, v := range a {
+		counts[v]++
+	}
+	for _, v := range b {
+		counts[v]--
+		if counts[v] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func nilIfEmpty(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
 func (r resourceImage) updateState(resourceState *resourceImageType, _ *diag.Diagnostics) error {
 	if resourceState.ID.IsUnknown() {
 		resourceState.ID = types.StringValue(uuid.Must(uuid.NewRandom()).String())
@@ -651,14 +937,24 @@ func (r resourceImage) updateState(resourceState *resourceImageType, _ *diag.Dia
 }
 
 func (r resourceImage) detectPackerVersion(resourceState *resourceImageType, diags *diag.Diagnostics) {
-	exe := r.getPackerExecutable()
-	env := packer_interop.EnvVars(resourceState.Environment, !resourceState.IgnoreEnvironment.ValueBool())
-	output, err := RunCommandInDirWithEnvReturnOutput(diags, exe, r.getDir(resourceState.Directory), env, "version")
-	if err != nil || len(output) == 0 {
+	bin, err := r.settings.binaryFor(resourceState.Binary.ValueString())
+	if err != nil {
+		diags.AddAttributeError(path.Root("binary"), "Unknown Packer binary", err.Error())
+		return
+	}
+	// Memoized per binary, so planning many images runs Packer only once.
+	info, err := binaryInfos.get(bin)
+	if err != nil {
+		diags.AddWarning(
+			"Failed to run packer version",
+			fmt.Sprintf("Error: %v\nOutput:\n%s", err, strings.TrimSpace(string(info.Output))),
+		)
+		return
+	}
+	if len(info.Output) == 0 {
 		return
 	}
-	v := strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(string(output), "Packer")), "v")
-	resourceState.PackerVersion = types.StringValue(v)
+	resourceState.PackerVersion = types.StringValue(info.Version)
 }
 
 // readManifestFromPath reads and decodes the manifest JSON into a dynamic value.
@@ -761,8 +1057,41 @@ func (r resourceImage) Create(ctx context.Context, req resource.CreateRequest, r
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	r = r.withBinaries(ctx, &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	// The ID names the isolated plugin directory, so it is needed before
+	// the build.
+	if resourceState.ID.IsNull() || resourceState.ID.IsUnknown() {
+		resourceState.ID = types.StringValue(uuid.Must(uuid.NewRandom()).String())
+	}
+	release, err := r.builds.acquire(ctx, resourceState.ConcurrencyGroup.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to start packer build", err.Error())
+		return
+	}
+	defer release()
+	r, cleanup, err := r.withScratchDir(&resourceState)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to write template_content", err.Error())
+		return
+	}
+	defer cleanup()
+	r, releaseSource, err := r.withPlannedSource(ctx, &resourceState)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to fetch source", err.Error())
+		return
+	}
+	defer releaseSource()
+	r, cleanupUpgrade, err := r.withUpgradedTemplate(&resourceState, &resp.Diagnostics)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to upgrade the legacy JSON template", err.Error())
+		return
+	}
+	defer cleanupUpgrade()
 
-	err := r.packerInit(&resourceState, &resp.Diagnostics)
+	err = r.packerInit(ctx, &resourceState, &resp.Diagnostics)
 	if err != nil {
 		resp.Diagnostics.AddError("Failed to run packer init", err.Error())
 		return
@@ -793,6 +1122,7 @@ func (r resourceImage) Create(ctx context.Context, req resource.CreateRequest, r
 			return
 		}
 	}
+	r.recordPlugins(ctx, &resourceState, &resp.Diagnostics)
 	err = r.updateState(&resourceState, &resp.Diagnostics)
 	if err != nil {
 		resp.Diagnostics.AddError("Failed to run packer", err.Error())
@@ -847,7 +1177,47 @@ func (r resourceImage) Update(ctx context.Context, req resource.UpdateRequest, r
 	}
 	plan.SensitiveVariables = cfg.SensitiveVariables
 
-	err := r.packerInit(&plan, &resp.Diagnostics)
+	if !buildInputsChanged(&plan, &resourceState) {
+		// Only metadata changed; keep the results of the previous build.
+		plan.ID = resourceState.ID
+		plan.BuildUUID = resourceState.BuildUUID
+		plan.PackerVersion = resourceState.PackerVersion
+		plan.Manifest = resourceState.Manifest
+		plan.Plugins = resourceState.Plugins
+		plan.UpgradedTemplate = resourceState.UpgradedTemplate
+		resp.Diagnostics.Append(resp.State.Set(ctx, plan)...)
+		return
+	}
+	r = r.withBinaries(ctx, &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	release, err := r.builds.acquire(ctx, plan.ConcurrencyGroup.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to start packer build", err.Error())
+		return
+	}
+	defer release()
+	r, cleanup, err := r.withScratchDir(&plan)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to write template_content", err.Error())
+		return
+	}
+	defer cleanup()
+	r, releaseSource, err := r.withPlannedSource(ctx, &plan)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to fetch source", err.Error())
+		return
+	}
+	defer releaseSource()
+	r, cleanupUpgrade, err := r.withUpgradedTemplate(&plan, &resp.Diagnostics)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to upgrade the legacy JSON template", err.Error())
+		return
+	}
+	defer cleanupUpgrade()
+
+	err = r.packerInit(ctx, &plan, &resp.Diagnostics)
 	if err != nil {
 		resp.Diagnostics.AddError("Failed to run packer init", err.Error())
 		return
@@ -875,12 +1245,18 @@ func (r resourceImage) Update(ctx context.Context, req resource.UpdateRequest, r
 			return
 		}
 	}
+	r.recordPlugins(ctx, &plan, &resp.Diagnostics)
 	err = r.updateState(&plan, &resp.Diagnostics)
 	if err != nil {
 		resp.Diagnostics.AddError("Failed to run packer", err.Error())
 		return
 	}
 	r.detectPackerVersion(&plan, &resp.Diagnostics)
+	if resourceState.IsolatePlugins.ValueBool() && !plan.IsolatePlugins.ValueBool() {
+		if err := os.RemoveAll(r.isolatedPluginDirectory(resourceState.ID.ValueString())); err != nil {
+			resp.Diagnostics.AddWarning("Could not remove the isolated plugin directory", err.Error())
+		}
+	}
 
 	diags = resp.State.Set(ctx, plan)
 	resp.Diagnostics.Append(diags...)
@@ -896,6 +1272,11 @@ func (r resourceImage) Delete(ctx context.Context, req resource.DeleteRequest, r
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	if state.IsolatePlugins.ValueBool() && state.ID.ValueString() != "" {
+		if err := os.RemoveAll(r.isolatedPluginDirectory(state.ID.ValueString())); err != nil {
+			resp.Diagnostics.AddWarning("Could not remove the isolated plugin directory", err.Error())
+		}
+	}
 
 	resp.State.RemoveResource(ctx)
 }
@@ -926,6 +1307,78 @@ func (r resourceImage) ModifyPlan(ctx context.Context, req resource.ModifyPlanRe
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	var planned resourceImageType
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &planned)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	// build_uuid, manifest and plugins keep their prior values
+	// (UseStateForUnknown) unless this update is going to run Packer again.
+	rebuild := buildInputsChanged(&planned, &prior)
+	// An unchanged image plans without fetching its source, which may mean
+	// a download. Its Packer version is still checked below.
+	changed := !req.Plan.Raw.Equal(req.State.Raw)
+	if changed || rebuild {
+		var revision types.String
+		var releaseSource func()
+		var err error
+		r, revision, releaseSource, err = r.withSource(ctx, &planned)
+		if err != nil {
+			resp.Diagnostics.AddWarning(
+				"Could not fetch the template source",
+				fmt.Sprintf("A new revision of the source is not detected by this plan: %v", err),
+			)
+			if !planned.Source.Equal(prior.Source) {
+				planned.SourceRevision = types.StringUnknown()
+			}
+		} else {
+			defer releaseSource()
+			planned.SourceRevision = revision
+		}
+		resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("source_revision"), planned.SourceRevision)...)
+		rebuild = buildInputsChanged(&planned, &prior)
+	}
+	// A build with other plugins can produce a different image, like one
+	// with another Packer version.
+	if changes, err := r.detectPluginChanges(ctx, &planned, &prior); err != nil {
+		resp.Diagnostics.AddWarning(
+			"Could not check the Packer plugins",
+			fmt.Sprintf("A change of the plugins is not detected by this plan: %v", err),
+		)
+	} else if len(changes) > 0 {
+		if planned.OnPluginChange.ValueString() == pluginChangeWarn {
+			resp.Diagnostics.AddWarning(
+				"Packer plugins changed since the last build",
+				"The image was built with other plugins than are installed now; on_plugin_change = \"warn\" "+
+					"keeps it.\n"+strings.Join(changes, "\n"),
+			)
+		} else {
+			rebuild = true
+		}
+	}
+	if rebuild {
+		resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("build_uuid"), types.StringUnknown())...)
+		resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("manifest"), types.DynamicUnknown())...)
+		resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("plugins"), types.MapUnknown(imagePluginType))...)
+		resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("upgraded_template"), types.StringUnknown())...)
+	}
+	var resolveDiags diag.Diagnostics
+	r = r.withBinaries(ctx, &resolveDiags)
+	if resolveDiags.HasError() {
+		// The plan does not depend on the binary otherwise; do not fail it
+		// when, say, a download mirror is unreachable.
+		cause := resolveDiags.Errors()[0]
+		resp.Diagnostics.AddWarning(
+			"Could not check the Packer version",
+			fmt.Sprintf(
+				"The provider's Packer binary is unavailable, so a change of the Packer version is not detected "+
+					"by this plan. The error is reported again when the image is built.\n%s: %s",
+				cause.Summary(), cause.Detail(),
+			),
+		)
+		return
+	}
+	resp.Diagnostics.Append(resolveDiags...)
 	var detectDiags diag.Diagnostics
 	r.detectPackerVersion(&cfg, &detectDiags)
 	if detectDiags.HasError() {
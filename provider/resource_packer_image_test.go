@@ -0,0 +1,168 @@
+package provider
+
+import (
+	"context"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestBuildInputsChanged(t *testing.T) {
+	base := func() resourceImageType {
+		return resourceImageType{
+			Variables:        types.DynamicNull(),
+			AdditionalParams: []string{"-parallel-builds=1", "-on-error=abort"},
+			Directory:        types.StringValue("images"),
+			File:             types.StringNull(),
+			Environment:      map[string]string{"FOO": "bar"},
+			Triggers:         map[string]string{},
+			Name:             types.StringValue("old"),
+			ManifestPath:     types.StringValue("old.json"),
+			Plugins:          types.MapNull(imagePluginType),
+			Files:            types.MapNull(types.StringType),
+		}
+	}
+
+	metadataOnly := base()
+	metadataOnly.Name = types.StringValue("new")
+	metadataOnly.ManifestPath = types.StringValue("new.json")
+	metadataOnly.AdditionalParams = []string{"-on-error=abort", "-parallel-builds=1"}
+	metadataOnly.Triggers = nil
+	metadataOnly.ConcurrencyGroup = types.StringValue("esxi")
+	metadataOnly.InitMode = types.StringValue("never")
+	metadataOnly.OnPluginChange = types.StringValue("warn")
+	metadataOnly.Force = types.BoolValue(false)
+	metadataOnly.UpgradeLegacyJSON = types.BoolValue(false)
+	metadataOnly.IgnoreEnvironment = types.BoolValue(false)
+	metadataOnly.IsolatePlugins = types.BoolValue(false)
+	state := base()
+	if buildInputsChanged(&metadataOnly, &state) {
+		t.Error("changing only name, manifest_path, concurrency_group, init_mode, on_plugin_change, set order and " +
+			"force, upgrade_legacy_json, ignore_environment and isolate_plugins from null to false should not require a build")
+	}
+	withoutDirectory, emptyDirectory := base(), base()
+	withoutDirectory.Directory = types.StringNull()
+	emptyDirectory.Directory = types.StringValue("")
+	if buildInputsChanged(&emptyDirectory, &withoutDirectory) {
+		t.Error("changing directory from null to empty should not require a build")
+	}
+
+	for name, mutate := range map[string]func(*resourceImageType){
+		"directory":          func(r *resourceImageType) { r.Directory = types.StringValue("other") },
+		"file":               func(r *resourceImageType) { r.File = types.StringValue("x.pkr.hcl") },
+		"additional_params":  func(r *resourceImageType) { r.AdditionalParams = []string{"-debug"} },
+		"environment":        func(r *resourceImageType) { r.Environment = map[string]string{"FOO": "baz"} },
+		"triggers":           func(r *resourceImageType) { r.Triggers = map[string]string{"a": "b"} },
+		"force":              func(r *resourceImageType) { r.Force = types.BoolValue(true) },
+		"variables":          func(r *resourceImageType) { r.Variables = types.DynamicUnknown() },
+		"binary":             func(r *resourceImageType) { r.Binary = types.StringValue("packer-1.9") },
+		"isolate_plugins":    func(r *resourceImageType) { r.IsolatePlugins = types.BoolValue(true) },
+		"ignore_environment": func(r *resourceImageType) { r.IgnoreEnvironment = types.BoolValue(true) },
+		"plugins":            func(r *resourceImageType) { r.Plugins = types.MapUnknown(imagePluginType) },
+		"template_content":   func(r *resourceImageType) { r.TemplateContent = types.StringValue("build {}") },
+		"files": func(r *resourceImageType) {
+			r.Files = types.MapValueMust(types.StringType, map[string]attr.Value{"setup.sh": types.StringValue("true")})
+		},
+		"source":              func(r *resourceImageType) { r.Source = types.StringValue("git::/srv/templates.git") },
+		"source_revision":     func(r *resourceImageType) { r.SourceRevision = types.StringUnknown() },
+		"upgrade_legacy_json": func(r *resourceImageType) { r.UpgradeLegacyJSON = types.BoolValue(true) },
+	} {
+		plan := base()
+		mutate(&plan)
+		if !buildInputsChanged(&plan, &state) {
+			t.Errorf("changing %s should require a build", name)
+		}
+	}
+}
+
+func TestModifyPlanUnchangedImage(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake Packer binaries are shell scripts")
+	}
+	ctx := context.Background()
+	var current resource.SchemaResponse
+	resourceImage{}.Schema(ctx, resource.SchemaRequest{}, &current)
+	objectType := current.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	values := map[string]tftypes.Value{}
+	for name, typ := range objectType.AttributeTypes {
+		values[name] = tftypes.NewValue(typ, nil)
+	}
+	values["id"] = tftypes.NewValue(tftypes.String, "image")
+	values["name"] = tftypes.NewValue(tftypes.String, "ubuntu")
+	values["source"] = tftypes.NewValue(tftypes.String, filepath.Join(t.TempDir(), "missing"))
+	values["source_revision"] = tftypes.NewValue(tftypes.String, "abc123")
+	values["packer_version"] = tftypes.NewValue(tftypes.String, "1.10.0")
+	prior := tftypes.NewValue(objectType, values)
+
+	plan := func(packer string, raw tftypes.Value) *resource.ModifyPlanResponse {
+		bin := filepath.Join(fakePacker(t, packer), "packer")
+		r := resourceImage{
+			binaries: newBinaryResolver(func(context.Context, *diag.Diagnostics) providerSettings {
+				return providerSettings{PackerBinary: bin}
+			}),
+			sources: newSourceFetcher(t.TempDir()),
+		}
+		resp := &resource.ModifyPlanResponse{Plan: tfsdk.Plan{Schema: current.Schema, Raw: raw}}
+		r.ModifyPlan(ctx, resource.ModifyPlanRequest{
+			Config: tfsdk.Config{Schema: current.Schema, Raw: raw},
+			State:  tfsdk.State{Schema: current.Schema, Raw: prior},
+			Plan:   tfsdk.Plan{Schema: current.Schema, Raw: raw},
+		}, resp)
+		return resp
+	}
+
+	if resp := plan("1.10.0", prior); len(resp.Diagnostics) > 0 || len(resp.RequiresReplace) > 0 {
+		t.Errorf("unchanged plan: replace %v, diagnostics %v", resp.RequiresReplace, resp.Diagnostics)
+	}
+	if resp := plan("1.11.0", prior); len(resp.RequiresReplace) != 1 || !resp.RequiresReplace[0].Equal(path.Root("packer_version")) {
+		t.Errorf("a new Packer version should replace an unchanged image, replace %v, diagnostics %v", resp.RequiresReplace, resp.Diagnostics)
+	}
+
+	changed := map[string]tftypes.Value{}
+	for name, v := range values {
+		changed[name] = v
+	}
+	changed["name"] = tftypes.NewValue(tftypes.String, "noble")
+	resp := plan("1.10.0", tftypes.NewValue(objectType, changed))
+	fetched := false
+	for _, d := range resp.Diagnostics.Warnings() {
+		fetched = fetched || d.Summary() == "Could not fetch the template source"
+	}
+	if !fetched {
+		t.Errorf("a changed image should fetch its source, got %v", resp.Diagnostics)
+	}
+}
+
+func TestUpgradeState(t *testing.T) {
+	ctx := context.Background()
+	var current resource.SchemaResponse
+	resourceImage{}.Schema(ctx, resource.SchemaRequest{}, &current)
+	currentType := current.Schema.Type().TerraformType(ctx)
+
+	for version, upgrader := range (resourceImage{}).UpgradeState(ctx) {
+		priorType := upgrader.PriorSchema.Type().TerraformType(ctx).(tftypes.Object)
+		values := map[string]tftypes.Value{}
+		for name, typ := range priorType.AttributeTypes {
+			values[name] = tftypes.NewValue(typ, nil)
+		}
+		values["id"] = tftypes.NewValue(tftypes.String, "image")
+		req := resource.UpgradeStateRequest{
+			State: &tfsdk.State{Schema: *upgrader.PriorSchema, Raw: tftypes.NewValue(priorType, values)},
+		}
+		resp := resource.UpgradeStateResponse{
+			State: tfsdk.State{Schema: current.Schema, Raw: tftypes.NewValue(currentType, nil)},
+		}
+		upgrader.StateUpgrader(ctx, req, &resp)
+		if resp.Diagnostics.HasError() {
+			t.Errorf("upgrading version %d: %v", version, resp.Diagnostics)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"terraform-provider-packer/packer_interop"
+
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+
+	"github.com/google/uuid"
+)
+
+type resourcePluginType struct {
+	ID              types.String `tfsdk:"id"`
+	Source          types.String `tfsdk:"source"`
+	Path            types.String `tfsdk:"path"`
+	Checksum        types.String `tfsdk:"checksum"`
+	PluginDirectory types.String `tfsdk:"plugin_directory"`
+	Version         types.String `tfsdk:"version"`
+	InstalledPath   types.String `tfsdk:"installed_path"`
+}
+
+type resourcePlugin struct {
+	p tfProvider
+	// downloads holds the download cache and lock timeout of the provider's
+	// configuration.
+	downloads downloadOptions
+}
+
+func (r resourcePlugin) Metadata(_ context.Context, _ resource.MetadataRequest, resp *resource.MetadataResponse) {
+	*resp = resource.MetadataResponse{
+		TypeName: "packer_plugin",
+	}
+}
+
+func (r resourcePlugin) Schema(_ context.Context, _ resource.SchemaRequest, response *resource.SchemaResponse) {
+	replace := []planmodifier.String{stringplanmodifier.RequiresReplace()}
+	*response = resource.SchemaResponse{
+		Schema: schema.Schema{
+			Description: "Installs a Packer plugin from a local file or go-getter source, without access to the " +
+				"plugin's releases, like `packer plugins install --path`. `packer_image` resources that depend on it " +
+				"find the plugin installed. It is removed on destroy unless another `packer_plugin` resource installed " +
+				"the same version or it was installed before, e.g. by `packer init`.",
+			Attributes: map[string]schema.Attribute{
+				"id": schema.StringAttribute{
+					Computed: true,
+					PlanModifiers: []planmodifier.String{
+						stringplanmodifier.UseStateForUnknown(),
+					},
+				},
+				"source": schema.StringAttribute{
+					Description: "Source address of the plugin as used in `required_plugins`, e.g. " +
+						"`github.com/hashicorp/docker`. Changing this forces replacement.",
+					Required:      true,
+					PlanModifiers: replace,
+				},
+				"path": schema.StringAttribute{
+					Description: "Plugin binary to install: a local path or a go-getter source such as `file::`, " +
+						"`s3::` or an http(s) URL. A source that yields a directory must contain exactly one " +
+						"`packer-plugin-*` file. Changing this forces replacement.",
+					Required:      true,
+					PlanModifiers: replace,
+				},
+				"checksum": schema.StringAttribute{
+					Description:   "SHA-256 checksum the plugin binary must match. Changing this forces replacement.",
+					Required:      true,
+					PlanModifiers: replace,
+				},
+				"plugin_directory": schema.StringAttribute{
+					Description: "Plugin directory to install into. Defaults to the provider's `plugin_directory`, " +
+						"else Packer's own plugin directory. Changing this forces replacement.",
+					Optional: true,
+					Computed: true,
+					PlanModifiers: []planmodifier.String{
+						stringplanmodifier.UseStateForUnknown(),
+						stringplanmodifier.RequiresReplace(),
+					},
+				},
+				"version": schema.StringAttribute{
+					Description: "Version of the installed plugin, as the plugin describes itself.",
+					Computed:    true,
+				},
+				"installed_path": schema.StringAttribute{
+					Description: "Path of the installed plugin binary.",
+					Computed:    true,
+				},
+			},
+		},
+	}
+}
+
+func (r *resourcePlugin) Configure(_ context.Context, req resource.ConfigureRequest, _ *resource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	if data, ok := req.ProviderData.(*providerData); ok {
+		r.downloads = downloadOptions{CacheDir: data.cacheDir, LockTimeout: data.lockTimeout}
+	}
+}
+
+// pluginDirectory returns the plugin directory resourceState installs into.
+func (r resourcePlugin) pluginDirectory(resourceState *resourcePluginType) (string, error) {
+	if dir := knownStringValue(resourceState.PluginDirectory); dir != "" {
+		return dir, nil
+	}
+	dirs, err := packerPluginDirs(packer_interop.EnvVars(map[string]string{}, true))
+	if err != nil {
+		return "", err
+	}
+	return dirs[0], nil
+}
+
+func (r resourcePlugin) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var resourceState resourcePluginType
+	diags := req.Plan.Get(ctx, &resourceState)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	dir, err := r.pluginDirectory(&resourceState)
+	if err != nil {
+		resp.Diagnostics.AddError("Could not determine the Packer plugin directory", err.Error())
+		return
+	}
+	id := uuid.Must(uuid.NewRandom()).String()
+	installed, err := installLocalPlugin(ctx, resourceState.Path.ValueString(), resourceState.Checksum.ValueString(),
+		resourceState.Source.ValueString(), dir, id, r.downloads)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to install Packer plugin", err.Error())
+		return
+	}
+	resourceState.ID = types.StringValue(id)
+	resourceState.PluginDirectory = types.StringValue(dir)
+	resourceState.Version = types.StringValue(installed.Version)
+	resourceState.InstalledPath = types.StringValue(installed.Path)
+
+	diags = resp.State.Set(ctx, &resourceState)
+	resp.Diagnostics.Append(diags...)
+}
+
+func (r resourcePlugin) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var resourceState resourcePluginType
+	diags := req.State.Get(ctx, &resourceState)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// A plugin removed outside of Terraform is installed again.
+	if _, err := os.Stat(resourceState.InstalledPath.ValueString()); os.IsNotExist(err) {
+		resp.State.RemoveResource(ctx)
+		return
+	}
+
+	diags = resp.State.Set(ctx, &resourceState)
+	resp.Diagnostics.Append(diags...)
+}
+
+func (r resourcePlugin) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	// Every configurable attribute forces replacement.
+	var plan resourcePluginType
+	diags := req.Plan.Get(ctx, &plan)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+func (r resourcePlugin) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	var state resourcePluginType
+	diags := req.State.Get(ctx, &state)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	removed, err := removePlugin(ctx, state.InstalledPath.ValueString(), state.PluginDirectory.ValueString(), state.ID.ValueString(), r.downloads)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to remove Packer plugin", err.Error())
+		return
+	}
+	if !removed {
+		resp.Diagnostics.AddWarning(
+			"Packer plugin kept",
+			fmt.Sprintf(
+				"%s is also installed by another packer_plugin resource or was installed outside of Terraform, "+
+					"e.g. by packer init, so it is not removed.",
+				state.InstalledPath.ValueString(),
+			),
+		)
+	}
+
+	resp.State.RemoveResource(ctx)
+}
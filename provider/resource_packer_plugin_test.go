@@ -0,0 +1,181 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+// pluginResourceState creates a packer_plugin resource installing bin into
+// pluginDir and returns its state.
+func pluginResourceState(t *testing.T, bin string, digest string, pluginDir string) tfsdk.State {
+	t.Helper()
+	ctx := context.Background()
+	var current resource.SchemaResponse
+	resourcePlugin{}.Schema(ctx, resource.SchemaRequest{}, &current)
+	objectType := current.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	values := map[string]tftypes.Value{}
+	for name, typ := range objectType.AttributeTypes {
+		values[name] = tftypes.NewValue(typ, tftypes.UnknownValue)
+	}
+	values["source"] = tftypes.NewValue(tftypes.String, "github.com/hashicorp/docker")
+	values["path"] = tftypes.NewValue(tftypes.String, bin)
+	values["checksum"] = tftypes.NewValue(tftypes.String, digest)
+	values["plugin_directory"] = tftypes.NewValue(tftypes.String, pluginDir)
+
+	resp := &resource.CreateResponse{State: tfsdk.State{Schema: current.Schema, Raw: tftypes.NewValue(objectType, nil)}}
+	resourcePlugin{}.Create(ctx, resource.CreateRequest{
+		Plan: tfsdk.Plan{Schema: current.Schema, Raw: tftypes.NewValue(objectType, values)},
+	}, resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatalf("create: %v", resp.Diagnostics)
+	}
+	return resp.State
+}
+
+func readPluginResource(t *testing.T, state tfsdk.State) tfsdk.State {
+	t.Helper()
+	resp := &resource.ReadResponse{State: state}
+	resourcePlugin{}.Read(context.Background(), resource.ReadRequest{State: state}, resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatalf("read: %v", resp.Diagnostics)
+	}
+	return resp.State
+}
+
+func deletePluginResource(t *testing.T, state tfsdk.State) *resource.DeleteResponse {
+	t.Helper()
+	resp := &resource.DeleteResponse{State: state}
+	resourcePlugin{}.Delete(context.Background(), resource.DeleteRequest{State: state}, resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatalf("delete: %v", resp.Diagnostics)
+	}
+	return resp
+}
+
+func installedPluginPath(t *testing.T, state tfsdk.State) string {
+	t.Helper()
+	var plugin resourcePluginType
+	if diags := state.Get(context.Background(), &plugin); diags.HasError() {
+		t.Fatal(diags)
+	}
+	return plugin.InstalledPath.ValueString()
+}
+
+func TestResourcePluginLifecycle(t *testing.T) {
+	useTempCacheDir(t)
+	usePlatform(t, "linux", "amd64")
+	bin, digest := fakePlugin(t, "1.0.9")
+	pluginDir := t.TempDir()
+
+	state := pluginResourceState(t, bin, digest, pluginDir)
+	var plugin resourcePluginType
+	if diags := state.Get(context.Background(), &plugin); diags.HasError() {
+		t.Fatal(diags)
+	}
+	if plugin.ID.ValueString() == "" || plugin.Version.ValueString() != "1.0.9" || plugin.PluginDirectory.ValueString() != pluginDir {
+		t.Errorf("created %+v", plugin)
+	}
+	installed := plugin.InstalledPath.ValueString()
+	if _, err := os.Stat(installed); err != nil {
+		t.Fatalf("plugin should be installed: %v", err)
+	}
+
+	if read := readPluginResource(t, state); read.Raw.IsNull() || !read.Raw.Equal(state.Raw) {
+		t.Errorf("an installed plugin should be kept in state, got %v", read.Raw)
+	}
+
+	resp := deletePluginResource(t, state)
+	if !resp.State.Raw.IsNull() || len(resp.Diagnostics) > 0 {
+		t.Errorf("delete left state %v, diagnostics %v", resp.State.Raw, resp.Diagnostics)
+	}
+	if _, err := os.Stat(installed); !os.IsNotExist(err) {
+		t.Errorf("plugin should be removed, stat: %v", err)
+	}
+}
+
+func TestResourcePluginReadDetectsRemovedPlugin(t *testing.T) {
+	useTempCacheDir(t)
+	usePlatform(t, "linux", "amd64")
+	bin, digest := fakePlugin(t, "1.0.9")
+
+	state := pluginResourceState(t, bin, digest, t.TempDir())
+	if err := os.Remove(installedPluginPath(t, state)); err != nil {
+		t.Fatal(err)
+	}
+	if read := readPluginResource(t, state); !read.Raw.IsNull() {
+		t.Errorf("a removed plugin should be removed from state, got %v", read.Raw)
+	}
+}
+
+func TestResourcePluginDeleteKeepsSharedPlugin(t *testing.T) {
+	useTempCacheDir(t)
+	usePlatform(t, "linux", "amd64")
+	bin, digest := fakePlugin(t, "1.0.9")
+	pluginDir := t.TempDir()
+
+	first := pluginResourceState(t, bin, digest, pluginDir)
+	second := pluginResourceState(t, bin, digest, pluginDir)
+	installed := installedPluginPath(t, first)
+
+	if resp := deletePluginResource(t, first); len(resp.Diagnostics.Warnings()) != 1 {
+		t.Errorf("deleting a shared plugin should warn that it is kept, got %v", resp.Diagnostics)
+	}
+	if _, err := os.Stat(installed); err != nil {
+		t.Fatalf("plugin used by another resource should be kept: %v", err)
+	}
+	deletePluginResource(t, second)
+	if _, err := os.Stat(installed); !os.IsNotExist(err) {
+		t.Errorf("plugin should be removed with its last resource, stat: %v", err)
+	}
+}
+
+func TestResourcePluginDeleteKeepsPluginInstalledByInit(t *testing.T) {
+	useTempCacheDir(t)
+	usePlatform(t, "linux", "amd64")
+	bin, digest := fakePlugin(t, "1.0.9")
+	pluginDir := t.TempDir()
+
+	// As installed by packer init before the resource is created.
+	installed := filepath.Join(pluginDir, "github.com", "hashicorp", "docker", "packer-plugin-docker_v1.0.9_x5.0_linux_amd64")
+	if err := os.MkdirAll(filepath.Dir(installed), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyExecutable(bin, installed); err != nil {
+		t.Fatal(err)
+	}
+
+	state := pluginResourceState(t, bin, digest, pluginDir)
+	if got := installedPluginPath(t, state); got != installed {
+		t.Fatalf("installed %s, want %s", got, installed)
+	}
+	deletePluginResource(t, state)
+	if _, err := os.Stat(installed); err != nil {
+		t.Errorf("plugin installed by packer init should be kept: %v", err)
+	}
+}
+
+func TestResourcePluginFollowsProviderCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	config := providerConfig(t, map[string]tftypes.Value{
+		"packer_binary_cache_dir":          tftypes.NewValue(tftypes.String, cacheDir),
+		"packer_binary_cache_lock_timeout": tftypes.NewValue(tftypes.String, "2m"),
+	})
+	var resp provider.ConfigureResponse
+	(&tfProvider{}).Configure(context.Background(), provider.ConfigureRequest{Config: config}, &resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+	var r resourcePlugin
+	r.Configure(context.Background(), resource.ConfigureRequest{ProviderData: resp.ResourceData}, &resource.ConfigureResponse{})
+	if r.downloads.cacheBaseDir() != cacheDir || r.downloads.lockTimeout() != 2*time.Minute {
+		t.Errorf("packer_plugin uses cache %s and lock timeout %s, want the provider's", r.downloads.cacheBaseDir(), r.downloads.lockTimeout())
+	}
+}
@@ -0,0 +1,346 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"terraform-provider-packer/crypto_util"
+
+	getter "github.com/hashicorp/go-getter/v2"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	tfpath "github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// templateSourceRetention is how long a fetched revision of a source that
+// is no longer the latest one stays in the cache, so that a build that has
+// just fetched it does not lose it.
+var templateSourceRetention = time.Hour
+
+// fetchedSource is a checkout of the source of a packer_image.
+type fetchedSource struct {
+	// Dir is the fetched directory, or its subdirectory given with //.
+	Dir string
+	// Root is the cache entry holding the checkout.
+	Root string
+	// Revision is the commit of a git source, else the digest of the
+	// fetched files.
+	Revision string
+}
+
+type sourceFetch struct {
+	mu      sync.Mutex
+	done    bool
+	fetched fetchedSource
+}
+
+// sourceFetcher fetches each source at most once in the provider process,
+// so that a plan or apply of many images from the same repository fetches
+// it once, and all of them see the same revision.
+type sourceFetcher struct {
+	// cacheDir holds the fetched sources; see templateSourceCacheDirectory.
+	cacheDir string
+	mu       sync.Mutex
+	fetches  map[string]*sourceFetch
+}
+
+// newSourceFetcher returns a sourceFetcher caching sources inside
+// downloadCache, the provider's downloadOptions.cacheBaseDir.
+func newSourceFetcher(downloadCache string) *sourceFetcher {
+	return &sourceFetcher{cacheDir: templateSourceCacheDirectory(downloadCache), fetches: map[string]*sourceFetch{}}
+}
+
+// get returns the checkout of source. Failed fetches are attempted again by
+// the next caller.
+func (f *sourceFetcher) get(ctx context.Context, source string) (fetchedSource, error) {
+	if f == nil {
+		return fetchTemplateSource(ctx, templateSourceCacheDirectory(downloadOptions{}.cacheBaseDir()), source)
+	}
+	f.mu.Lock()
+	fetch, ok := f.fetches[source]
+	if !ok {
+		fetch = &sourceFetch{}
+		f.fetches[source] = fetch
+	}
+	f.mu.Unlock()
+
+	fetch.mu.Lock()
+	defer fetch.mu.Unlock()
+	if fetch.done {
+		return fetch.fetched, nil
+	}
+	fetched, err := fetchTemplateSource(ctx, f.cacheDir, source)
+	if err != nil {
+		return fetchedSource{}, err
+	}
+	fetch.fetched, fetch.done = fetched, true
+	return fetched, nil
+}
+
+// templateSourceCacheDirectory holds the fetched sources inside
+// downloadCache.
+func templateSourceCacheDirectory(downloadCache string) string {
+	return filepath.Join(downloadCache, templateSourcesDirName)
+}
+
+// fetchTemplateSource fetches source, a go-getter address with an optional
+// //subdirectory, into cacheDir. Every revision of a source gets an entry
+// of its own that is never modified, so that builds can run in it while
+// another plan fetches a newer revision; older revisions are pruned.
+func fetchTemplateSource(ctx context.Context, cacheDir string, source string) (fetchedSource, error) {
+	root, subdir := getter.SourceDirSubdir(source)
+	if subdir != "" {
+		if err := validateSourceDirectory(subdir); err != nil {
+			return fetchedSource{}, err
+		}
+	}
+	sourceDir := filepath.Join(cacheDir, sourceCacheKey(root))
+	if err := os.MkdirAll(sourceDir, 0o755); err != nil {
+		return fetchedSource{}, fmt.Errorf("could not create the source cache: %v", err)
+	}
+
+	// Archives such as templates.tar.gz are unpacked.
+	client := &getter.Client{DisableSymlinks: true}
+	_, staging, err := fetchWithClient(ctx, client, root, sourceDir)
+	if err != nil {
+		return fetchedSource{}, err
+	}
+	defer func() { _ = os.RemoveAll(staging) }()
+	// A single file is fetched into the directory as well.
+	fetched := filepath.Join(staging, "src")
+
+	var revision string
+	if isGitSource(root) {
+		revision, err = gitRevision(ctx, fetched)
+	} else {
+		revision, err = treeDigest(fetched)
+	}
+	if err != nil {
+		return fetchedSource{}, err
+	}
+
+	entry := filepath.Join(sourceDir, sourceEntryName(revision))
+	lock, err := lockDir(ctx, entry, defaultCacheLockTimeout)
+	if err != nil {
+		return fetchedSource{}, err
+	}
+	if _, err := os.Stat(entry); os.IsNotExist(err) {
+		err = os.Rename(fetched, entry)
+		if err != nil {
+			_ = lock.Unlock()
+			return fetchedSource{}, fmt.Errorf("could not store %s in the source cache: %v", source, err)
+		}
+	}
+	_ = lock.Unlock()
+	markCacheEntryUsed(entry)
+	pruneTemplateSources(sourceDir, entry)
+
+	dir := entry
+	if subdir != "" {
+		dir = filepath.Join(entry, filepath.FromSlash(path.Clean(filepath.ToSlash(subdir))))
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return fetchedSource{}, fmt.Errorf("%s has no subdirectory %q", root, subdir)
+		}
+	}
+	return fetchedSource{Dir: dir, Root: entry, Revision: revision}, nil
+}
+
+// sourceCacheKey names the cache directory of a source.
+func sourceCacheKey(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
+
+// sourceEntryName names the cache entry of a revision.
+func sourceEntryName(revision string) string {
+	if digest, ok := strings.CutPrefix(revision, "sha256:"); ok {
+		return "sha256-" + digest
+	}
+	return "git-" + revision
+}
+
+// isGitSource reports whether go-getter fetches source with git, e.g.
+// git::https://example.com/templates.git or github.com/org/templates.
+func isGitSource(source string) bool {
+	pwd, err := os.Getwd()
+	if err != nil {
+		return false
+	}
+	git := &getter.GitGetter{Detectors: []getter.Detector{
+		new(getter.GitHubDetector),
+		new(getter.GitDetector),
+		new(getter.BitBucketDetector),
+		new(getter.GitLabDetector),
+	}}
+	ok, err := getter.Detect(&getter.Request{Src: source, Pwd: pwd}, git)
+	return err == nil && ok
+}
+
+// gitRevision returns the commit checked out in dir.
+func gitRevision(ctx context.Context, dir string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("could not determine the fetched commit: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// treeDigest returns the SHA-256 digest of the files under root: their
+// paths, whether they are executable and their content. VCS metadata is
+// ignored.
+func treeDigest(root string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" || d.Name() == ".hg" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		digest, err := crypto_util.FileSHA256(p)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(h, "%s\x00%t\x00%s\n", filepath.ToSlash(rel), info.Mode()&0o111 != 0, digest)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not hash the fetched files: %v", err)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// pruneTemplateSources removes the revisions in sourceDir other than keep,
+// and their lock files, that were not used for templateSourceRetention.
+// Revisions a build runs in are skipped; see lockTemplateSource.
+func pruneTemplateSources(sourceDir string, keep string) {
+	entries, err := os.ReadDir(sourceDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		entry := filepath.Join(sourceDir, e.Name())
+		if !e.IsDir() || entry == keep ||
+			!(strings.HasPrefix(e.Name(), "git-") || strings.HasPrefix(e.Name(), "sha256-")) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < templateSourceRetention {
+			continue
+		}
+		lockPath := cacheLockPath(entry)
+		lock, locked, err := tryLockFile(lockPath, false)
+		if err != nil || !locked {
+			continue
+		}
+		_ = os.RemoveAll(entry)
+		_ = os.Remove(lockPath)
+		_ = lock.Unlock()
+	}
+}
+
+// lockTemplateSource takes a shared lock on the cache entry of fetched,
+// which keeps it from being pruned while a build runs in it.
+func lockTemplateSource(ctx context.Context, fetched fetchedSource) (func(), error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultCacheLockTimeout)
+	defer cancel()
+	lock, err := lockFileContext(ctx, cacheLockPath(fetched.Root), true, cacheLockRetryDelay)
+	if err != nil {
+		return nil, fmt.Errorf("could not lock %q: %v", fetched.Root, err)
+	}
+	if _, err := os.Stat(fetched.Root); err != nil {
+		_ = lock.Unlock()
+		return nil, fmt.Errorf("the fetched source %q was removed: %v", fetched.Root, err)
+	}
+	return func() { _ = lock.Unlock() }, nil
+}
+
+// validateSourceDirectory checks that dir, the directory of a packer_image
+// with a source or the subdirectory of the source, stays inside the
+// fetched directory.
+func validateSourceDirectory(dir string) error {
+	slashed := filepath.ToSlash(dir)
+	if path.IsAbs(slashed) || filepath.IsAbs(dir) || filepath.VolumeName(dir) != "" {
+		return fmt.Errorf("%q must be a relative path inside the source", dir)
+	}
+	if cleaned := path.Clean(slashed); cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return fmt.Errorf("%q must stay inside the source", dir)
+	}
+	return nil
+}
+
+// validateTemplateSource checks that the directory of a packer_image with a
+// source is inside the source.
+func validateTemplateSource(cfg *resourceImageType, diags *diag.Diagnostics) {
+	if cfg.Source.IsNull() || cfg.Directory.IsNull() || cfg.Directory.IsUnknown() {
+		return
+	}
+	if err := validateSourceDirectory(cfg.Directory.ValueString()); err != nil {
+		diags.AddAttributeError(tfpath.Root("directory"), "Invalid directory", err.Error())
+	}
+}
+
+// withSource returns a copy of r that runs Packer in the checkout of the
+// source of resourceState, if it has one, along with the revision of the
+// checkout and the function that releases it. The revision is null without
+// a source and unknown while the source is.
+func (r resourceImage) withSource(ctx context.Context, resourceState *resourceImageType) (resourceImage, types.String, func(), error) {
+	if resourceState.Source.IsNull() {
+		return r, types.StringNull(), func() {}, nil
+	}
+	if resourceState.Source.IsUnknown() {
+		return r, types.StringUnknown(), func() {}, nil
+	}
+	fetched, err := r.sources.get(ctx, resourceState.Source.ValueString())
+	if err != nil {
+		return r, types.StringNull(), nil, err
+	}
+	release, err := lockTemplateSource(ctx, fetched)
+	if err != nil {
+		return r, types.StringNull(), nil, err
+	}
+	r.sourceDir = fetched.Dir
+	return r, types.StringValue(fetched.Revision), release, nil
+}
+
+// withPlannedSource is withSource for a build: the fetched revision must be
+// the one in the plan, if it was known, and is stored in resourceState.
+func (r resourceImage) withPlannedSource(ctx context.Context, resourceState *resourceImageType) (resourceImage, func(), error) {
+	r, revision, release, err := r.withSource(ctx, resourceState)
+	if err != nil {
+		return r, nil, err
+	}
+	planned := resourceState.SourceRevision
+	if !planned.IsNull() && !planned.IsUnknown() && !planned.Equal(revision) {
+		release()
+		return r, nil, fmt.Errorf(
+			"%s is at revision %s, but the plan was made for %s; run terraform plan again",
+			resourceState.Source.ValueString(), revision.ValueString(), planned.ValueString(),
+		)
+	}
+	resourceState.SourceRevision = revision
+	return r, release, nil
+}
@@ -0,0 +1,226 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestFetchTemplateSourceFromDirectory(t *testing.T) {
+	previous := templateSourceRetention
+	templateSourceRetention = 0
+	t.Cleanup(func() { templateSourceRetention = previous })
+
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "ubuntu"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "ubuntu", "build.pkr.hcl"), []byte("build {}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	downloadCache := t.TempDir()
+	cacheDir := filepath.Join(downloadCache, "template-sources")
+	fetcher := newSourceFetcher(downloadCache)
+	first, err := fetcher.get(context.Background(), src+"//ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(first.Revision, "sha256:") {
+		t.Errorf("revision of a directory = %s, want a digest", first.Revision)
+	}
+	if got, err := os.ReadFile(filepath.Join(first.Dir, "build.pkr.hcl")); err != nil || string(got) != "build {}" {
+		t.Errorf("fetched template = %q (%v)", got, err)
+	}
+	if !strings.HasPrefix(first.Root, cacheDir+string(filepath.Separator)) {
+		t.Errorf("source cached in %s, want it in %s", first.Root, cacheDir)
+	}
+	if entries, err := listCacheEntries(downloadCache); err != nil || len(entries) != 0 {
+		t.Errorf("template sources should not be download cache entries, got %v (%v)", entries, err)
+	}
+	if info, err := os.Lstat(first.Root); err != nil || !info.IsDir() {
+		t.Errorf("the cache should hold a copy of a local directory, got %v (%v)", info, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(src, "ubuntu", "build.pkr.hcl"), []byte("build { name = \"x\" }"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := fetcher.get(context.Background(), src+"//ubuntu"); err != nil || again != first {
+		t.Errorf("a source should be fetched once per process, got %+v (%v)", again, err)
+	}
+	second, err := fetchTemplateSource(context.Background(), cacheDir, src+"//ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Revision == first.Revision || second.Root == first.Root {
+		t.Errorf("changed files should give a new revision, got %s twice", second.Revision)
+	}
+	if _, err := os.Stat(first.Root); !os.IsNotExist(err) {
+		t.Errorf("the previous revision should be pruned, stat: %v", err)
+	}
+	if _, err := os.Stat(cacheLockPath(first.Root)); !os.IsNotExist(err) {
+		t.Errorf("the lock of the pruned revision should be removed, stat: %v", err)
+	}
+
+	if _, err := fetchTemplateSource(context.Background(), cacheDir, src+"//missing"); err == nil {
+		t.Error("a missing subdirectory should be rejected")
+	}
+}
+
+func TestFetchTemplateSourceFromFile(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "build.pkr.hcl")
+	if err := os.WriteFile(src, []byte("build {}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fetched, err := fetchTemplateSource(context.Background(), t.TempDir(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(fetched.Dir, "build.pkr.hcl")); err != nil || string(got) != "build {}" {
+		t.Errorf("a single file should be fetched into the directory, got %q (%v)", got, err)
+	}
+}
+
+func TestFetchTemplateSourceKeepsLockedRevision(t *testing.T) {
+	previous := templateSourceRetention
+	templateSourceRetention = 0
+	t.Cleanup(func() { templateSourceRetention = previous })
+
+	cacheDir := t.TempDir()
+	src := t.TempDir()
+	template := filepath.Join(src, "build.pkr.hcl")
+	if err := os.WriteFile(template, []byte("build {}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	first, err := fetchTemplateSource(context.Background(), cacheDir, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release, err := lockTemplateSource(context.Background(), first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	if err := os.WriteFile(template, []byte("build { name = \"x\" }"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fetchTemplateSource(context.Background(), cacheDir, src); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(first.Root); err != nil {
+		t.Errorf("a revision a build runs in should be kept: %v", err)
+	}
+}
+
+func TestFetchTemplateSourceFromGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	cacheDir := t.TempDir()
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(content string) string {
+		if err := os.WriteFile(filepath.Join(repo, "build.pkr.hcl"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", ".")
+		git("commit", "-q", "-m", "update template")
+		return git("rev-parse", "HEAD")
+	}
+	git("init", "-q")
+	v1 := commit("build {}")
+	git("tag", "v1.0.0")
+	v2 := commit("build { name = \"x\" }")
+
+	source := "git::file://" + filepath.ToSlash(repo)
+	fetched, err := fetchTemplateSource(context.Background(), cacheDir, source+"?ref=v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched.Revision != v1 {
+		t.Errorf("revision = %s, want the tagged commit %s", fetched.Revision, v1)
+	}
+	if got, _ := os.ReadFile(filepath.Join(fetched.Dir, "build.pkr.hcl")); string(got) != "build {}" {
+		t.Errorf("fetched template = %q, want the tagged one", got)
+	}
+	if fetched, err = fetchTemplateSource(context.Background(), cacheDir, source); err != nil || fetched.Revision != v2 {
+		t.Errorf("revision = %s (%v), want the latest commit %s", fetched.Revision, err, v2)
+	}
+}
+
+func TestIsGitSource(t *testing.T) {
+	for source, want := range map[string]bool{
+		"git::https://example.com/templates.git?ref=v1.2.0": true,
+		"git::/srv/templates.git":                           true,
+		"github.com/example/templates":                      true,
+		"https://example.com/templates.tar.gz":              false,
+		"./templates":                                       false,
+	} {
+		if got := isGitSource(source); got != want {
+			t.Errorf("isGitSource(%q) = %v, want %v", source, got, want)
+		}
+	}
+}
+
+func TestValidateTemplateSource(t *testing.T) {
+	source := types.StringValue("git::https://example.com/templates.git")
+	for name, c := range map[string]struct {
+		cfg   resourceImageType
+		valid bool
+	}{
+		"subdirectory":     {resourceImageType{Source: source, Directory: types.StringValue("ubuntu")}, true},
+		"current":          {resourceImageType{Source: source, Directory: types.StringValue(".")}, true},
+		"absolute":         {resourceImageType{Source: source, Directory: types.StringValue("/srv/templates")}, false},
+		"outside":          {resourceImageType{Source: source, Directory: types.StringValue("../templates")}, false},
+		"without a source": {resourceImageType{Source: types.StringNull(), Directory: types.StringValue("/srv/templates")}, true},
+	} {
+		var diags diag.Diagnostics
+		validateTemplateSource(&c.cfg, &diags)
+		if diags.HasError() == c.valid {
+			t.Errorf("%s: valid = %v, diagnostics %v", name, !diags.HasError(), diags)
+		}
+	}
+}
+
+func TestGetDirWithSource(t *testing.T) {
+	r := resourceImage{sourceDir: filepath.Join("cache", "entry")}
+	if got, want := r.getDir(types.StringValue("ubuntu")), filepath.Join("cache", "entry", "ubuntu"); got != want {
+		t.Errorf("getDir = %s, want %s", got, want)
+	}
+	if got, want := r.getDir(types.StringNull()), filepath.Join("cache", "entry"); got != want {
+		t.Errorf("getDir without directory = %s, want %s", got, want)
+	}
+}
+
+func TestTemplateSourcesFollowCacheDir(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "ci", "packer-binaries")
+	config := providerConfig(t, map[string]tftypes.Value{
+		"packer_binary_cache_dir": tftypes.NewValue(tftypes.String, cacheDir),
+	})
+	var resp provider.ConfigureResponse
+	(&tfProvider{}).Configure(context.Background(), provider.ConfigureRequest{Config: config}, &resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+	want := filepath.Join(cacheDir, "template-sources")
+	if got := resp.ResourceData.(*providerData).sources.cacheDir; got != want {
+		t.Errorf("template sources are cached in %s, want %s inside packer_binary_cache_dir", got, want)
+	}
+}
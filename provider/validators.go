@@ -3,6 +3,8 @@ package provider
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
 	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
@@ -42,6 +44,33 @@ func (n NonEmptyStringValidator) ValidateString(ctx context.Context, request val
 	}
 }
 
+// OneOfStringValidator checks that a string is one of Values.
+type OneOfStringValidator struct {
+	Values []string
+}
+
+func (o OneOfStringValidator) Description(_ context.Context) string {
+	return fmt.Sprintf("Checks if the given string is one of %s.", strings.Join(o.Values, ", "))
+}
+
+func (o OneOfStringValidator) MarkdownDescription(ctx context.Context) string {
+	return o.Description(ctx)
+}
+
+func (o OneOfStringValidator) ValidateString(_ context.Context, request validator.StringRequest, response *validator.StringResponse) {
+	if request.ConfigValue.IsUnknown() || request.ConfigValue.IsNull() {
+		return
+	}
+	if !slices.Contains(o.Values, request.ConfigValue.ValueString()) {
+		response.Diagnostics.AddAttributeError(
+			request.Path,
+			"Invalid value",
+			fmt.Sprintf("Value must be one of %s, got %q.", strings.Join(o.Values, ", "), request.ConfigValue.ValueString()),
+		)
+	}
+}
+
 var (
 	_ validator.String = (*NonEmptyStringValidator)(nil)
+	_ validator.String = (*OneOfStringValidator)(nil)
 )